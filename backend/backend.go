@@ -4,12 +4,15 @@
 package backend
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"log"
 
 	"github.com/cryptag/cryptag"
 	"github.com/cryptag/cryptag/types"
+	"github.com/cryptag/go-minilock/taber"
 	"github.com/elimisteve/fun"
 )
 
@@ -43,26 +46,41 @@ type Backend interface {
 // pairs.  (Be sure that pairs contains the latest TagPairs contained
 // in backend.)
 func CreateTagsFromPlain(bk Backend, plaintags []string, pairs types.TagPairs) (newPairs types.TagPairs, err error) {
+	index, err := blindIndex(bk)
+	if err != nil {
+		return nil, err
+	}
+	return createTagsFromPlain(bk, bk.Key(), plaintags, pairs, index)
+}
+
+// createTagsFromPlain is CreateTagsFromPlain but encrypts the new
+// TagPairs with key and computes blind RandomTags with index if it's
+// non-nil.
+func createTagsFromPlain(bk Backend, key *[32]byte, plaintags []string, pairs types.TagPairs, index []byte) (newPairs types.TagPairs, err error) {
 	// Find out which members of plaintags don't have an existing,
 	// corresponding TagPair
 
-	existingPlain := pairs.AllPlain()
+	existing := types.IndexTagPairs(pairs)
 
 	// Concurrent Tag creation ftw
 	var chs []chan *types.TagPair
 
 	// TODO: Put the following in a `CreateTags` function
 
+	// Don't create duplicate TagPairs if plaintags repeats a tag
+	creating := map[string]bool{}
+
 	for _, plain := range plaintags {
-		if !fun.SliceContains(existingPlain, plain) {
+		if len(existing.ByPlain(plain)) == 0 && !creating[plain] {
+			creating[plain] = true
 			// Preserve tag ordering despite concurrent creation
 			ch := make(chan *types.TagPair)
 			chs = append(chs, ch)
 
 			go func(plain string, ch chan *types.TagPair) {
-				pair, err := CreateTag(bk, plain)
+				pair, err := createTag(bk, key, plain, index, existing)
 				if err != nil {
-					log.Printf("Error calling CreateTag(%q): %v\n", plain, err)
+					log.Printf("Error calling createTag(%q): %v\n", plain, err)
 					ch <- nil
 					return
 				}
@@ -93,6 +111,12 @@ func CreateTagsFromPlain(bk Backend, plaintags []string, pairs types.TagPairs) (
 // nonce, encrypts the PlainTag, then creates and returns the newly
 // allocated TagPair.
 func NewTagPair(key *[32]byte, plaintag string) (*types.TagPair, error) {
+	return NewTagPairWithCipher(key, plaintag, "")
+}
+
+// NewTagPairWithCipher is like NewTagPair but encrypts the PlainTag
+// with the cryptag.Cipher called cipherName.
+func NewTagPairWithCipher(key *[32]byte, plaintag, cipherName string) (*types.TagPair, error) {
 	rand := fun.RandomString(RANDOM_TAG_ALPHABET, RANDOM_TAG_LENGTH)
 
 	nonce, err := cryptag.RandomNonce()
@@ -100,12 +124,13 @@ func NewTagPair(key *[32]byte, plaintag string) (*types.TagPair, error) {
 		return nil, err
 	}
 
-	plainEnc, err := cryptag.Encrypt([]byte(plaintag), nonce, key)
+	plainEnc, err := cryptag.EncryptWithCipher(cipherName, []byte(plaintag), nonce, key)
 	if err != nil {
 		return nil, err
 	}
 
 	pair := types.NewTagPair(plainEnc, rand, nonce, plaintag)
+	pair.KeyID = cryptag.KeyID(key)
 
 	return pair, nil
 }
@@ -113,10 +138,29 @@ func NewTagPair(key *[32]byte, plaintag string) (*types.TagPair, error) {
 // CreateTag uses NewTagPair to create a new TagPair, then saves said
 // TagPair in backend.
 func CreateTag(bk Backend, plaintag string) (*types.TagPair, error) {
-	pair, err := NewTagPair(bk.Key(), plaintag)
+	index, err := blindIndex(bk)
 	if err != nil {
 		return nil, err
 	}
+	return createTag(bk, bk.Key(), plaintag, index, nil)
+}
+
+// createTag creates and saves a TagPair for plaintag, encrypted with
+// key, whose RandomTag is its blind index under index, if index isn't
+// nil, or else a new RandomTag that neither known (which may be nil)
+// nor bk has.
+func createTag(bk Backend, key *[32]byte, plaintag string, index []byte, known *types.TagPairIndex) (*types.TagPair, error) {
+	pair, err := NewTagPairWithCipher(key, plaintag, GetSettings(bk).Cipher)
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		pair.Random = BlindRandomTag(index, plaintag)
+	} else {
+		if pair.Random, err = uniqueRandomTag(bk, known); err != nil {
+			return nil, err
+		}
+	}
 
 	err = bk.SaveTagPair(pair)
 	if err != nil {
@@ -131,43 +175,182 @@ func CreateTag(bk Backend, plaintag string) (*types.TagPair, error) {
 // unique to row, sets row.RandomTags, and sets row.Encrypted.  row is
 // now ready to be saved to a Backend.
 func PopulateRowBeforeSave(bk Backend, row *types.Row, pairs types.TagPairs) (newPairs types.TagPairs, err error) {
+	return populateRowBeforeSave(bk, row, pairs, nil)
+}
+
+// populateRowBeforeSave is PopulateRowBeforeSave but seals row to
+// recipients if there are any.
+func populateRowBeforeSave(bk Backend, row *types.Row, pairs types.TagPairs, recipients []*taber.Keys) (newPairs types.TagPairs, err error) {
 	// For each element of row.plainTags that doesn't match an
 	// existing tag, call CreateTag().  Encrypt row.decrypted and
 	// store it in row.Encrypted.  POST to server.
 
-	// TODO: Call this in parallel with encryption below
-	newPairs, err = CreateTagsFromPlain(bk, row.PlainTags(), pairs)
+	index, err := blindIndex(bk)
 	if err != nil {
-		return newPairs, fmt.Errorf("Error from CreateNewTagsFromPlain: %v", err)
+		return nil, err
 	}
 
-	allTagPairs := append(pairs, newPairs...)
+	if index != nil {
+		// RandomTags computed locally; only TagPairs that don't
+		// exist yet need creating
+		newPairs, err = createBlindTags(bk, bk.Key(), index, row.PlainTags(), pairs)
+		if err != nil {
+			return newPairs, fmt.Errorf("Error creating blind tags: %v", err)
+		}
+		row.RandomTags = blindRandomTags(index, row.PlainTags())
+	} else {
+		// TODO: Call this in parallel with encryption below
+		newPairs, err = CreateTagsFromPlain(bk, row.PlainTags(), pairs)
+		if err != nil {
+			return newPairs, fmt.Errorf("Error from CreateNewTagsFromPlain: %v", err)
+		}
+
+		known := types.IndexTagPairs(pairs)
+		created := types.NewTagPairIndex(newPairs)
 
-	var randtags []string
+		var randtags []string
 
-	// Set row.RandomTags
+		// Set row.RandomTags
 
-	for _, plain := range row.PlainTags() {
-		for i, pair := range allTagPairs {
-			if plain == pair.Plain() {
-				randtags = append(randtags, pair.Random)
-				break
+		for _, plain := range row.PlainTags() {
+			matches := known.ByPlain(plain)
+			if len(matches) == 0 {
+				matches = created.ByPlain(plain)
 			}
-			if i == len(allTagPairs)-1 {
+			if len(matches) == 0 {
 				return newPairs, fmt.Errorf(
 					"No corresponding TagPair found for plain tag `%s`", plain)
 			}
+			randtags = append(randtags, matches[0].Random)
 		}
+		row.RandomTags = randtags
+	}
+
+	row.RandomTags, err = hideTagStructure(bk, row.IDTag(), row.RandomTags)
+	if err != nil {
+		return newPairs, err
+	}
+	row.KeyID = cryptag.KeyID(bk.Key())
+	if len(recipients) > 0 {
+		// Not encrypted with any Backend key
+		row.KeyID = ""
+	}
+
+	// Streamed Rows' data is encrypted separately (and, since
+	// they're never created when signing, not signed); see
+	// CreateRowFromReader
+	if row.Streamed {
+		return newPairs, nil
 	}
-	row.RandomTags = randtags
 
 	// Set row.Encrypted
 
-	encData, err := cryptag.Encrypt(row.Decrypted(), row.Nonce, bk.Key())
+	opts, err := GetSettings(bk).RowOptions()
 	if err != nil {
+		return newPairs, err
+	}
+	opts.Recipients = recipients
+
+	if err = row.EncryptWithOptions(bk.Key(), opts); err != nil {
 		return newPairs, fmt.Errorf("Error encrypting data: %v", err)
 	}
-	row.Encrypted = encData
+
+	if GetSettings(bk).Sign {
+		if err = signRow(row); err != nil {
+			return newPairs, err
+		}
+	}
 
 	return newPairs, nil
 }
+
+// signRow signs row with the identity loaded by UseIdentity.
+func signRow(row *types.Row) error {
+	key := signingKey()
+	if key == nil {
+		return ErrNoSigningKey
+	}
+	return row.Sign(key)
+}
+
+// SignedByOthersError is returned by functions that re-encrypt or
+// re-tag Rows when some of them are signed by other users.  Re-saving
+// those Rows would break their signatures, which only their authors
+// can make again, so they're left as they are.
+type SignedByOthersError struct {
+	RandomTags []cryptag.RandomTags // Of each Row left as is
+}
+
+func (e *SignedByOthersError) Error() string {
+	return fmt.Sprintf("%d Rows signed by other users were left as they are,"+
+		" since re-saving them would remove their signatures", len(e.RandomTags))
+}
+
+// signedByOthers reports whether row is signed by someone other than
+// this user (see UseIdentity).
+func signedByOthers(row *types.Row) bool {
+	if !row.IsSigned() {
+		return false
+	}
+	key := signingKey()
+	return key == nil || !bytes.Equal(row.Signer, key.Public().(ed25519.PublicKey))
+}
+
+// checkSignedByOthers returns a *SignedByOthersError if any of rows
+// are signed by other users.
+func checkSignedByOthers(rows types.Rows) error {
+	var randtags []cryptag.RandomTags
+	for _, row := range rows {
+		if signedByOthers(row) {
+			randtags = append(randtags, row.RandomTags)
+		}
+	}
+	if len(randtags) > 0 {
+		return &SignedByOthersError{RandomTags: randtags}
+	}
+	return nil
+}
+
+// SealedToOthersError is returned by functions that re-tag Rows when
+// some of them are sealed to other users (see CreateRowForRecipients).
+// Re-saving a Row under new RandomTags means re-encrypting it, which
+// takes its data, which only its recipients can read, so they're left
+// as they are for one of them to re-tag.
+type SealedToOthersError struct {
+	RandomTags []cryptag.RandomTags // Of each Row left as is
+}
+
+func (e *SealedToOthersError) Error() string {
+	return fmt.Sprintf("%d Rows sealed to other users can't be re-tagged"+
+		" by this user", len(e.RandomTags))
+}
+
+// checkSealedToOthers returns a *SealedToOthersError if any of rows
+// are sealed to other users.
+func checkSealedToOthers(rows types.Rows) error {
+	var randtags []cryptag.RandomTags
+	for _, row := range rows {
+		if !row.IsRecipient() {
+			randtags = append(randtags, row.RandomTags)
+		}
+	}
+	if len(randtags) > 0 {
+		return &SealedToOthersError{RandomTags: randtags}
+	}
+	return nil
+}
+
+// resignRow replaces row's signature, which this user made but no
+// longer matches row (e.g., after re-encryption), with a new one.
+// Rows signed by others can't be re-signed (see SignedByOthersError).
+func resignRow(row *types.Row) error {
+	if !row.IsSigned() {
+		return nil
+	}
+	if signedByOthers(row) {
+		return &SignedByOthersError{RandomTags: []cryptag.RandomTags{row.RandomTags}}
+	}
+
+	row.Signer, row.Signature = nil, nil
+	return row.Sign(signingKey())
+}
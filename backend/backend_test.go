@@ -0,0 +1,87 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// TestMain keeps Configs, rotation state, search indexes, and the
+// like that the tests save out of the user's real ~/.cryptag, and
+// makes locking Configs fast.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "cryptag-backend-test")
+	if err != nil {
+		panic(err)
+	}
+
+	cryptag.TrustedBasePath = dir
+	cryptag.BackendPath = path.Join(dir, "backends")
+	cryptag.LocalDataPath = dir
+	SearchIndexDir = path.Join(dir, "search")
+
+	// Locking Configs needn't be slow
+	ScryptN = 1 << 10
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestMemory returns a new Memory backend named after t, whose
+// Config has been saved so that functions that update it (e.g.,
+// RotateKey) can.
+func newTestMemory(t *testing.T, settings Settings) *Memory {
+	cfg := &Config{Name: t.Name(), Type: TypeMemory, Settings: settings}
+
+	m, err := NewMemory(cfg)
+	if err != nil {
+		t.Fatalf("Error creating Memory backend: %v", err)
+	}
+
+	if err = cfg.Save(cryptag.BackendPath); err != nil {
+		t.Fatalf("Error saving Memory backend's Config: %v", err)
+	}
+
+	return m
+}
+
+// reloadConfig gives m the keys and Settings in its Config as saved
+// to disk, as loading it again would, but without losing its
+// contents.
+func reloadConfig(t *testing.T, m *Memory) {
+	cfg, err := ReadConfig(cryptag.BackendPath, m.Name())
+	if err != nil {
+		t.Fatalf("Error reading Config: %v", err)
+	}
+
+	m.key, m.oldKeys, m.settings = cfg.Key, cfg.OldKeys, cfg.Settings
+}
+
+// mustCreateRow creates a Row in bk or fails t.
+func mustCreateRow(t *testing.T, bk Backend, pairs types.TagPairs, data string, plaintags ...string) *types.Row {
+	row, err := CreateRow(bk, pairs, []byte(data), plaintags)
+	if err != nil {
+		t.Fatalf("Error creating Row tagged %v: %v", plaintags, err)
+	}
+	return row
+}
+
+// decryptedData returns the data of each of rows, sorted.
+func decryptedData(rows types.Rows) []string {
+	data := make([]string, 0, len(rows))
+	for _, row := range rows {
+		data = append(data, string(row.Decrypted()))
+	}
+	sort.Strings(data)
+	return data
+}
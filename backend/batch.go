@@ -0,0 +1,160 @@
+// Steve Phillips / elimisteve
+// 2017.04.19
+
+package backend
+
+import (
+	"strings"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// BatchBackend is implemented by Backends that can fetch several
+// specific Rows at once, so that clients can list Rows (see ListRows)
+// then fetch the data of just the ones they need.
+type BatchBackend interface {
+	// RowsByIDs returns, with their data, the Rows having any of
+	// idRandTags, the RandomTags of their "id:..." tags
+	RowsByIDs(idRandTags cryptag.RandomTags) (types.Rows, error)
+}
+
+// RowsByIDsBatchSize is the max number of Rows that RowsByIDs asks a
+// BatchBackend for at once.
+var RowsByIDsBatchSize = 100
+
+// RowsByIDs fetches the Rows with the given IDs ("id:..." tags, or
+// just the part after "id:"), in that order.  IDs with no
+// corresponding Row are skipped; if none have one, ErrRowsNotFound is
+// returned.  If pairs is nil, the TagPairs needed are fetched from bk.
+//
+// Backends that aren't BatchBackends are queried once for each ID,
+// or (if they're QueryBackends) once for every ID at once.
+func RowsByIDs(bk Backend, pairs types.TagPairs, ids []string) (types.Rows, error) {
+	idTags := make([]string, len(ids))
+	for i, id := range ids {
+		if !strings.HasPrefix(id, "id:") {
+			id = "id:" + id
+		}
+		idTags[i] = id
+	}
+
+	index, err := blindIndex(bk)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil && pairs == nil {
+		if pairs, err = bk.AllTagPairs(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var randtags cryptag.RandomTags
+	if index != nil {
+		randtags = blindRandomTags(index, idTags)
+	} else {
+		pairIndex := types.IndexTagPairs(pairs)
+		for _, idTag := range idTags {
+			randtags = append(randtags, pairIndex.ByPlain(idTag).AllRandom()...)
+		}
+	}
+
+	// Fetch each Row once, even if its ID was given more than once
+	seen := make(map[string]bool, len(randtags))
+	unique := randtags[:0]
+	for _, random := range randtags {
+		if !seen[random] {
+			seen[random] = true
+			unique = append(unique, random)
+		}
+	}
+	randtags = unique
+
+	if len(randtags) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	rows, err := fetchRowsByIDs(bk, randtags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip Rows sealed to other users
+	rows = rows.Readable()
+
+	if len(rows) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	if index != nil {
+		err = populateRowsBlind(bk, rows)
+	} else {
+		err = rows.PopulateWithKeyRing(KeyRing(bk), pairs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return orderByIDs(rows, idTags), nil
+}
+
+// fetchRowsByIDs fetches the Rows having any of idRandTags, in
+// batches if bk is a BatchBackend.
+func fetchRowsByIDs(bk Backend, idRandTags cryptag.RandomTags) (types.Rows, error) {
+	if bbk, ok := bk.(BatchBackend); ok {
+		var rows types.Rows
+		for len(idRandTags) > 0 {
+			n := len(idRandTags)
+			if RowsByIDsBatchSize > 0 && n > RowsByIDsBatchSize {
+				n = RowsByIDsBatchSize
+			}
+			batch, err := bbk.RowsByIDs(idRandTags[:n])
+			if err != nil && err != types.ErrRowsNotFound {
+				return nil, err
+			}
+			rows = append(rows, batch...)
+			idRandTags = idRandTags[n:]
+		}
+		return rows, nil
+	}
+
+	if qbk, ok := bk.(QueryBackend); ok {
+		rows, err := qbk.RowsFromRandomQuery(&RandomQuery{Op: OpTag,
+			RandomTags: idRandTags})
+		if err != nil && err != types.ErrRowsNotFound {
+			return nil, err
+		}
+		return rows, nil
+	}
+
+	var rows types.Rows
+	for _, random := range idRandTags {
+		matches, err := bk.RowsFromRandomTags(cryptag.RandomTags{random})
+		if err != nil && err != types.ErrRowsNotFound {
+			return nil, err
+		}
+		rows = append(rows, matches...)
+	}
+	return rows, nil
+}
+
+// orderByIDs returns rows (which must be populated) in the order of
+// idTags, with one Row per ID.
+func orderByIDs(rows types.Rows, idTags []string) types.Rows {
+	byID := make(map[string]*types.Row, len(rows))
+	for _, row := range rows {
+		idTag := row.IDTag()
+		if _, ok := byID[idTag]; !ok && idTag != "" {
+			byID[idTag] = row
+		}
+	}
+
+	ordered := make(types.Rows, 0, len(byID))
+	for _, idTag := range idTags {
+		if row, ok := byID[idTag]; ok {
+			ordered = append(ordered, row)
+			delete(byID, idTag)
+		}
+	}
+	return ordered
+}
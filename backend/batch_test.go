@@ -0,0 +1,90 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchCountingBackend records the size of each batch of Rows it's
+// asked for.
+type batchCountingBackend struct {
+	*Memory
+	batches []int
+}
+
+func (bk *batchCountingBackend) RowsByIDs(idRandTags cryptag.RandomTags) (types.Rows, error) {
+	bk.batches = append(bk.batches, len(idRandTags))
+	return bk.Memory.RowsByIDs(idRandTags)
+}
+
+// createIDRows creates n Rows whose data is "a", "b", and so on, and returns
+// their IDs.
+func createIDRows(t *testing.T, bk Backend, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		row := mustCreateRow(t, bk, nil, string(rune('a'+i)), "batch")
+		ids[i] = strings.TrimPrefix(row.IDTag(), "id:")
+	}
+	return ids
+}
+
+func TestRowsByIDsOrder(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	ids := createIDRows(t, m, 4)
+
+	// In the order given, once each, with or without "id:", skipping
+	// IDs without Rows
+	rows, err := RowsByIDs(m, nil, []string{ids[2], "id:" + ids[0], "nope",
+		ids[2], ids[3], "id:" + ids[0]})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"c", "a", "d"}, dataInOrder(rows))
+	}
+
+	_, err = RowsByIDs(m, nil, []string{"nope"})
+	assert.Equal(t, types.ErrRowsNotFound, err)
+}
+
+func TestRowsByIDsFallback(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	ids := createIDRows(t, m, 3)
+
+	// Neither a BatchBackend nor a QueryBackend, so each ID is fetched
+	// on its own
+	bk := &countingBackend{Backend: m}
+	rows, err := RowsByIDs(bk, nil, []string{ids[1], ids[0], ids[1]})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"b", "a"}, dataInOrder(rows))
+		assert.Equal(t, 2, bk.fetches)
+	}
+}
+
+func TestRowsByIDsBatches(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	ids := createIDRows(t, m, 7)
+
+	defer func(size int) { RowsByIDsBatchSize = size }(RowsByIDsBatchSize)
+	RowsByIDsBatchSize = 3
+
+	bk := &batchCountingBackend{Memory: m}
+	rows, err := RowsByIDs(bk, nil, ids)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, dataInOrder(rows))
+		assert.Equal(t, []int{3, 3, 1}, bk.batches)
+	}
+
+	// No limit
+	RowsByIDsBatchSize = 0
+	bk.batches = nil
+	rows, err = RowsByIDs(bk, nil, ids)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 7, len(rows))
+		assert.Equal(t, []int{7}, bk.batches)
+	}
+}
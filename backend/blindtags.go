@@ -0,0 +1,448 @@
+// Steve Phillips / elimisteve
+// 2017.04.10
+
+package backend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// Tag modes.  In TagModeRandom, each TagPair's RandomTag is random,
+// so mapping PlainTags to RandomTags requires fetching (and
+// decrypting) every TagPair from the Backend.  In TagModeHMAC, each
+// RandomTag is a keyed hash (a "blind index") of its PlainTag, so
+// clients compute RandomTags locally and only fetch the TagPairs of
+// the Rows they get back.
+const (
+	TagModeRandom = ""
+	TagModeHMAC   = "hmac"
+)
+
+// BlindTagLength is the length of the RandomTags used in TagModeHMAC.
+// Longer than RANDOM_TAG_LENGTH since collisions can't be retried.
+const BlindTagLength = 16
+
+var (
+	ErrTagPairDeleteNotSupported = errors.New("Backend doesn't support" +
+		" deleting TagPairs")
+	ErrAlreadyBlindTags = errors.New("Backend already uses blind (HMAC) tags")
+)
+
+// TagPairDeleter is implemented by Backends that can delete TagPairs.
+type TagPairDeleter interface {
+	DeleteTagPairs(randtags cryptag.RandomTags) error
+}
+
+// blindIndexKey derives the key that blind RandomTags are computed
+// with from key (a Backend key), so that the server can't compute
+// them even if it learns what PlainTags are in use.
+func blindIndexKey(key *[32]byte) []byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("cryptag blind index key"))
+	return mac.Sum(nil)
+}
+
+// BlindRandomTag returns the RandomTag that plaintag maps to under
+// indexKey in TagModeHMAC.
+func BlindRandomTag(indexKey []byte, plaintag string) string {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(plaintag))
+	sum := mac.Sum(nil)
+
+	// Base 36 to match RANDOM_TAG_ALPHABET
+	s := new(big.Int).SetBytes(sum).Text(36)
+	for len(s) < BlindTagLength {
+		s = "0" + s
+	}
+	return s[len(s)-BlindTagLength:]
+}
+
+func blindRandomTags(indexKey []byte, plaintags []string) cryptag.RandomTags {
+	randtags := make(cryptag.RandomTags, len(plaintags))
+	for i, plain := range plaintags {
+		randtags[i] = BlindRandomTag(indexKey, plain)
+	}
+	return randtags
+}
+
+// blindIndex returns the blind index key bk's RandomTags are computed
+// with, or nil if bk doesn't use TagModeHMAC.
+func blindIndex(bk Backend) ([]byte, error) {
+	s := GetSettings(bk)
+	if s.TagMode != TagModeHMAC {
+		return nil, nil
+	}
+
+	key := KeyRing(bk).Get(s.TagKeyID)
+	if key == nil {
+		return nil, cryptag.ErrKeyNotFound{KeyID: s.TagKeyID}
+	}
+
+	return blindIndexKey(key), nil
+}
+
+// UsesBlindTags reports whether bk's RandomTags are blind indexes (see
+// TagModeHMAC), in which case fetching all TagPairs is unnecessary.
+func UsesBlindTags(bk Backend) bool {
+	return GetSettings(bk).TagMode == TagModeHMAC
+}
+
+// RandomTagsFromPlain returns the RandomTags that plaintags map to in
+// bk, which must use TagModeHMAC, without contacting bk.
+func RandomTagsFromPlain(bk Backend, plaintags cryptag.PlainTags) (cryptag.RandomTags, error) {
+	index, err := blindIndex(bk)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return nil, fmt.Errorf("Backend `%s` doesn't use blind tags", bk.Name())
+	}
+	return blindRandomTags(index, plaintags), nil
+}
+
+// tagPairsIfNeeded returns pairs, or all of bk's TagPairs if pairs is
+// nil and bk needs them to map PlainTags to RandomTags.
+func tagPairsIfNeeded(bk Backend, pairs types.TagPairs) (types.TagPairs, error) {
+	if pairs != nil || UsesBlindTags(bk) {
+		return pairs, nil
+	}
+	return bk.AllTagPairs(nil)
+}
+
+// createBlindTags creates and saves the TagPairs, encrypted with key,
+// for each of plaintags that bk doesn't already have.
+func createBlindTags(bk Backend, key *[32]byte, index []byte, plaintags []string, pairs types.TagPairs) (types.TagPairs, error) {
+	known := types.IndexTagPairs(pairs)
+
+	var unknown cryptag.RandomTags
+	for _, plain := range plaintags {
+		random := BlindRandomTag(index, plain)
+		if !known.HasRandom(random) {
+			unknown = append(unknown, random)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+
+	existing, err := bk.TagPairsFromRandomTags(unknown)
+	if err != nil && types.Debug {
+		log.Printf("createBlindTags: error fetching existing TagPairs: %v\n", err)
+	}
+
+	var missing []string
+	for _, plain := range plaintags {
+		random := BlindRandomTag(index, plain)
+		if !known.HasRandom(random) && !existing.HasRandom(random) {
+			missing = append(missing, plain)
+		}
+	}
+
+	// Blind RandomTags can't be re-generated, so at least don't let
+	// two PlainTags share one
+	for _, pair := range append(existing, known.Pairs()...) {
+		for _, plain := range plaintags {
+			if pair.Random == BlindRandomTag(index, plain) && pair.Plain() != "" &&
+				pair.Plain() != plain {
+				return nil, fmt.Errorf("Blind RandomTag of `%s` collides with that of `%s`",
+					plain, pair.Plain())
+			}
+		}
+	}
+
+	return createTagsFromPlain(bk, key, missing, nil, index)
+}
+
+// getRowsBlind is getRows for Backends using TagModeHMAC.
+func getRowsBlind(bk Backend, index []byte, plaintags cryptag.PlainTags, fetchByRandom func(cryptag.RandomTags) (types.Rows, error)) (types.Rows, error) {
+	rows, err := fetchByRandom(blindRandomTags(index, plaintags))
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip Rows sealed to other users
+	rows = rows.Readable()
+
+	if len(rows) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	if err = populateRowsBlind(bk, rows); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// populateRowsBlind populates rows, fetching just the TagPairs they
+// need from bk, which uses TagModeHMAC.
+func populateRowsBlind(bk Backend, rows types.Rows) error {
+	// Only fetch the TagPairs of the Rows found
+	var randtags cryptag.RandomTags
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for _, random := range row.RandomTags {
+			if !seen[random] {
+				seen[random] = true
+				randtags = append(randtags, random)
+			}
+		}
+	}
+
+	pairs, err := bk.TagPairsFromRandomTags(randtags)
+	if err != nil {
+		return err
+	}
+
+	return rows.PopulateWithKeyRing(KeyRing(bk), pairs)
+}
+
+// MigrateToBlindTags switches bk from random RandomTags to blind ones
+// (see TagModeHMAC), deriving the blind index key from bk's current
+// key.  Every TagPair is replaced with one whose RandomTag is a blind
+// index, and every Row is re-saved (and re-encrypted, since its
+// envelope commits to its RandomTags) under its new RandomTags and
+// its old copy deleted.  Finally bk's updated Config is saved to
+// cryptag.BackendPath.  If any Rows are sealed to or signed by other
+// users, nothing is migrated and a *SealedToOthersError or
+// *SignedByOthersError, respectively, is returned.
+//
+// bk must support deleting Rows and TagPairs.  If interrupted, call
+// again to resume; Rows and TagPairs already migrated are skipped.
+// bk itself keeps its old settings; load it again afterward.
+func MigrateToBlindTags(bk Backend) error {
+	if UsesBlindTags(bk) {
+		return ErrAlreadyBlindTags
+	}
+	return rekeyBlindTags(bk, bk.Key())
+}
+
+// RekeyBlindTags moves bk, which must use TagModeHMAC, to a blind
+// index key derived from bk's current key, as MigrateToBlindTags
+// would, such as to finish a RemoveTeamMember that was interrupted.
+// Also moves Rows that members who hadn't yet run SyncTeamKeys saved
+// under the old blind index key.
+func RekeyBlindTags(bk Backend) error {
+	if !UsesBlindTags(bk) {
+		return fmt.Errorf("Backend `%s` doesn't use blind tags", bk.Name())
+	}
+	return rekeyBlindTags(bk, bk.Key())
+}
+
+// rekeyBlindTags moves every TagPair and Row in bk to blind
+// RandomTags computed with the blind index key derived from key,
+// re-encrypting them with key (which bk itself may not be using yet),
+// then saves bk's updated Config (with key added to its key ring, if need
+// be) and, if bk has a team, tells the team which key to use.
+func rekeyBlindTags(bk Backend, key *[32]byte) error {
+	if key == nil {
+		return cryptag.ErrNilKey
+	}
+
+	if err := reindexBlindTags(bk, key); err != nil {
+		return err
+	}
+
+	cfg, err := bk.ToConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Key == nil || *cfg.Key != *key {
+		cfg.AddKey(key)
+	}
+	cfg.TagMode = TagModeHMAC
+	cfg.TagKeyID = cryptag.KeyID(key)
+
+	if err = cfg.Update(cryptag.BackendPath); err != nil {
+		return err
+	}
+
+	// Other members need the new blind index key, too
+	team, err := GetTeam(bk)
+	if err == ErrNoTeam {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error fetching team: %v", err)
+	}
+	team.TagKeyID = cfg.TagKeyID
+
+	return saveTeam(bk, team)
+}
+
+// reindexBlindTags replaces every TagPair in bk whose RandomTag isn't
+// its blind index under the blind index key derived from key with one
+// whose is, and moves every Row to its new RandomTags, encrypting both
+// with key.  TagPairs and Rows already moved are skipped.
+func reindexBlindTags(bk Backend, key *[32]byte) error {
+	deleter, ok := bk.(TagPairDeleter)
+	if !ok {
+		return ErrTagPairDeleteNotSupported
+	}
+
+	index := blindIndexKey(key)
+
+	pairs, err := bk.AllTagPairs(nil)
+	if err != nil {
+		return fmt.Errorf("Error fetching TagPairs: %v", err)
+	}
+
+	// Create blind TagPairs for every PlainTag
+
+	var plaintags, oldRandom []string
+	var allRandom cryptag.RandomTags
+	for _, pair := range pairs {
+		if pair.Random == BlindRandomTag(index, pair.Plain()) {
+			continue
+		}
+		oldRandom = append(oldRandom, pair.Random)
+		plaintags = append(plaintags, pair.Plain())
+		// "all" may have duplicate TagPairs
+		if pair.Plain() == "all" {
+			allRandom = append(allRandom, pair.Random)
+		}
+	}
+
+	rows, err := rowsWithAnyRandomTag(bk, allRandom)
+	if err != nil {
+		return fmt.Errorf("Error fetching Rows: %v", err)
+	}
+
+	// Don't move any Rows unless all of them can be read and
+	// re-signed
+	if err = checkSealedToOthers(rows); err != nil {
+		return err
+	}
+	if err = checkSignedByOthers(rows); err != nil {
+		return err
+	}
+
+	newPairs, err := createBlindTags(bk, key, index, plaintags, pairs)
+	if err != nil {
+		return fmt.Errorf("Error creating blind TagPairs: %v", err)
+	}
+	if types.Debug {
+		log.Printf("reindexBlindTags: created %d new TagPairs\n", len(newPairs))
+	}
+
+	// Move each Row not yet moved
+
+	for _, row := range rows {
+		if err = moveRowToBlindTags(bk, key, row, index, pairs); err != nil {
+			return fmt.Errorf("Error moving Row with RandomTags %v: %v",
+				row.RandomTags, err)
+		}
+	}
+
+	if types.Debug {
+		log.Printf("reindexBlindTags: moved %d Rows\n", len(rows))
+	}
+
+	// Remove old TagPairs
+
+	if len(oldRandom) > 0 {
+		if err = deleter.DeleteTagPairs(oldRandom); err != nil {
+			return fmt.Errorf("Error deleting old TagPairs: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// moveRowToBlindTags re-saves row, encrypted with key, under the blind
+// RandomTags of its PlainTags then deletes the original.
+func moveRowToBlindTags(bk Backend, key *[32]byte, row *types.Row, index []byte, pairs types.TagPairs) error {
+	if err := row.PopulateWithKeyRing(KeyRing(bk), pairs); err != nil {
+		return err
+	}
+
+	randtags, err := hideTagStructure(bk, row.IDTag(),
+		blindRandomTags(index, row.PlainTags()))
+	if err != nil {
+		return err
+	}
+
+	return moveRow(bk, key, row, row.PlainTags(), randtags)
+}
+
+// moveRow re-saves row, which must be populated, with the given
+// PlainTags and RandomTags, encrypted with key (unless it's sealed to
+// recipients), then deletes the original.
+func moveRow(bk Backend, key *[32]byte, row *types.Row, plaintags []string, randtags cryptag.RandomTags) error {
+	newRow, err := types.NewRowSimple(row.Decrypted(), plaintags)
+	if err != nil {
+		return err
+	}
+	newRow.RandomTags = randtags
+	newRow.KeyID = row.KeyID
+
+	if row.Streamed {
+		newRow.Streamed = true
+		newRow.KeyID = cryptag.KeyID(key)
+		err = copyRowStream(bk, key, row, newRow)
+	} else {
+		var opts types.RowOptions
+		if opts, err = GetSettings(bk).RowOptions(); err != nil {
+			return err
+		}
+		if row.HasRecipients() {
+			err = newRow.EncryptForSameRecipients(row, opts)
+		} else {
+			newRow.KeyID = cryptag.KeyID(key)
+			err = newRow.EncryptWithOptions(key, opts)
+		}
+		if err != nil {
+			return err
+		}
+		// Re-sign this user's own Rows; refuse to drop others'
+		// signatures
+		newRow.Signer, newRow.Signature = row.Signer, row.Signature
+		if err = resignRow(newRow); err != nil {
+			return err
+		}
+		err = bk.SaveRow(newRow)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bk.DeleteRows(row.RandomTags)
+}
+
+// copyRowStream saves the (verified) data of streamed Row src as the
+// data of streamed Row dst, encrypted with key.
+func copyRowStream(bk Backend, key *[32]byte, src, dst *types.Row) error {
+	sbk, ok := bk.(StreamBackend)
+	if !ok {
+		return ErrStreamingNotSupported
+	}
+
+	rc, err := OpenRowStream(bk, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		_, err := dst.EncryptStream(pw, rc, key)
+		pw.CloseWithError(err)
+	}()
+
+	if err = sbk.SaveRowStream(dst, pr); err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateToBlindTagsSealedToOthers(t *testing.T) {
+	useTestIdentity(t)
+	m := newTestMemory(t, Settings{})
+
+	mustCreateRow(t, m, nil, "mine", "shared")
+
+	other, err := taber.RandomKey()
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	sealed, err := CreateRowForRecipients(m, nil, []byte("theirs"),
+		[]string{"shared"}, []*taber.Keys{other})
+	if err != nil {
+		t.Fatalf("Error creating sealed Row: %v", err)
+	}
+
+	// Nothing is moved...
+	err = MigrateToBlindTags(m)
+	if assert.IsType(t, &SealedToOthersError{}, err) {
+		assert.Equal(t, 1, len(err.(*SealedToOthersError).RandomTags))
+		assert.ElementsMatch(t, sealed.RandomTags, err.(*SealedToOthersError).RandomTags[0])
+	}
+
+	reloadConfig(t, m)
+	assert.False(t, UsesBlindTags(m))
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"mine"}, decryptedData(rows))
+	}
+
+	// ...until the sealed Row is gone
+	assert.Nil(t, m.DeleteRows(sealed.RandomTags))
+	assert.Nil(t, MigrateToBlindTags(m))
+
+	reloadConfig(t, m)
+	assert.True(t, UsesBlindTags(m))
+
+	rows, err = RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"mine"}, decryptedData(rows))
+	}
+}
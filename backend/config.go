@@ -34,10 +34,19 @@ type Config struct {
 	Type     string // Should be one of: backend.Type*
 	New      bool   `json:"-"`
 	Key      *[32]byte
+	OldKeys  []*[32]byte `json:",omitempty"` // Previous keys, oldest first
 	Local    bool
 	DataPath string // Used by backend.FileSystem, other local backends
 
 	Custom map[string]interface{} `json:",omitempty"` // Used by Dropbox, Webserver, other backends
+
+	Settings
+
+	// LockedKey is set when Key is passphrase-protected, in which
+	// case Key is not written to disk
+	LockedKey *LockedKey `json:",omitempty"`
+
+	wrapKey *[32]byte // Derived from passphrase; encrypts LockedKey
 }
 
 // Save persists this config to disk.  Returns error if a Config
@@ -72,17 +81,29 @@ func (conf *Config) save(backendsDir string, overwrite bool) error {
 	if err := conf.Canonicalize(); err != nil {
 		return err
 	}
-	b, err := json.Marshal(conf)
-	if err != nil {
-		return err
-	}
 
 	if overwrite {
+		// Don't replace a passphrase-protected config with an
+		// unprotected one
+		if err := conf.keepLock(filename); err != nil {
+			return err
+		}
 		if err := conf.Backup(backendsDir); err != nil {
 			return err
 		}
 	}
 
+	return conf.write(filename)
+}
+
+// write marshals conf and writes it to filename, leaving out conf.Key
+// if conf is passphrase-protected.
+func (conf *Config) write(filename string) error {
+	b, err := conf.marshal()
+	if err != nil {
+		return err
+	}
+
 	if err = ioutil.WriteFile(filename, b, 0600); err != nil {
 		return err
 	}
@@ -91,6 +112,25 @@ func (conf *Config) save(backendsDir string, overwrite bool) error {
 	return nil
 }
 
+func (conf *Config) marshal() ([]byte, error) {
+	if conf.LockedKey == nil {
+		return json.Marshal(conf)
+	}
+
+	// Key may have changed since conf was unlocked
+	if conf.Key != nil {
+		if err := conf.relock(); err != nil {
+			return nil, err
+		}
+	}
+
+	locked := *conf
+	locked.Key = nil
+	locked.OldKeys = nil
+
+	return json.Marshal(&locked)
+}
+
 // Backup creates a backup of this Config to
 // (backendsDir)/(conf.Name).json-$timestamp
 func (conf *Config) Backup(backendsDir string) error {
@@ -129,7 +169,13 @@ func (conf *Config) Canonicalize() error {
 			" more whitespace characters, shouldn't", conf.Name)
 	}
 
-	if conf.Key == nil {
+	if err := conf.Settings.Valid(); err != nil {
+		return fmt.Errorf("Invalid settings for storage backend `%s`: %v",
+			conf.Name, err)
+	}
+
+	// Locked Configs have a key; it just hasn't been decrypted yet
+	if conf.Key == nil && conf.LockedKey == nil {
 		log.Printf("Generating new encryption key for backend `%s`...",
 			conf.Name)
 		key, err := cryptag.RandomKey()
@@ -148,6 +194,26 @@ func (conf *Config) Canonicalize() error {
 	return nil
 }
 
+// KeyRing returns all of conf's keys, oldest first, ending with
+// conf.Key.
+func (conf *Config) KeyRing() cryptag.KeyRing {
+	ring := make(cryptag.KeyRing, 0, len(conf.OldKeys)+1)
+	ring = append(ring, conf.OldKeys...)
+	if conf.Key != nil {
+		ring = append(ring, conf.Key)
+	}
+	return ring
+}
+
+// AddKey makes key conf's newest key, used for all new encryption,
+// while keeping its previous keys in conf.OldKeys so that data
+// encrypted with them stays readable.
+func (conf *Config) AddKey(key *[32]byte) {
+	ring := conf.KeyRing().Add(key)
+	conf.OldKeys = ring[:len(ring)-1]
+	conf.Key = key
+}
+
 // GetType returns the type of conf.  Preferable to using .Type
 // directly because this detects legacy Backend Configs with type
 // TypeFileSystem, TypeWebserver, and TypeDropboxRemote.
@@ -185,7 +251,7 @@ func (conf *Config) GetPath() string {
 	switch typ {
 	case TypeDropboxRemote:
 		return fmt.Sprintf("%s", conf.Custom["BasePath"])
-	case TypeFileSystem:
+	case TypeFileSystem, TypeMemory:
 		return conf.DataPath
 	case TypeWebserver:
 		return fmt.Sprintf("%s", conf.Custom["BaseURL"])
@@ -202,8 +268,26 @@ func (conf *Config) GetPath() string {
 //
 
 // ReadConfig reads, parses, and unmarshals the Backend Config file
-// with the name backendName and returns it.
+// with the name backendName and returns it.  If the Config is
+// passphrase-protected, it is unlocked using PassphraseFunc.
 func ReadConfig(backendPath, backendName string) (*Config, error) {
+	conf, err := ReadLockedConfig(backendPath, backendName)
+	if err != nil {
+		return conf, err
+	}
+
+	if err = conf.PromptUnlock(); err != nil {
+		return nil, fmt.Errorf("Error unlocking Backend Config %s: %v",
+			conf.Name, err)
+	}
+
+	return conf, nil
+}
+
+// ReadLockedConfig is like ReadConfig but doesn't unlock
+// passphrase-protected Configs, whose Key will be nil.  Useful when
+// only a Config's non-secret fields are needed.
+func ReadLockedConfig(backendPath, backendName string) (*Config, error) {
 	if backendPath == "" {
 		backendPath = cryptag.BackendPath
 	}
@@ -264,7 +348,9 @@ func ReadConfig(backendPath, backendName string) (*Config, error) {
 
 // ReadConfigs reads, parses, and unmarshals the Backend Config files
 // located in backendPath (defaults to cryptag.BackendPath) and that
-// matches the pattern bkPattern.
+// matches the pattern bkPattern.  Passphrase-protected Configs are
+// not unlocked (see ReadLockedConfig); call PromptUnlock on the ones
+// whose keys are needed.
 func ReadConfigs(backendPath, bkPattern string) ([]*Config, error) {
 	if backendPath == "" {
 		backendPath = cryptag.BackendPath
@@ -284,7 +370,7 @@ func ReadConfigs(backendPath, bkPattern string) ([]*Config, error) {
 
 	for _, fname := range backendNames {
 		bkName := ConfigNameFromPath(fname)
-		conf, err := ReadConfig(backendPath, bkName)
+		conf, err := ReadLockedConfig(backendPath, bkName)
 		if err != nil {
 			errs = append(errs, err.Error())
 			continue
@@ -302,7 +388,9 @@ func ReadConfigs(backendPath, bkPattern string) ([]*Config, error) {
 
 // ReadBackends reads all the Backend Configs at backendPath whose
 // names match the pattern bkPattern, turns them into Backends, then
-// returns all the successfully created Backends.
+// returns all the successfully created Backends.  Passphrase-protected
+// Backends are skipped rather than prompting for each one's
+// passphrase; load them individually with LoadBackend.
 func ReadBackends(backendPath, bkPattern string) ([]Backend, error) {
 	configs, err := ReadConfigs(backendPath, bkPattern)
 	if err != nil {
@@ -316,10 +404,18 @@ func ReadBackends(backendPath, bkPattern string) ([]Backend, error) {
 	}
 
 	backends := make([]Backend, 0, len(configs))
+	locked := 0
 
 	for _, conf := range configs {
 		var bk Backend
 
+		if conf.Locked() {
+			log.Printf("Skipping passphrase-protected Backend `%s`\n",
+				conf.Name)
+			locked++
+			continue
+		}
+
 		typ := conf.GetType()
 
 		maker, err := GetMaker(typ)
@@ -337,7 +433,7 @@ func ReadBackends(backendPath, bkPattern string) ([]Backend, error) {
 		backends = append(backends, bk)
 	}
 
-	if len(configs) > 0 && len(backends) == 0 {
+	if len(configs) > locked && len(backends) == 0 {
 		// TODO: Abuse of scoping of err; consider making less subtle
 		return nil, fmt.Errorf("Error reading config: %v", err)
 	}
@@ -0,0 +1,49 @@
+// Steve Phillips / elimisteve
+// 2017.04.11
+
+package backend
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// hideTagStructure returns randtags, the real RandomTags of the Row
+// with the ID tag idTag, padded with decoy RandomTags (that have no
+// TagPair; see types.DecoyRandomTags) up to bk's Settings.MinTags and
+// shuffled, so that the server storing the Row can't tell which of
+// its RandomTags is its ID tag, which is "all", or how many tags it
+// really has.
+func hideTagStructure(bk Backend, idTag string, randtags cryptag.RandomTags) (cryptag.RandomTags, error) {
+	hidden := make(cryptag.RandomTags, len(randtags))
+	copy(hidden, randtags)
+
+	s := GetSettings(bk)
+
+	// Decoys look just like real RandomTags
+	if n := s.MinTags - len(randtags); n > 0 {
+		decoys := types.DecoyRandomTags(idTag, randtags, n, s.RandomTagLength())
+		hidden = append(hidden, decoys...)
+	}
+
+	if err := shuffle(hidden); err != nil {
+		return nil, err
+	}
+
+	return hidden, nil
+}
+
+// shuffle shuffles strs in place using crypto/rand.
+func shuffle(strs []string) error {
+	for i := len(strs) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return err
+		}
+		strs[i], strs[j.Int64()] = strs[j.Int64()], strs[i]
+	}
+	return nil
+}
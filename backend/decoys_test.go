@@ -0,0 +1,64 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoys(t *testing.T) {
+	m := newTestMemory(t, Settings{MinTags: 10})
+
+	row := mustCreateRow(t, m, nil, "decoyed", "type:note", "home")
+	assert.Len(t, row.RandomTags, 10)
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"home"})
+	if assert.Nil(t, err) && assert.Len(t, rows, 1) {
+		assert.Equal(t, "decoyed", string(rows[0].Decrypted()))
+		assert.Len(t, rows[0].PlainTags(), 5)
+		assert.Len(t, rows[0].Decoys(), 5)
+	}
+
+	rows, err = ListRowsFromPlainTags(m, nil, []string{"type:note"})
+	if assert.Nil(t, err) && assert.Len(t, rows, 1) {
+		assert.Len(t, rows[0].PlainTags(), 5)
+	}
+
+	// A real RandomTag whose TagPair we don't have is reported, not
+	// taken for a decoy
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	home := types.NewTagPairIndex(pairs).ByPlain("home")
+	rows, err = m.RowsFromRandomTags(cryptag.RandomTags{home[0].Random})
+	if !assert.Nil(t, err) {
+		return
+	}
+	var withoutHome types.TagPairs
+	for _, pair := range pairs {
+		if pair.Plain() != "home" {
+			withoutHome = append(withoutHome, pair)
+		}
+	}
+	err = rows[0].SetPlainTags(withoutHome)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "RandomTag `"+home[0].Random+"` not found", err.Error())
+	}
+
+	// Re-tagged Rows get decoys that match their new RandomTags
+	if !assert.Nil(t, MergeTag(m, "home", "house")) {
+		return
+	}
+	rows, err = RowsFromPlainTags(m, nil, []string{"house"})
+	if assert.Nil(t, err) && assert.Len(t, rows, 1) {
+		assert.Equal(t, "decoyed", string(rows[0].Decrypted()))
+		assert.Len(t, rows[0].RandomTags, 10)
+		assert.Contains(t, rows[0].PlainTags(), "house")
+	}
+}
@@ -40,7 +40,10 @@ type DropboxRemote struct {
 	tagCursor  string // Used to fetch latest tags only
 
 	// Used for encryption/decryption
-	key *[32]byte
+	key     *[32]byte
+	oldKeys []*[32]byte // Used for decryption only
+
+	settings Settings
 
 	dboxConf DropboxConfig
 }
@@ -118,7 +121,14 @@ func DropboxRemoteFromConfig(conf *Config) (*DropboxRemote, error) {
 		return nil, err
 	}
 
-	return NewDropboxRemote((*conf.Key)[:], conf.Name, dboxConf)
+	db, err := NewDropboxRemote((*conf.Key)[:], conf.Name, dboxConf)
+	if err != nil {
+		return nil, err
+	}
+	db.oldKeys = conf.OldKeys
+	db.settings = conf.Settings
+
+	return db, nil
 }
 
 // NewDropboxRemote creates a new DropboxRemote using the given
@@ -217,10 +227,12 @@ func (db *DropboxRemote) ToConfig() (*Config, error) {
 	name := "dropbox-" + host
 
 	config := Config{
-		Key:    db.key,
-		Name:   name,
-		Type:   TypeDropboxRemote,
-		Custom: DropboxConfigToMap(db.dboxConf),
+		Key:      db.key,
+		OldKeys:  db.oldKeys,
+		Name:     name,
+		Type:     TypeDropboxRemote,
+		Custom:   DropboxConfigToMap(db.dboxConf),
+		Settings: db.settings,
 	}
 	return &config, nil
 }
@@ -229,6 +241,14 @@ func (db *DropboxRemote) Key() *[32]byte {
 	return db.key
 }
 
+func (db *DropboxRemote) KeyRing() cryptag.KeyRing {
+	return append(append(cryptag.KeyRing{}, db.oldKeys...), db.key)
+}
+
+func (db *DropboxRemote) Settings() Settings {
+	return db.settings
+}
+
 func (db *DropboxRemote) AllTagPairs(oldPairs types.TagPairs) (types.TagPairs, error) {
 	start := time.Now()
 
@@ -251,6 +271,9 @@ func (db *DropboxRemote) SaveRow(row *types.Row) error {
 		}
 		return errors.New("Invalid row; requires Encrypted, RandomTags, Nonce fields")
 	}
+	if err := validRandomTags(row.RandomTags); err != nil {
+		return err
+	}
 
 	rowB, err := json.Marshal(row)
 	if err != nil {
@@ -268,7 +291,9 @@ func (db *DropboxRemote) SaveRow(row *types.Row) error {
 	// bad; should be filename only.
 	dest := db.rowsURL + "/" + strings.Join(row.RandomTags, "-")
 
-	_, err = db.dbox.FilesPut(rclose, int64(len(rowB)), dest, false, "")
+	// Overwrite so that re-encrypted Rows (see RotateKey) replace
+	// the originals rather than being saved alongside them
+	_, err = db.dbox.FilesPut(rclose, int64(len(rowB)), dest, true, "")
 	if err != nil {
 		return err
 	}
@@ -277,6 +302,10 @@ func (db *DropboxRemote) SaveRow(row *types.Row) error {
 }
 
 func (db *DropboxRemote) SaveTagPair(pair *types.TagPair) error {
+	if err := ValidRandomTag(pair.Random); err != nil {
+		return err
+	}
+
 	pairB, err := json.Marshal(pair)
 	if err != nil {
 		return err
@@ -289,7 +318,7 @@ func (db *DropboxRemote) SaveTagPair(pair *types.TagPair) error {
 	rclose := ioutil.NopCloser(bytes.NewReader(pairB))
 	dest := db.tagsURL + "/" + pair.Random
 
-	_, err = db.dbox.FilesPut(rclose, int64(len(pairB)), dest, false, "")
+	_, err = db.dbox.FilesPut(rclose, int64(len(pairB)), dest, true, "")
 	if err != nil {
 		return err
 	}
@@ -319,7 +348,38 @@ func (db *DropboxRemote) RowsFromRandomTags(randtags cryptag.RandomTags) (types.
 }
 
 func (db *DropboxRemote) DeleteRows(randTags cryptag.RandomTags) error {
-	return errors.New("DropboxRemote.DeleteRows NOT IMPLEMENTED")
+	if len(randTags) == 0 {
+		return fmt.Errorf("Must query by 1 or more tags")
+	}
+
+	rows, err := fetchRows(db, randTags, false)
+	if err != nil {
+		return err
+	}
+
+	// Dropbox search matches substrings of filenames, so make sure
+	// each Row found really has every tag
+	for _, row := range rows {
+		if !fun.SliceContainsAll(row.RandomTags, randTags) {
+			continue
+		}
+		_, err = db.dbox.Delete(db.rowsURL + "/" + strings.Join(row.RandomTags, "-"))
+		if err != nil {
+			return fmt.Errorf("Error deleting Row: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteTagPairs deletes the TagPairs with the given RandomTags.
+func (db *DropboxRemote) DeleteTagPairs(randtags cryptag.RandomTags) error {
+	for _, random := range randtags {
+		if _, err := db.dbox.Delete(db.tagsURL + "/" + random); err != nil {
+			return fmt.Errorf("Error deleting TagPair: %v", err)
+		}
+	}
+	return nil
 }
 
 //
@@ -337,7 +397,7 @@ func fetchRows(db *DropboxRemote, randtags cryptag.RandomTags, includeFileBody b
 }
 
 // getRowsFromDbox fetches the encrypted rows from url, decrypts them, then
-func getRowsFromDbox(key *[32]byte, url string, pairs types.TagPairs) (types.Rows, error) {
+func getRowsFromDbox(ring cryptag.KeyRing, url string, pairs types.TagPairs) (types.Rows, error) {
 	var rows types.Rows
 	var err error
 
@@ -346,7 +406,7 @@ func getRowsFromDbox(key *[32]byte, url string, pairs types.TagPairs) (types.Row
 	}
 
 	for _, row := range rows {
-		if err = row.Populate(key, pairs); err != nil {
+		if err = row.PopulateWithKeyRing(ring, pairs); err != nil {
 			return nil, fmt.Errorf("Error from PopulateRowAfterGet: %v", err)
 		}
 	}
@@ -364,45 +424,69 @@ func getAllTagsFromDbox(db *DropboxRemote) (types.TagPairs, error) {
 	if err != nil {
 		return nil, err
 	}
-	db.SetTagCursor(entry.Hash)
 
 	randtags := make([]string, 0, len(entry.Contents))
 	for i := range entry.Contents {
 		randtags = append(randtags, filepath.Base(entry.Contents[i].Path))
 	}
 
-	return getTagsFromDbox(db, randtags)
+	pairs, err := getTagsFromDbox(db, randtags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only advance the cursor once every tag was fetched, so a failed
+	// fetch is retried next time
+	db.SetTagCursor(entry.Hash)
+
+	return pairs, nil
 }
 
 // getTagsFromDbox fetches the encrypted tag pairs at db.tagsURL,
-// decrypts them, and unmarshals them into a TagPairs value
+// decrypts them, and unmarshals them into a TagPairs value.  Tags that
+// don't exist are skipped quietly, since callers such as
+// uniqueRandomTag check for tags they expect not to find.  Any other
+// failure is returned, lest a tag that couldn't be fetched be mistaken
+// for one that doesn't exist.
 func getTagsFromDbox(db *DropboxRemote, randtags cryptag.RandomTags) (types.TagPairs, error) {
-	tags := make(chan *types.TagPair)
+	type result struct {
+		tag  string
+		pair *types.TagPair
+		err  error
+	}
+	results := make(chan result)
 
 	// Download tags in randtags
 	for _, tag := range randtags {
 		go func(tag string) {
 			pair, err := getTagFromDbox(db, tag)
-			if err != nil {
-				log.Printf("Error from getTagFromDbox: %v\n", err)
-				tags <- nil
-				return
-			}
-			tags <- pair
+			results <- result{tag, pair, err}
 		}(tag)
 	}
 
 	var pairs types.TagPairs
+	var firstErr error
 
+	// Receive every result, even after an error, so no goroutine
+	// blocks forever
 	for i := 0; i < len(randtags); i++ {
-		if t := <-tags; t != nil {
-			// log.Printf("Tag #%d: %#v\n", i, t)
-			pairs = append(pairs, t)
+		res := <-results
+		switch {
+		case os.IsNotExist(res.err):
+			if types.Debug {
+				log.Printf("getTagFromDbox: tag `%s` not found\n", res.tag)
+			}
+		case res.err != nil:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Error getting tag `%s`: %v", res.tag, res.err)
+			}
+		default:
+			pairs = append(pairs, res.pair)
 		}
 	}
 
-	if len(pairs) == 0 {
-		log.Printf("getTagsFromDbox returning no pairs!\n")
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
 	return pairs, nil
@@ -410,6 +494,9 @@ func getTagsFromDbox(db *DropboxRemote, randtags cryptag.RandomTags) (types.TagP
 
 func getTagFromDbox(db *DropboxRemote, tag string) (*types.TagPair, error) {
 	b, err := download(db, db.tagsURL+"/"+tag)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Error from download: %v\n", err)
 	}
@@ -420,7 +507,7 @@ func getTagFromDbox(db *DropboxRemote, tag string) (*types.TagPair, error) {
 	}
 
 	// Decrypt, thereby setting pair.plain
-	if err = pair.Decrypt(db.Key()); err != nil {
+	if err = pair.DecryptWithKeyRing(db.KeyRing()); err != nil {
 		return nil, fmt.Errorf("Error from Decrypt: %v\n", err)
 	}
 
@@ -517,6 +604,10 @@ func download(db *DropboxRemote, fullURL string) (body []byte, err error) {
 		log.Printf("Downloading `%v`\n", fullURL)
 	}
 	f, _, err := db.dbox.Download(fullURL, "", 0)
+	if os.IsNotExist(err) {
+		// Returned as-is so callers can check for it
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Error downloading `%v`: %v\n", fullURL, err)
 	}
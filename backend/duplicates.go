@@ -0,0 +1,119 @@
+// Steve Phillips / elimisteve
+// 2017.04.22
+
+package backend
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// A PlainTag can end up with more than one TagPair when two clients
+// create it at about the same time (e.g., two devices syncing through
+// Dropbox).  Queries match Rows tagged with any of a tag's TagPairs,
+// and RepairDuplicateTags moves every Row to a single canonical
+// TagPair.
+
+// RepairDuplicateTags re-tags every Row tagged with a duplicate
+// TagPair with its PlainTag's canonical TagPair (the one with the
+// lowest RandomTag, so that clients repairing at the same time agree),
+// then deletes the duplicates.  Returns the PlainTags repaired.
+//
+// bk must support deleting TagPairs.  If interrupted, call again to
+// resume.  Like MergeTag, re-tags nothing if Rows that need it are
+// sealed to or signed by other users.
+func RepairDuplicateTags(bk Backend) (repaired []string, err error) {
+	pairs, err := bk.AllTagPairs(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching TagPairs: %v", err)
+	}
+
+	dups := pairs.Duplicates()
+	if len(dups) == 0 {
+		return nil, nil
+	}
+
+	deleter, ok := bk.(TagPairDeleter)
+	if !ok {
+		return nil, ErrTagPairDeleteNotSupported
+	}
+
+	plaintags := make([]string, 0, len(dups))
+	for plain := range dups {
+		plaintags = append(plaintags, plain)
+	}
+	sort.Strings(plaintags)
+
+	for _, plain := range plaintags {
+		randtags := dups[plain].AllRandom()
+		sort.Strings(randtags)
+
+		canonical, extra := randtags[0], randtags[1:]
+
+		if types.Debug {
+			log.Printf("RepairDuplicateTags: merging TagPairs %v of `%s` into %s\n",
+				extra, plain, canonical)
+		}
+
+		if err = retagRows(bk, pairs, plain, plain, extra, canonical); err != nil {
+			return repaired, err
+		}
+
+		if err = deleter.DeleteTagPairs(extra); err != nil {
+			return repaired, fmt.Errorf("Error deleting duplicate TagPairs: %v", err)
+		}
+
+		repaired = append(repaired, plain)
+	}
+
+	return repaired, nil
+}
+
+// hasDuplicatePairs reports whether any of plaintags has more than one
+// TagPair in pairs.
+func hasDuplicatePairs(pairs types.TagPairs, plaintags []string) bool {
+	counts := make(map[string]int, len(plaintags))
+	for _, plain := range plaintags {
+		counts[plain] = 0
+	}
+	for _, pair := range pairs {
+		n, wanted := counts[pair.Plain()]
+		if !wanted {
+			continue
+		}
+		if n == 1 {
+			if types.Debug {
+				log.Printf("PlainTag `%s` has duplicate TagPairs\n", pair.Plain())
+			}
+			return true
+		}
+		counts[pair.Plain()] = n + 1
+	}
+	return false
+}
+
+// deleteRowsWithDuplicatePairs deletes the Rows tagged with all of
+// plaintags, one or more of which has duplicate TagPairs, by deleting
+// each such Row individually.
+func deleteRowsWithDuplicatePairs(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags) error {
+	rows, err := ListRowsFromPlainTags(bk, pairs, plaintags)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		// Rows with a superset of row's tags were deleted with it
+		err = bk.DeleteRows(row.RandomTags)
+		if err != nil && err != types.ErrRowsNotFound {
+			return err
+		}
+	}
+
+	unindexRows(bk, rows)
+
+	return nil
+}
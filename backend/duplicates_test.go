@@ -0,0 +1,138 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// createDuplicateTag creates two TagPairs for plain and a Row tagged
+// with each (and "other"), and returns the TagPairs' RandomTags,
+// lowest first.
+func createDuplicateTag(t *testing.T, m *Memory, plain string) []string {
+	mustCreateRow(t, m, nil, "first "+plain, plain, "other")
+
+	all, err := m.AllTagPairs(nil)
+	if err != nil {
+		t.Fatalf("Error getting TagPairs: %v", err)
+	}
+	randtags := all.WithPlainPattern(plain).AllRandom()
+
+	// As another client that hadn't seen the first TagPair would
+	var pairs types.TagPairs
+	for _, pair := range all {
+		if pair.Plain() != plain {
+			pairs = append(pairs, pair)
+		}
+	}
+	dup, err := createTag(m, m.Key(), plain, nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating duplicate TagPair: %v", err)
+	}
+	mustCreateRow(t, m, append(pairs, dup), "second "+plain, plain, "other")
+
+	all, err = m.AllTagPairs(nil)
+	if err != nil {
+		t.Fatalf("Error getting TagPairs: %v", err)
+	}
+	assert.Equal(t, map[string]int{plain: 2}, duplicateCounts(all))
+
+	randtags = append(randtags, dup.Random)
+	sort.Strings(randtags)
+	return randtags
+}
+
+func duplicateCounts(pairs types.TagPairs) map[string]int {
+	counts := map[string]int{}
+	for plain, dups := range pairs.Duplicates() {
+		counts[plain] = len(dups)
+	}
+	return counts
+}
+
+func TestRepairDuplicateTags(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	randtags := createDuplicateTag(t, m, "work")
+	mustCreateRow(t, m, nil, "home", "home")
+
+	// Queries match Rows tagged with either TagPair
+	rows, err := RowsFromPlainTags(m, nil, []string{"work", "other"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"first work", "second work"}, decryptedData(rows))
+	}
+	q, err := ParseQuery("work | home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = RowsFromQuery(m, nil, q)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"first work", "home", "second work"}, decryptedData(rows))
+	}
+
+	repaired, err := RepairDuplicateTags(m)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"work"}, repaired)
+
+	// Only the lowest RandomTag is left, and every Row uses it
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 0, len(pairs.Duplicates()))
+	if work := pairs.WithPlainPattern("work"); assert.Equal(t, 1, len(work)) {
+		assert.Equal(t, randtags[0], work[0].Random)
+	}
+
+	rows, err = RowsFromPlainTags(m, nil, []string{"work"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"first work", "second work"}, decryptedData(rows))
+		for _, row := range rows {
+			assert.True(t, row.HasRandomTag(randtags[0]))
+			assert.False(t, row.HasRandomTag(randtags[1]))
+		}
+	}
+
+	// Nothing left to repair
+	repaired, err = RepairDuplicateTags(m)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(repaired))
+
+	after, err := m.AllTagPairs(nil)
+	if assert.Nil(t, err) {
+		assert.ElementsMatch(t, pairs.AllRandom(), after.AllRandom())
+	}
+}
+
+func TestDeleteRowsWithDuplicatePairs(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	createDuplicateTag(t, m, "work")
+	mustCreateRow(t, m, nil, "work at home", "work", "home")
+	mustCreateRow(t, m, nil, "home", "home")
+
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, hasDuplicatePairs(pairs, []string{"work", "other"}))
+	assert.False(t, hasDuplicatePairs(pairs, []string{"home", "other"}))
+
+	// Rows tagged with either TagPair are deleted
+	if !assert.Nil(t, DeleteRows(m, nil, []string{"work", "other"})) {
+		return
+	}
+
+	_, err = RowsFromPlainTags(m, nil, []string{"other"})
+	assert.Equal(t, types.ErrRowsNotFound, err)
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"all"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"home", "work at home"}, decryptedData(rows))
+	}
+}
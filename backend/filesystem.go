@@ -7,12 +7,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/cryptag/cryptag"
 	"github.com/cryptag/cryptag/types"
@@ -30,6 +33,10 @@ type FileSystem struct {
 	rowsPath string // subdirectory of dataPath
 	new      bool
 	key      *[32]byte
+	oldKeys  []*[32]byte
+	settings Settings
+
+	streamsPath string // subdirectory of dataPath; streamed Rows' data
 }
 
 func NewFileSystem(conf *Config) (*FileSystem, error) {
@@ -44,6 +51,10 @@ func NewFileSystem(conf *Config) (*FileSystem, error) {
 		rowsPath: path.Join(conf.DataPath, "rows"),
 		new:      conf.New,
 		key:      conf.Key,
+		oldKeys:  conf.OldKeys,
+		settings: conf.Settings,
+
+		streamsPath: path.Join(conf.DataPath, "streams"),
 	}
 	if err := fs.init(); err != nil {
 		return nil, err
@@ -68,7 +79,7 @@ func (fs *FileSystem) init() error {
 	var err error
 	// TODO(elimisteve): Should this assume that cryptag.BackendPath
 	// already exists?
-	for _, path := range []string{fs.dataPath, fs.tagsPath, fs.rowsPath, cryptag.BackendPath} {
+	for _, path := range []string{fs.dataPath, fs.tagsPath, fs.rowsPath, fs.streamsPath, cryptag.BackendPath} {
 		err = os.MkdirAll(path, 0755)
 		if err == nil || os.IsExist(err) {
 			// Created successfully or already exists
@@ -144,7 +155,9 @@ func (fs *FileSystem) ToConfig() (*Config, error) {
 		Type:     TypeFileSystem,
 		New:      fs.new,
 		Key:      fs.key,
+		OldKeys:  fs.oldKeys,
 		DataPath: fs.dataPath,
+		Settings: fs.settings,
 	}
 
 	return &config, nil
@@ -154,17 +167,33 @@ func (fs *FileSystem) Key() *[32]byte {
 	return fs.key
 }
 
+func (fs *FileSystem) KeyRing() cryptag.KeyRing {
+	return append(append(cryptag.KeyRing{}, fs.oldKeys...), fs.key)
+}
+
+func (fs *FileSystem) Settings() Settings {
+	return fs.settings
+}
+
 func (fs *FileSystem) AllTagPairs(oldPairs types.TagPairs) (types.TagPairs, error) {
 	tagFiles, err := filepath.Glob(path.Join(fs.tagsPath, "*"))
 	if err != nil {
 		return nil, fmt.Errorf("Error listing tags: %v", err)
 	}
 
-	var pairs types.TagPairs
+	// Only read and decrypt TagPairs that oldPairs doesn't have
+	known := types.NewTagPairIndex(oldPairs)
+
+	pairs := make(types.TagPairs, 0, len(tagFiles))
 	for _, f := range tagFiles {
+		if pair, ok := known.ByRandom(filepath.Base(f)); ok {
+			pairs = append(pairs, pair)
+			continue
+		}
+
 		// filepath.Base(f) is of the form randtag1-randtag2-randtag3
 		// and its contents is {"plain_encrypted": ..., "nonce": ...}
-		pair, err := readTagFile(fs.Key(), f)
+		pair, err := readTagFile(fs.KeyRing(), f)
 		if err != nil {
 			return nil, err
 		}
@@ -180,9 +209,32 @@ func (fs *FileSystem) AllTagPairs(oldPairs types.TagPairs) (types.TagPairs, erro
 	return pairs, nil
 }
 
+// TagPairsFromRandomTags returns the TagPairs with the given
+// RandomTags, skipping those that don't exist.
 func (fs *FileSystem) TagPairsFromRandomTags(randtags cryptag.RandomTags) (types.TagPairs, error) {
-	// TODO: This should grab files from disk with the names $BASE/tags/$randtag
-	return nil, errors.New("TagPairsFromRandomTags: NOT IMPLEMENTED")
+	var pairs types.TagPairs
+	for _, random := range randtags {
+		pair, err := readTagFile(fs.KeyRing(), path.Join(fs.tagsPath, filepath.Base(random)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// DeleteTagPairs deletes the TagPairs with the given RandomTags.
+func (fs *FileSystem) DeleteTagPairs(randtags cryptag.RandomTags) error {
+	for _, random := range randtags {
+		err := os.Remove(path.Join(fs.tagsPath, filepath.Base(random)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (fs *FileSystem) SaveTagPair(pair *types.TagPair) error {
@@ -190,6 +242,9 @@ func (fs *FileSystem) SaveTagPair(pair *types.TagPair) error {
 		// TODO(elimisteve): Make error global?
 		return errors.New("Invalid tag pair; requires plain_encrypted, random, and nonce fields")
 	}
+	if err := ValidRandomTag(pair.Random); err != nil {
+		return err
+	}
 
 	// Just save "plain_encrypted" and "nonce" to file ("random"
 	// contained in filename)
@@ -197,6 +252,9 @@ func (fs *FileSystem) SaveTagPair(pair *types.TagPair) error {
 		"plain_encrypted": pair.PlainEncrypted,
 		"nonce":           pair.Nonce,
 	}
+	if pair.KeyID != "" {
+		t["key_id"] = pair.KeyID
+	}
 	b, err := json.Marshal(t)
 	if err != nil {
 		return err
@@ -205,7 +263,7 @@ func (fs *FileSystem) SaveTagPair(pair *types.TagPair) error {
 	// Save tag pair to fs.tagsPath/$random
 	filepath := path.Join(fs.tagsPath, pair.Random)
 
-	return ioutil.WriteFile(filepath, b, 0600)
+	return writeFileKeepModTime(filepath, b, 0600)
 }
 
 func (fs *FileSystem) ListRows(randtags cryptag.RandomTags) (types.Rows, error) {
@@ -241,6 +299,9 @@ func (fs *FileSystem) SaveRow(row *types.Row) error {
 		// TODO(elimisteve): Make error global?
 		return errors.New("Invalid row; requires Encrypted, RandomTags, Nonce fields")
 	}
+	if err := validRandomTags(row.RandomTags); err != nil {
+		return err
+	}
 
 	// Save row.{Encrypted,Nonce} to fs.rowsPath/randomtag1-randomtag2-randomtag3
 
@@ -248,6 +309,13 @@ func (fs *FileSystem) SaveRow(row *types.Row) error {
 		"data":  row.Encrypted,
 		"nonce": row.Nonce,
 	}
+	if row.KeyID != "" {
+		rowData["key_id"] = row.KeyID
+	}
+	if row.IsSigned() {
+		rowData["signer"] = row.Signer
+		rowData["sig"] = row.Signature
+	}
 	b, err := json.Marshal(rowData)
 	if err != nil {
 		return err
@@ -258,7 +326,68 @@ func (fs *FileSystem) SaveRow(row *types.Row) error {
 	filename := strings.Join(row.RandomTags, "-")
 	filepath := path.Join(fs.rowsPath, filename)
 
-	return ioutil.WriteFile(filepath, b, 0600)
+	return writeFileKeepModTime(filepath, b, 0600)
+}
+
+// SaveRowStream saves row, whose encrypted data is read from
+// encrypted and stored in its own file in fs.streamsPath, not in the
+// row file.
+func (fs *FileSystem) SaveRowStream(row *types.Row, encrypted io.Reader) error {
+	if !row.Streamed || len(row.RandomTags) == 0 || row.Nonce == nil || *row.Nonce == [24]byte{} {
+		if types.Debug {
+			log.Printf("Error saving streamed row `%#v`\n", row)
+		}
+		return errors.New("Invalid streamed row; requires Streamed, RandomTags, Nonce fields")
+	}
+
+	filename := strings.Join(row.RandomTags, "-")
+
+	// Write to a temporary file first so that a failed or partial
+	// stream never ends up looking like a complete Row
+	streamFile := path.Join(fs.streamsPath, filename)
+	tmpFile := tempFileFor(streamFile)
+
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, encrypted)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("Error writing row stream: %v", err)
+	}
+
+	if err = os.Rename(tmpFile, streamFile); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	rowData := map[string]interface{}{
+		"nonce":    row.Nonce,
+		"streamed": true,
+	}
+	if row.KeyID != "" {
+		rowData["key_id"] = row.KeyID
+	}
+	b, err := json.Marshal(rowData)
+	if err != nil {
+		return err
+	}
+
+	return writeFileKeepModTime(path.Join(fs.rowsPath, filename), b, 0600)
+}
+
+// RowStream opens the file containing the encrypted data of the
+// streamed Row row.
+func (fs *FileSystem) RowStream(row *types.Row) (io.ReadCloser, error) {
+	if !row.Streamed {
+		return nil, types.ErrRowNotStreamed
+	}
+	return os.Open(path.Join(fs.streamsPath, strings.Join(row.RandomTags, "-")))
 }
 
 func (fs *FileSystem) DeleteRows(randTags cryptag.RandomTags) error {
@@ -290,6 +419,13 @@ func (fs *FileSystem) DeleteRows(randTags cryptag.RandomTags) error {
 		if err != nil {
 			return err
 		}
+
+		// Remove streamed data, if any
+		streamFile := path.Join(fs.streamsPath, strings.Join(row.RandomTags, "-"))
+		err = os.Remove(streamFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
 	return nil
@@ -300,25 +436,94 @@ func (fs *FileSystem) DeleteRows(randTags cryptag.RandomTags) error {
 //
 
 func (fs *FileSystem) rowsFromRandomTags(randTags []string, includeFileBody bool) (types.Rows, error) {
+	return fs.rowsFromRandomTagsPage(randTags, includeFileBody, types.PageOptions{})
+}
+
+func (fs *FileSystem) rowsFromRandomTagsPage(randTags []string, includeFileBody bool, opts types.PageOptions) (types.Rows, error) {
 	if types.Debug {
-		log.Printf("rowsFromRandomTags(%#v, %v)\n", randTags, includeFileBody)
+		log.Printf("rowsFromRandomTagsPage(%#v, %v, %+v)\n", randTags,
+			includeFileBody, opts)
+	}
+
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+
+	hasAll := func(rowTags []string) bool {
+		return fun.SliceContainsAll(rowTags, randTags)
 	}
 
+	return fs.rowsMatchingPage(hasAll, includeFileBody, opts)
+}
+
+// RowsFromRandomQuery returns the Rows matching q.
+func (fs *FileSystem) RowsFromRandomQuery(q *RandomQuery) (types.Rows, error) {
+	return fs.rowsMatching(q.Matches, true)
+}
+
+// ListRowsFromRandomQuery returns the Rows matching q, without their
+// data.
+func (fs *FileSystem) ListRowsFromRandomQuery(q *RandomQuery) (types.Rows, error) {
+	return fs.rowsMatching(q.Matches, false)
+}
+
+// RowsByIDs returns the Rows having any of idRandTags.
+func (fs *FileSystem) RowsByIDs(idRandTags cryptag.RandomTags) (types.Rows, error) {
+	return fs.rowsMatching((&RandomQuery{Op: OpTag, RandomTags: idRandTags}).Matches, true)
+}
+
+// ListRowsPage is like ListRows but only returns the Rows that opts
+// selects.
+func (fs *FileSystem) ListRowsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error) {
+	if len(randtags) == 0 {
+		return nil, errors.New("Must query by 1 or more tags")
+	}
+	return fs.rowsFromRandomTagsPage(randtags, false, opts)
+}
+
+// RowsFromRandomTagsPage is like RowsFromRandomTags but only returns
+// the Rows that opts selects.
+func (fs *FileSystem) RowsFromRandomTagsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error) {
+	if len(randtags) == 0 {
+		return nil, errors.New("Must query by 1 or more tags")
+	}
+	return fs.rowsFromRandomTagsPage(randtags, true, opts)
+}
+
+// rowsMatching returns the Rows whose RandomTags satisfy match.
+func (fs *FileSystem) rowsMatching(match func(rowTags []string) bool, includeFileBody bool) (types.Rows, error) {
+	return fs.rowsMatchingPage(match, includeFileBody, types.PageOptions{})
+}
+
+// rowsMatchingPage returns the Rows whose RandomTags satisfy match
+// that opts selects.  Only the row files of those Rows are read.
+func (fs *FileSystem) rowsMatchingPage(match func(rowTags []string) bool, includeFileBody bool, opts types.PageOptions) (types.Rows, error) {
 	rowFiles, err := filepath.Glob(path.Join(fs.rowsPath, "*"))
 	if err != nil {
 		return nil, err
 	}
 
-	var rows types.Rows
-
-	// For each row dir, if it has all tags, append to `rows`
+	// For each row file, if its tags match, keep it
+	var matches []string
 	for _, f := range rowFiles {
 		// Row filenames are of the form randtag1-randtag2-randtag3
-		rowTags := strings.Split(filepath.Base(f), "-")
+		if match(strings.Split(filepath.Base(f), "-")) {
+			matches = append(matches, f)
+		}
+	}
 
-		if !fun.SliceContainsAll(rowTags, randTags) {
-			continue
+	if opts.Order != types.OrderNone {
+		if err = sortByModTime(matches, opts.Order == types.OrderNewest); err != nil {
+			return nil, err
 		}
+	}
+
+	start, end := opts.Bounds(len(matches))
+
+	var rows types.Rows
+
+	for _, f := range matches[start:end] {
+		rowTags := strings.Split(filepath.Base(f), "-")
 
 		var row *types.Row
 
@@ -329,7 +534,7 @@ func (fs *FileSystem) rowsFromRandomTags(randTags []string, includeFileBody bool
 				return nil, err
 			}
 		} else {
-			// Row is tagged with all queryTags; return to user
+			// Row matches; return to user
 			row = &types.Row{RandomTags: rowTags}
 		}
 
@@ -343,7 +548,7 @@ func (fs *FileSystem) rowsFromRandomTags(randTags []string, includeFileBody bool
 	return rows, nil
 }
 
-func readTagFile(key *[32]byte, tagFile string) (*types.TagPair, error) {
+func readTagFile(ring cryptag.KeyRing, tagFile string) (*types.TagPair, error) {
 	// TODO(elimisteve): Do streaming reads
 
 	// Set pair.{PlainEncrypted,Nonce} from file contents, pair.Random
@@ -364,8 +569,8 @@ func readTagFile(key *[32]byte, tagFile string) (*types.TagPair, error) {
 	pair.Random = filepath.Base(tagFile)
 
 	// Populate pair.plain
-	if err = pair.Decrypt(key); err != nil {
-		return nil, fmt.Errorf("Error from pair.Decrypt: %v", err)
+	if err = pair.DecryptWithKeyRing(ring); err != nil {
+		return nil, fmt.Errorf("Error from pair.DecryptWithKeyRing: %v", err)
 	}
 
 	return pair, nil
@@ -378,7 +583,8 @@ func readRowFile(bk *FileSystem, rowFilePath string, rowTags []string) (*types.R
 	}
 
 	var row types.Row
-	// This populates row.Encrypted and row.Nonce
+	// This populates row.Encrypted, row.Nonce, row.Streamed,
+	// row.KeyID, row.Signer, and row.Signature
 	err = json.Unmarshal(b, &row)
 	if err != nil {
 		return nil, err
@@ -388,3 +594,44 @@ func readRowFile(bk *FileSystem, rowFilePath string, rowTags []string) (*types.R
 
 	return &row, nil
 }
+
+// writeFileKeepModTime is like ioutil.WriteFile but, if filename
+// already exists, keeps its modification time.  Row files are
+// ordered by modification time (see types.PageOptions), which should
+// stay the time each Row was first saved even when it's re-saved,
+// such as when re-encrypted with a new key.
+func writeFileKeepModTime(filename string, b []byte, perm os.FileMode) error {
+	info, statErr := os.Stat(filename)
+
+	if err := ioutil.WriteFile(filename, b, perm); err != nil {
+		return err
+	}
+
+	if statErr != nil {
+		return nil
+	}
+	return os.Chtimes(filename, time.Now(), info.ModTime())
+}
+
+// sortByModTime sorts files by modification time, oldest first unless
+// newestFirst, breaking ties by name.
+func sortByModTime(files []string, newestFirst bool) error {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		ti, tj := modTimes[files[i]], modTimes[files[j]]
+		if ti.Equal(tj) {
+			return files[i] < files[j]
+		}
+		return ti.Before(tj) != newestFirst
+	})
+
+	return nil
+}
@@ -14,20 +14,44 @@ import (
 	"github.com/cryptag/cryptag"
 	"github.com/cryptag/cryptag/keyutil"
 	"github.com/cryptag/cryptag/rowutil"
+	"github.com/cryptag/cryptag/tagschema"
 	"github.com/cryptag/cryptag/types"
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/elimisteve/fun"
 )
 
+// RowsFromPlainTags returns the Rows in bk that have all of
+// plaintags, any of which can be a prefix or range pattern such as
+// "when:201703*" or "created:>=20170101" (see types.MatchesTag, and
+// types.EscapeTag for matching tags like "50*" exactly).
 func RowsFromPlainTags(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags) (types.Rows, error) {
 	return getRows(bk, pairs, plaintags, bk.RowsFromRandomTags)
 }
 
+// ListRowsFromPlainTags is like RowsFromPlainTags but doesn't fetch
+// the Rows' data.
 func ListRowsFromPlainTags(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags) (types.Rows, error) {
 	return getRows(bk, pairs, plaintags, bk.ListRows)
 }
 
 func getRows(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, fetchByRandom func(cryptag.RandomTags) (types.Rows, error)) (types.Rows, error) {
+	// Prefix and range patterns match the union of several tags
+	for _, plain := range plaintags {
+		if types.IsTagPattern(plain) {
+			return getRowsByQuery(bk, pairs, TagsQuery(plaintags), nil, fetchByRandom)
+		}
+	}
+	plaintags = types.UnescapeTags(plaintags)
+
 	if pairs == nil {
-		var err error
+		index, err := blindIndex(bk)
+		if err != nil {
+			return nil, err
+		}
+		if index != nil {
+			return getRowsBlind(bk, index, plaintags, fetchByRandom)
+		}
+
 		pairs, err = bk.AllTagPairs(nil)
 		if err != nil {
 			return nil, err
@@ -38,6 +62,11 @@ func getRows(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, fetc
 		return nil, types.ErrTagPairNotFound
 	}
 
+	// Match Rows tagged with any of a tag's duplicate TagPairs
+	if hasDuplicatePairs(pairs, plaintags) {
+		return getRowsByQuery(bk, pairs, TagsQuery(plaintags), nil, fetchByRandom)
+	}
+
 	matches, err := pairs.WithAllPlainTags(plaintags)
 	if err != nil {
 		return nil, err
@@ -48,11 +77,14 @@ func getRows(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, fetc
 		return nil, err
 	}
 
+	// Skip Rows sealed to other users
+	rows = rows.Readable()
+
 	if len(rows) == 0 {
 		return nil, types.ErrRowsNotFound
 	}
 
-	if err := rows.Populate(bk.Key(), pairs); err != nil {
+	if err := rows.PopulateWithKeyRing(KeyRing(bk), pairs); err != nil {
 		return nil, err
 	}
 
@@ -60,48 +92,95 @@ func getRows(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, fetc
 }
 
 func DeleteRows(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags) error {
-	if pairs == nil {
+	var randtags cryptag.RandomTags
+
+	// Note which Rows to remove from the search index
+	var deleted types.Rows
+	if hasSearchIndex(bk) {
+		deleted, _ = ListRowsFromPlainTags(bk, pairs, plaintags)
+	}
+
+	if pairs == nil && UsesBlindTags(bk) {
 		var err error
-		pairs, err = bk.AllTagPairs(nil)
+		randtags, err = RandomTagsFromPlain(bk, plaintags)
 		if err != nil {
 			return err
 		}
-	}
+	} else {
+		if pairs == nil {
+			var err error
+			pairs, err = bk.AllTagPairs(nil)
+			if err != nil {
+				return err
+			}
+		}
 
-	matches, err := pairs.WithAllPlainTags(plaintags)
-	if err != nil {
-		return err
-	}
+		if hasDuplicatePairs(pairs, plaintags) {
+			return deleteRowsWithDuplicatePairs(bk, pairs, plaintags)
+		}
 
-	randtags := matches.AllRandom()
+		matches, err := pairs.WithAllPlainTags(plaintags)
+		if err != nil {
+			return err
+		}
+
+		randtags = matches.AllRandom()
+	}
 
 	if types.Debug {
 		log.Printf("Deleting rows with PlainTags `%v` / RandomTags `%v`\n",
 			plaintags, randtags)
 	}
 
-	return bk.DeleteRows(randtags)
+	if err := bk.DeleteRows(randtags); err != nil {
+		return err
+	}
+
+	unindexRows(bk, deleted)
+
+	return nil
 }
 
 func CreateRow(bk Backend, pairs types.TagPairs, rowData []byte, plaintags []string) (*types.Row, error) {
+	return createRow(bk, pairs, rowData, plaintags, nil, nil)
+}
+
+// CreateRowForRecipients is like CreateRow but seals the new Row's
+// data to recipients' public keys (rather than encrypting it with
+// bk's key) so that only they can read it.  (Its tags are still
+// readable by anyone with bk's key.)  Include your own identity in
+// recipients to be able to read it yourself.
+func CreateRowForRecipients(bk Backend, pairs types.TagPairs, rowData []byte, plaintags []string, recipients []*taber.Keys) (*types.Row, error) {
+	if len(recipients) == 0 {
+		return nil, types.ErrNoRecipients
+	}
+	return createRow(bk, pairs, rowData, plaintags, nil, recipients)
+}
+
+// createRow creates a new Row.  Of plaintags, only those not in
+// oldTags, the tags of the Row it's a new version of (if any), are
+// validated; see validateNewTags.
+func createRow(bk Backend, pairs types.TagPairs, rowData []byte, plaintags, oldTags []string, recipients []*taber.Keys) (*types.Row, error) {
 	if types.Debug {
 		log.Printf("Creating row with data of length %d and tags `%#v`\n",
 			len(rowData), plaintags)
 	}
 
+	if err := validateNewTags(plaintags, oldTags); err != nil {
+		return nil, err
+	}
+
 	row, err := types.NewRow(rowData, plaintags)
 	if err != nil {
 		return nil, err
 	}
 
-	if pairs == nil {
-		pairs, err = bk.AllTagPairs(nil)
-		if err != nil {
-			return nil, err
-		}
+	pairs, err = tagPairsIfNeeded(bk, pairs)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = PopulateRowBeforeSave(bk, row, pairs)
+	_, err = populateRowBeforeSave(bk, row, pairs, recipients)
 	if err != nil {
 		return nil, err
 	}
@@ -111,15 +190,33 @@ func CreateRow(bk Backend, pairs types.TagPairs, rowData []byte, plaintags []str
 		return nil, err
 	}
 
+	indexRows(bk, row)
+
 	return row, nil
 }
 
-func CreateFileRow(bk Backend, pairs types.TagPairs, filename string, plaintags []string) (*types.Row, error) {
-	rowData, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("Error reading file `%s`: %v\n", filename, err)
+// validateNewTags validates (see tagschema.Validate) the tags in
+// plaintags that aren't in oldTags.  Tags carried over from a Row's
+// previous version aren't re-validated, so that Rows tagged before
+// their tags' namespaces were registered, or with tags that were
+// valid then, can still be updated.
+func validateNewTags(plaintags, oldTags []string) error {
+	for _, plain := range plaintags {
+		if fun.SliceContains(oldTags, plain) {
+			continue
+		}
+		if err := tagschema.Validate(plain); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// CreateFileRow creates a new Row containing the contents of
+// filename.  If bk is a StreamBackend, the file is encrypted and
+// saved as a stream rather than being read into memory all at once
+// (see CreateRowFromReader for how that differs).
+func CreateFileRow(bk Backend, pairs types.TagPairs, filename string, plaintags []string) (*types.Row, error) {
 	plaintags = append(plaintags, "type:file", "filename:"+filepath.Base(filename))
 
 	// Add tag based on filetype (e.g., type:pdf)
@@ -128,6 +225,15 @@ func CreateFileRow(bk Backend, pairs types.TagPairs, filename string, plaintags
 		plaintags = append(plaintags, "type:"+fileExt)
 	}
 
+	if _, ok := bk.(StreamBackend); ok {
+		return createFileRowStream(bk, pairs, filename, plaintags, nil)
+	}
+
+	rowData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file `%s`: %v\n", filename, err)
+	}
+
 	return CreateRow(bk, pairs, rowData, plaintags)
 }
 
@@ -147,11 +253,9 @@ func CreateJSONRow(bk Backend, pairs types.TagPairs, obj interface{}, plaintags
 // here.
 func UpdateRow(bk Backend, pairs types.TagPairs, prevIDTag string, newData []byte) (*types.Row, error) {
 	var err error
-	if pairs == nil {
-		pairs, err = bk.AllTagPairs(nil)
-		if err != nil {
-			return nil, err
-		}
+	pairs, err = tagPairsIfNeeded(bk, pairs)
+	if err != nil {
+		return nil, err
 	}
 
 	oldRows, err := ListRowsFromPlainTags(bk, pairs, []string{prevIDTag})
@@ -173,13 +277,21 @@ func UpdateRow(bk Backend, pairs types.TagPairs, prevIDTag string, newData []byt
 // tags that begin with newishTags, remove the "id:...",
 // "created:...", and "all" tags, and will add an "origversionrow:..."
 // tag that points to the ID tag of the original Row being versioned
-// here (or keep the existing "origversionrow:..." tag).
+// here (or keep the existing "origversionrow:..." tag).  Only the tags
+// that oldRow doesn't have are validated.
 //
 // If the plaintags that the new, updated row should have doesn't
 // require any pre-processing, newishTags can simply be
 // oldRow.PlainTags().  (You may want your pre-processing step to add
 // tags like `prevversionrow:...` or user-specified tags.)
 func UpdateRowAdvanced(bk Backend, pairs types.TagPairs, oldRow *types.Row, newData []byte, newishTags []string) (*types.Row, error) {
+	return createRow(bk, pairs, newData, updatedTags(oldRow, newishTags),
+		oldRow.PlainTags(), nil)
+}
+
+// updatedTags returns the plaintags that a new version of oldRow
+// should be created with; see UpdateRowAdvanced.
+func updatedTags(oldRow *types.Row, newishTags []string) []string {
 	var origIDTag string
 
 	var newTags []string
@@ -210,13 +322,12 @@ func UpdateRowAdvanced(bk Backend, pairs types.TagPairs, oldRow *types.Row, newD
 	// versions of this Row), set this new row's origversionrow to
 	// oldRow
 	if origIDTag == "" {
-		oldRowIDTag := rowutil.TagWithPrefix(oldRow, "id:")
-		newTags = append(newTags, "origversionrow:"+oldRowIDTag)
+		newTags = append(newTags, "origversionrow:"+oldRow.IDTag())
 	} else {
 		newTags = append(newTags, origIDTag)
 	}
 
-	return CreateRow(bk, pairs, newData, newTags)
+	return newTags
 }
 
 // UpdateFileRow finds the Row uniquely picked out by prevIDTag then
@@ -226,11 +337,9 @@ func UpdateRowAdvanced(bk Backend, pairs types.TagPairs, oldRow *types.Row, newD
 // origversionrow:... tag).
 func UpdateFileRow(bk Backend, pairs types.TagPairs, prevIDTag string, newFilename string) (*types.Row, error) {
 	var err error
-	if pairs == nil {
-		pairs, err = bk.AllTagPairs(nil)
-		if err != nil {
-			return nil, err
-		}
+	pairs, err = tagPairsIfNeeded(bk, pairs)
+	if err != nil {
+		return nil, err
 	}
 
 	rows, err := ListRowsFromPlainTags(bk, pairs, []string{prevIDTag})
@@ -253,7 +362,7 @@ func UpdateFileRow(bk Backend, pairs types.TagPairs, prevIDTag string, newFilena
 	// Sure this a file?
 	if !oldRow.HasPlainTag("type:file") {
 		return nil, fmt.Errorf(`Row %s is not a file (no "type:file" tag)`,
-			rowutil.TagWithPrefix(oldRow, "id:"))
+			oldRow.IDTag())
 	}
 
 	// Determine which old `type:${file_extension}` tag to remove below
@@ -286,9 +395,13 @@ func UpdateFileRow(bk Backend, pairs types.TagPairs, prevIDTag string, newFilena
 		newTags = append(newTags, "type:"+fileExt)
 	}
 
+	if _, ok := bk.(StreamBackend); ok {
+		return createFileRowStream(bk, pairs, newFilename,
+			updatedTags(oldRow, newTags), oldTags)
+	}
+
 	// Read file data
 
-	// TODO: Need smarter file streaming logic...
 	newData, err := ioutil.ReadFile(newFilename)
 	if err != nil {
 		return nil, err
@@ -308,6 +421,10 @@ func getFileExt(filenameOrPath string) string {
 	return strings.ToLower(fileExt)
 }
 
+// UpdateKey makes newKey the key that bk's Config uses for all new
+// encryption, keeping the previous key in its key ring, without
+// re-encrypting existing Rows or TagPairs; use RotateKey for that.
+//
 // newKey can be of type *[32]byte, []byte (with length 32), or a
 // string to be parsed with keyutil.Parse.
 func UpdateKey(bk Backend, newKey interface{}) error {
@@ -342,7 +459,8 @@ func UpdateKey(bk Backend, newKey interface{}) error {
 		return err
 	}
 
-	cfg.Key = goodKey
+	// Keep the old key around so existing data stays readable
+	cfg.AddKey(goodKey)
 
 	return cfg.Update(cryptag.BackendPath)
 }
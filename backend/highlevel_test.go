@@ -0,0 +1,138 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/cryptag/cryptag/types"
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/stretchr/testify/assert"
+)
+
+// saveUnvalidatedRow saves a new Row tagged with plaintags without
+// validating them, like Rows created before tagschema checked them.
+func saveUnvalidatedRow(t *testing.T, bk Backend, data string, plaintags ...string) *types.Row {
+	row, err := types.NewRow([]byte(data), plaintags)
+	if err != nil {
+		t.Fatalf("Error creating Row: %v", err)
+	}
+	if _, err = PopulateRowBeforeSave(bk, row, nil); err != nil {
+		t.Fatalf("Error populating Row: %v", err)
+	}
+	if err = bk.SaveRow(row); err != nil {
+		t.Fatalf("Error saving Row: %v", err)
+	}
+	return row
+}
+
+func TestUpdateRowInvalidOldTags(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	_, err := CreateRow(m, nil, []byte("new"), []string{"size:big"})
+	assert.NotNil(t, err)
+
+	old := saveUnvalidatedRow(t, m, "old", "size:big", "type:", "notes")
+
+	// Tags carried over from the old version aren't re-validated...
+	row, err := UpdateRow(m, nil, old.IDTag(), []byte("updated"))
+	if assert.Nil(t, err) {
+		assert.True(t, row.HasPlainTag("size:big"))
+		assert.True(t, row.HasPlainTag("origversionrow:"+old.IDTag()))
+	}
+
+	// ...but new ones are
+	_, err = UpdateRowAdvanced(m, nil, old, []byte("updated"),
+		append(old.PlainTags(), "when:someday"))
+	assert.NotNil(t, err)
+
+	_, err = UpdateRowAdvanced(m, nil, old, []byte("updated"),
+		append(old.PlainTags(), "when:20170427"))
+	assert.Nil(t, err)
+}
+
+func TestUpdateFileRowInvalidOldTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryptag-update-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "notes.txt")
+	if err = ioutil.WriteFile(filename, []byte("new notes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := NewFileSystem(&Config{Name: t.Name(), DataPath: path.Join(dir, "data")})
+	if err != nil {
+		t.Fatalf("Error creating FileSystem backend: %v", err)
+	}
+
+	for _, bk := range []Backend{newTestMemory(t, Settings{}), fs} {
+		old := saveUnvalidatedRow(t, bk, "old notes", "size:big", "type:file",
+			"filename:notes.txt", "type:txt")
+
+		row, err := UpdateFileRow(bk, nil, old.IDTag(), filename)
+		if assert.Nil(t, err, bk.Name()) {
+			assert.True(t, row.HasPlainTag("size:big"), bk.Name())
+			assert.True(t, row.HasPlainTag("type:file"), bk.Name())
+		}
+	}
+}
+
+func TestCreateRowForRecipients(t *testing.T) {
+	me := useTestIdentity(t)
+	m := newTestMemory(t, Settings{})
+
+	alice, err := taber.RandomKey()
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	bob, err := taber.RandomKey()
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+
+	_, err = CreateRowForRecipients(m, nil, []byte("new"), []string{"shared"}, nil)
+	assert.Equal(t, types.ErrNoRecipients, err)
+
+	mustCreateRow(t, m, nil, "everyone", "shared")
+	_, err = CreateRowForRecipients(m, nil, []byte("alice and bob"),
+		[]string{"shared"}, []*taber.Keys{alice, bob})
+	if err != nil {
+		t.Fatalf("Error creating sealed Row: %v", err)
+	}
+	_, err = CreateRowForRecipients(m, nil, []byte("alice and me"),
+		[]string{"shared"}, []*taber.Keys{alice, me})
+	if err != nil {
+		t.Fatalf("Error creating sealed Row: %v", err)
+	}
+
+	// Rows sealed to other users are skipped...
+	rows, err := RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"alice and me", "everyone"}, decryptedData(rows))
+	}
+
+	// ...and can't be decrypted when fetched directly
+	randtags, pairs := rowRandomTags(t, m, "shared")
+	all, err := m.RowsFromRandomTags(randtags)
+	if assert.Nil(t, err) && assert.Equal(t, 3, len(all)) {
+		assert.Equal(t, 2, len(all.Readable()))
+		assert.NotNil(t, all.Populate(m.Key(), pairs))
+	}
+
+	// Until this user has one of their identities
+	if err = types.AddIdentity(alice); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"alice and bob", "alice and me", "everyone"},
+			decryptedData(rows))
+	}
+}
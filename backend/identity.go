@@ -0,0 +1,149 @@
+// Steve Phillips / elimisteve
+// 2017.04.12
+
+package backend
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/cryptag/go-minilock/taber"
+)
+
+var (
+	ErrIdentityExists = errors.New("Identity already exists")
+	ErrNoSigningKey   = errors.New("Signing Rows requires an identity; create one with `cryptag identity`")
+)
+
+// An identity is this user's Curve25519 keypair, whose public key
+// (encoded as a miniLock ID) others can seal Rows to; see
+// CreateRowForRecipients.  Its Ed25519 signing key, used to sign
+// Rows (see Settings.Sign), is derived from its private key.
+
+type identityFile struct {
+	Private []byte `json:"private"`
+	Public  []byte `json:"public"`
+}
+
+// IdentityFile returns the path to the file storing this user's
+// identity.
+func IdentityFile() string {
+	return path.Join(cryptag.TrustedBasePath, "identity.json")
+}
+
+// CreateIdentity generates a new, random identity for this user and
+// saves it to IdentityFile.
+func CreateIdentity() (*taber.Keys, error) {
+	if _, err := os.Stat(IdentityFile()); err == nil {
+		return nil, ErrIdentityExists
+	}
+
+	keys, err := taber.RandomKey()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(identityFile{Private: keys.Private, Public: keys.Public})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(path.Dir(IdentityFile()), 0700); err != nil {
+		return nil, err
+	}
+
+	if err = ioutil.WriteFile(IdentityFile(), b, 0600); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// LoadIdentity loads this user's identity from IdentityFile.
+func LoadIdentity() (*taber.Keys, error) {
+	b, err := ioutil.ReadFile(IdentityFile())
+	if err != nil {
+		return nil, err
+	}
+
+	var id identityFile
+	if err = json.Unmarshal(b, &id); err != nil {
+		return nil, fmt.Errorf("Error parsing identity file `%s`: %v",
+			IdentityFile(), err)
+	}
+
+	keys := &taber.Keys{Private: id.Private, Public: id.Public}
+	if !keys.HasPrivate() || !keys.HasPublic() {
+		return nil, fmt.Errorf("Identity file `%s` contains invalid keys",
+			IdentityFile())
+	}
+
+	return keys, nil
+}
+
+// UseIdentity loads this user's identity, if one exists, so that Rows
+// sealed to it can be decrypted.
+func UseIdentity() error {
+	keys, err := LoadIdentity()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err = types.AddIdentity(keys); err != nil {
+		return err
+	}
+
+	signer.mu.Lock()
+	signer.key = SigningKey(keys)
+	signer.mu.Unlock()
+
+	return nil
+}
+
+// signer holds the signing key of the identity loaded by UseIdentity.
+var signer struct {
+	mu  sync.RWMutex
+	key ed25519.PrivateKey
+}
+
+func signingKey() ed25519.PrivateKey {
+	signer.mu.RLock()
+	defer signer.mu.RUnlock()
+
+	return signer.key
+}
+
+// SigningKey derives the Ed25519 key that the identity keys signs
+// Rows with.
+func SigningKey(keys *taber.Keys) ed25519.PrivateKey {
+	mac := hmac.New(sha256.New, keys.Private)
+	mac.Write([]byte("cryptag signing key"))
+	return ed25519.NewKeyFromSeed(mac.Sum(nil))
+}
+
+// ParseRecipients parses ids, a list of miniLock IDs, into public
+// keys that Rows can be sealed to.
+func ParseRecipients(ids []string) ([]*taber.Keys, error) {
+	recipients := make([]*taber.Keys, 0, len(ids))
+	for _, id := range ids {
+		keys, err := taber.FromID(strings.TrimSpace(id))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid recipient ID `%s`: %v", id, err)
+		}
+		recipients = append(recipients, keys)
+	}
+	return recipients, nil
+}
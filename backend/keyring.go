@@ -0,0 +1,22 @@
+// Steve Phillips / elimisteve
+// 2017.04.05
+
+package backend
+
+import "github.com/cryptag/cryptag"
+
+// HasKeyRing is implemented by Backends that, in addition to Key(),
+// know of older keys that previously-saved data may be encrypted
+// with.
+type HasKeyRing interface {
+	KeyRing() cryptag.KeyRing
+}
+
+// KeyRing returns bk's key ring if it has one, otherwise a key ring
+// containing only bk.Key().
+func KeyRing(bk Backend) cryptag.KeyRing {
+	if kr, ok := bk.(HasKeyRing); ok {
+		return kr.KeyRing()
+	}
+	return cryptag.KeyRing{bk.Key()}
+}
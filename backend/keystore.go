@@ -0,0 +1,432 @@
+// Steve Phillips / elimisteve
+// 2017.04.03
+
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cryptag/cryptag"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	KDFScrypt = "scrypt"
+
+	scryptSaltLength = 32
+)
+
+var (
+	ErrConfigLocked    = errors.New("Backend config is passphrase-protected and still locked")
+	ErrConfigNotLocked = errors.New("Backend config is not passphrase-protected")
+	ErrWrongPassphrase = errors.New("Wrong passphrase for backend config")
+	ErrEmptyPassphrase = errors.New("Passphrase cannot be empty")
+	ErrPassphraseMatch = errors.New("Passphrases don't match")
+	ErrUnknownKDF      = errors.New("Unknown key derivation function")
+)
+
+// Default scrypt cost parameters used when locking a Config.  The
+// parameters actually used are stored alongside each LockedKey, so
+// these can be raised later without breaking existing Configs.
+var (
+	ScryptN = 1 << 15
+	ScryptR = 8
+	ScryptP = 1
+)
+
+// PassphraseFunc is called to get the passphrase for the
+// passphrase-protected Config named configName whenever one is
+// unlocked by ReadConfig or PromptUnlock.  Defaults to
+// PromptPassphrase.
+var PassphraseFunc = PromptPassphrase
+
+// LockedKey holds a Backend's key encrypted with a key derived from a
+// passphrase.  When a Config has a LockedKey, its Key is never written
+// to disk.
+type LockedKey struct {
+	KDF       string    `json:"kdf"`
+	Salt      []byte    `json:"salt"`
+	N         int       `json:"n"`
+	R         int       `json:"r"`
+	P         int       `json:"p"`
+	Nonce     *[24]byte `json:"nonce"`
+	Encrypted []byte    `json:"encrypted"`
+}
+
+// lockedSecrets is what gets encrypted into LockedKey.Encrypted
+type lockedSecrets struct {
+	Key     *[32]byte   `json:"key"`
+	OldKeys []*[32]byte `json:"old_keys,omitempty"`
+}
+
+func newLockedKey() (*LockedKey, error) {
+	salt := make([]byte, scryptSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	lk := &LockedKey{
+		KDF:  KDFScrypt,
+		Salt: salt,
+		N:    ScryptN,
+		R:    ScryptR,
+		P:    ScryptP,
+	}
+	return lk, nil
+}
+
+// deriveKey derives the key that encrypts lk's secrets from passphrase.
+func (lk *LockedKey) deriveKey(passphrase string) (*[32]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	if lk.KDF != KDFScrypt {
+		return nil, ErrUnknownKDF
+	}
+
+	b, err := scrypt.Key([]byte(passphrase), lk.Salt, lk.N, lk.R, lk.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Error deriving key from passphrase: %v", err)
+	}
+
+	return cryptag.ConvertKey(b)
+}
+
+func (lk *LockedKey) seal(wrapKey *[32]byte, secrets *lockedSecrets) error {
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := cryptag.RandomNonce()
+	if err != nil {
+		return err
+	}
+
+	enc, err := cryptag.Encrypt(b, nonce, wrapKey)
+	if err != nil {
+		return err
+	}
+
+	lk.Nonce = nonce
+	lk.Encrypted = enc
+
+	return nil
+}
+
+func (lk *LockedKey) open(wrapKey *[32]byte) (*lockedSecrets, error) {
+	b, err := cryptag.Decrypt(lk.Encrypted, lk.Nonce, wrapKey)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var secrets lockedSecrets
+	if err = json.Unmarshal(b, &secrets); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling locked key: %v", err)
+	}
+	if secrets.Key == nil {
+		return nil, cryptag.ErrNilKey
+	}
+
+	return &secrets, nil
+}
+
+//
+// Config methods
+//
+
+// Protected returns whether conf's key is stored passphrase-protected.
+func (conf *Config) Protected() bool {
+	return conf.LockedKey != nil
+}
+
+// Locked returns whether conf is passphrase-protected and has not
+// yet been unlocked, in which case conf.Key is nil.
+func (conf *Config) Locked() bool {
+	return conf.LockedKey != nil && conf.Key == nil
+}
+
+// Lock passphrase-protects conf's key with a key derived from
+// passphrase.  Changes are not persisted; see LockConfig.
+func (conf *Config) Lock(passphrase string) error {
+	if conf.Key == nil {
+		return cryptag.ErrNilKey
+	}
+
+	lk, err := newLockedKey()
+	if err != nil {
+		return err
+	}
+
+	wrapKey, err := lk.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err = lk.seal(wrapKey, conf.secrets()); err != nil {
+		return err
+	}
+
+	conf.LockedKey = lk
+	conf.wrapKey = wrapKey
+
+	unlocked.add(conf.Name, wrapKey)
+
+	return nil
+}
+
+// Unlock decrypts conf's passphrase-protected key using passphrase,
+// setting conf.Key.  Returns ErrWrongPassphrase if passphrase is
+// incorrect.
+func (conf *Config) Unlock(passphrase string) error {
+	if conf.LockedKey == nil {
+		return ErrConfigNotLocked
+	}
+
+	wrapKey, err := conf.LockedKey.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := conf.LockedKey.open(wrapKey)
+	if err != nil {
+		return err
+	}
+
+	conf.Key = secrets.Key
+	conf.OldKeys = secrets.OldKeys
+	conf.wrapKey = wrapKey
+
+	unlocked.add(conf.Name, wrapKey)
+
+	return nil
+}
+
+// relock re-encrypts conf.Key (and conf.OldKeys) into conf.LockedKey so that a changed
+// key is what gets saved.
+func (conf *Config) relock() error {
+	if conf.wrapKey == nil {
+		return ErrConfigLocked
+	}
+	return conf.LockedKey.seal(conf.wrapKey, conf.secrets())
+}
+
+func (conf *Config) secrets() *lockedSecrets {
+	return &lockedSecrets{Key: conf.Key, OldKeys: conf.OldKeys}
+}
+
+// keepLock ensures that overwriting the passphrase-protected Config
+// stored at filename with conf (which may have come from
+// Backend.ToConfig) keeps it passphrase-protected rather than
+// writing conf.Key to disk.
+func (conf *Config) keepLock(filename string) error {
+	if conf.LockedKey != nil {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var onDisk Config
+	if err = json.Unmarshal(b, &onDisk); err != nil {
+		return fmt.Errorf("Error unmarshaling config file `%v`: %v",
+			filename, err)
+	}
+	if onDisk.LockedKey == nil {
+		return nil
+	}
+
+	// Only re-lock with a key that can open what's on disk
+	wrapKey := unlocked.get(conf.Name)
+	if wrapKey == nil {
+		return ErrConfigLocked
+	}
+	if _, err = onDisk.LockedKey.open(wrapKey); err != nil {
+		return ErrConfigLocked
+	}
+
+	conf.LockedKey = onDisk.LockedKey
+	conf.wrapKey = wrapKey
+
+	return nil
+}
+
+// PromptUnlock unlocks conf, if it is locked, with the passphrase
+// that PassphraseFunc returns for it.
+func (conf *Config) PromptUnlock() error {
+	if !conf.Locked() {
+		return nil
+	}
+
+	pass, err := PassphraseFunc(conf.Name)
+	if err != nil {
+		return err
+	}
+
+	return conf.Unlock(pass)
+}
+
+//
+// Convenience Functions
+//
+
+// LockConfig passphrase-protects conf, an unlocked Config in
+// backendPath, and overwrites it on disk without making a backup of
+// the unprotected version.  Existing backups containing the
+// unprotected key are logged so the user can delete them.
+func LockConfig(backendPath string, conf *Config, passphrase string) error {
+	if backendPath == "" {
+		backendPath = cryptag.BackendPath
+	}
+
+	if err := conf.Canonicalize(); err != nil {
+		return err
+	}
+	if err := conf.Lock(passphrase); err != nil {
+		return err
+	}
+
+	filename := ConfigPathFromName(backendPath, conf.Name)
+
+	if err := conf.write(filename); err != nil {
+		return err
+	}
+
+	backups, _ := filepath.Glob(filename + "-*")
+	for _, bkup := range backups {
+		b, err := ioutil.ReadFile(bkup)
+		if err != nil {
+			continue
+		}
+		var old Config
+		if err = json.Unmarshal(b, &old); err == nil && old.LockedKey == nil {
+			log.Printf("Backup %v contains the unprotected key; delete it"+
+				" if no longer needed\n", bkup)
+		}
+	}
+
+	return nil
+}
+
+// RemoveLock removes passphrase protection from conf, an unlocked
+// Config in backendPath, and saves it to disk with its key
+// unprotected.
+func RemoveLock(backendPath string, conf *Config) error {
+	if backendPath == "" {
+		backendPath = cryptag.BackendPath
+	}
+
+	if !conf.Protected() {
+		return ErrConfigNotLocked
+	}
+	if conf.Locked() {
+		return ErrConfigLocked
+	}
+
+	conf.LockedKey = nil
+	conf.wrapKey = nil
+
+	unlocked.remove(conf.Name)
+
+	return conf.write(ConfigPathFromName(backendPath, conf.Name))
+}
+
+// PromptPassphrase returns the value of the BACKEND_PASSPHRASE
+// environment variable if set, otherwise prompts for the passphrase
+// to the Config named configName on the terminal.
+func PromptPassphrase(configName string) (string, error) {
+	if pass := os.Getenv("BACKEND_PASSPHRASE"); pass != "" {
+		return pass, nil
+	}
+
+	return readPassphrase(fmt.Sprintf("Passphrase for backend `%s`: ",
+		configName))
+}
+
+// PromptNewPassphrase returns the value of the BACKEND_PASSPHRASE
+// environment variable if set, otherwise prompts for a new passphrase
+// twice on the terminal and makes sure both match.
+func PromptNewPassphrase() (string, error) {
+	if pass := os.Getenv("BACKEND_PASSPHRASE"); pass != "" {
+		return pass, nil
+	}
+
+	pass, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass == "" {
+		return "", ErrEmptyPassphrase
+	}
+
+	again, err := readPassphrase("Repeat new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass != again {
+		return "", ErrPassphraseMatch
+	}
+
+	return pass, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", ErrConfigLocked
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("Error reading passphrase: %v", err)
+	}
+
+	return string(b), nil
+}
+
+//
+// Unlocked key cache
+//
+
+// unlockedKeys remembers the passphrase-derived keys of unlocked
+// Configs so that Configs created with Backend.ToConfig can be saved
+// without losing their passphrase protection.
+type unlockedKeys struct {
+	mu   sync.RWMutex
+	keys map[string]*[32]byte
+}
+
+var unlocked = unlockedKeys{keys: map[string]*[32]byte{}}
+
+func (u *unlockedKeys) add(name string, wrapKey *[32]byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.keys[name] = wrapKey
+}
+
+func (u *unlockedKeys) get(name string) *[32]byte {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.keys[name]
+}
+
+func (u *unlockedKeys) remove(name string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.keys, name)
+}
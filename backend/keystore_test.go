@@ -0,0 +1,206 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// usePassphrase makes ReadConfig unlock Configs with pass rather than
+// prompting for it.
+func usePassphrase(t *testing.T, pass string) {
+	orig := PassphraseFunc
+	PassphraseFunc = func(string) (string, error) { return pass, nil }
+	t.Cleanup(func() { PassphraseFunc = orig })
+}
+
+// assertKeyNotOnDisk asserts that the saved Config named name is
+// locked and doesn't contain key.
+func assertKeyNotOnDisk(t *testing.T, name string, key *[32]byte) {
+	b, err := ioutil.ReadFile(ConfigPathFromName(cryptag.BackendPath, name))
+	if !assert.Nil(t, err) {
+		return
+	}
+	keyB, _ := json.Marshal(key)
+	assert.NotContains(t, string(b), string(keyB))
+
+	conf, err := ReadLockedConfig(cryptag.BackendPath, name)
+	if assert.Nil(t, err) {
+		assert.True(t, conf.Locked())
+	}
+}
+
+func TestConfigLockUnlock(t *testing.T) {
+	conf := &Config{Name: t.Name(), Type: TypeMemory}
+	if err := conf.Canonicalize(); err != nil {
+		t.Fatal(err)
+	}
+	key := conf.Key
+	oldKey, _ := cryptag.RandomKey()
+	conf.OldKeys = []*[32]byte{oldKey}
+
+	assert.Equal(t, ErrConfigNotLocked, conf.Unlock(testPassphrase))
+	assert.Equal(t, ErrEmptyPassphrase, conf.Lock(""))
+
+	if !assert.Nil(t, conf.Lock(testPassphrase)) {
+		return
+	}
+	assert.True(t, conf.Protected())
+	assert.False(t, conf.Locked())
+
+	// As read from disk
+	conf.Key, conf.OldKeys = nil, nil
+	assert.True(t, conf.Locked())
+
+	assert.Equal(t, ErrWrongPassphrase, conf.Unlock("wrong"))
+	assert.Equal(t, ErrEmptyPassphrase, conf.Unlock(""))
+	assert.True(t, conf.Locked())
+
+	if assert.Nil(t, conf.Unlock(testPassphrase)) {
+		assert.Equal(t, key, conf.Key)
+		assert.Equal(t, []*[32]byte{oldKey}, conf.OldKeys)
+		assert.False(t, conf.Locked())
+	}
+}
+
+func TestLockConfig(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	conf, err := m.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.Nil(t, LockConfig(cryptag.BackendPath, conf, testPassphrase)) {
+		return
+	}
+	assertKeyNotOnDisk(t, m.Name(), m.Key())
+
+	usePassphrase(t, "wrong")
+	_, err = ReadConfig(cryptag.BackendPath, m.Name())
+	assert.NotNil(t, err)
+
+	usePassphrase(t, testPassphrase)
+	read, err := ReadConfig(cryptag.BackendPath, m.Name())
+	if assert.Nil(t, err) {
+		assert.Equal(t, m.Key(), read.Key)
+	}
+}
+
+func TestLockConfigKeepLock(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	conf, err := m.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = LockConfig(cryptag.BackendPath, conf, testPassphrase); err != nil {
+		t.Fatalf("Error locking Config: %v", err)
+	}
+
+	// Backends don't know their Configs are locked, but saving them
+	// keeps them locked, even after a key change
+	newKey, _ := cryptag.RandomKey()
+	m.key, m.oldKeys = newKey, []*[32]byte{conf.Key}
+
+	fromBk, err := m.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Nil(t, fromBk.Update(cryptag.BackendPath)) {
+		return
+	}
+	assertKeyNotOnDisk(t, m.Name(), newKey)
+	assertKeyNotOnDisk(t, m.Name(), conf.Key)
+
+	usePassphrase(t, testPassphrase)
+	read, err := ReadConfig(cryptag.BackendPath, m.Name())
+	if assert.Nil(t, err) {
+		assert.Equal(t, newKey, read.Key)
+		assert.Equal(t, []*[32]byte{conf.Key}, read.OldKeys)
+	}
+
+	// Without having unlocked it, there's no way to keep it locked,
+	// so it isn't overwritten
+	unlocked.remove(m.Name())
+	fromBk, _ = m.ToConfig()
+	assert.Equal(t, ErrConfigLocked, fromBk.Update(cryptag.BackendPath))
+	assertKeyNotOnDisk(t, m.Name(), newKey)
+}
+
+func TestRemoveLock(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	conf, err := m.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ErrConfigNotLocked, RemoveLock(cryptag.BackendPath, conf))
+
+	if err = LockConfig(cryptag.BackendPath, conf, testPassphrase); err != nil {
+		t.Fatalf("Error locking Config: %v", err)
+	}
+
+	locked, err := ReadLockedConfig(cryptag.BackendPath, m.Name())
+	if assert.Nil(t, err) {
+		assert.Equal(t, ErrConfigLocked, RemoveLock(cryptag.BackendPath, locked))
+	}
+
+	if !assert.Nil(t, RemoveLock(cryptag.BackendPath, conf)) {
+		return
+	}
+
+	read, err := ReadLockedConfig(cryptag.BackendPath, m.Name())
+	if assert.Nil(t, err) {
+		assert.False(t, read.Protected())
+		assert.Equal(t, m.Key(), read.Key)
+	}
+
+	// Saving it again doesn't re-lock it
+	fromBk, _ := m.ToConfig()
+	assert.Nil(t, fromBk.Update(cryptag.BackendPath))
+	read, err = ReadLockedConfig(cryptag.BackendPath, m.Name())
+	if assert.Nil(t, err) {
+		assert.False(t, read.Protected())
+	}
+}
+
+func TestReadBackendsSkipsLocked(t *testing.T) {
+	for _, name := range []string{"open", "locked"} {
+		conf := &Config{Name: t.Name() + "-" + name, Type: TypeMemory}
+		if err := conf.Canonicalize(); err != nil {
+			t.Fatal(err)
+		}
+		if err := conf.Save(cryptag.BackendPath); err != nil {
+			t.Fatal(err)
+		}
+		if name == "locked" {
+			if err := LockConfig(cryptag.BackendPath, conf, testPassphrase); err != nil {
+				t.Fatalf("Error locking Config: %v", err)
+			}
+		}
+	}
+
+	// Never prompted for
+	orig := PassphraseFunc
+	PassphraseFunc = func(string) (string, error) {
+		t.Error("PassphraseFunc called")
+		return testPassphrase, nil
+	}
+	defer func() { PassphraseFunc = orig }()
+
+	bks, err := ReadBackends(cryptag.BackendPath, t.Name()+"-*")
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(bks)) {
+		assert.Equal(t, t.Name()+"-open", bks[0].Name())
+	}
+
+	bks, err = ReadBackends(cryptag.BackendPath, t.Name()+"-locked")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(bks))
+}
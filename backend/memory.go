@@ -0,0 +1,515 @@
+// Steve Phillips / elimisteve
+// 2017.04.26
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/elimisteve/fun"
+)
+
+var (
+	ErrNoSnapshotPath = errors.New("backend: no snapshot filename given or configured")
+)
+
+func init() {
+	RegisterMaker(TypeMemory, func(cfg *Config) (Backend, error) {
+		return NewMemory(cfg)
+	})
+}
+
+// Memory is a Backend that stores everything in memory, which makes
+// it useful for tests and for apps that shouldn't touch the disk.  It
+// is safe for concurrent use.
+//
+// A Memory's contents can be saved to and loaded from an encrypted
+// snapshot file (see WriteSnapshot and ReadSnapshot); if its Config's
+// DataPath is set, the snapshot there (if any) is loaded when it's
+// created.
+type Memory struct {
+	name     string
+	dataPath string // Snapshot filename; optional
+	key      *[32]byte
+	oldKeys  []*[32]byte
+	settings Settings
+
+	mu    sync.RWMutex
+	pairs map[string]*types.TagPair // RandomTag -> encrypted TagPair
+	rows  []*types.Row              // Oldest first
+}
+
+// memorySnapshot is what a Memory's snapshot file contains once
+// decrypted.
+type memorySnapshot struct {
+	TagPairs types.TagPairs `json:"tag_pairs"`
+	Rows     types.Rows     `json:"rows"`
+}
+
+// memorySnapshotFile is how a Memory's snapshot is stored on disk.
+type memorySnapshotFile struct {
+	KeyID     string    `json:"key_id"`
+	Nonce     *[24]byte `json:"nonce"`
+	Encrypted []byte    `json:"encrypted"`
+}
+
+// NewMemory returns a new, empty Memory backend configured by conf,
+// loading the snapshot at conf.DataPath if there is one.  conf must
+// be unlocked first if it's passphrase-protected (see Config.Unlock).
+func NewMemory(conf *Config) (*Memory, error) {
+	if err := conf.Canonicalize(); err != nil {
+		return nil, err
+	}
+	if conf.Locked() {
+		return nil, ErrConfigLocked
+	}
+
+	m := &Memory{
+		name:     conf.Name,
+		dataPath: conf.DataPath,
+		key:      conf.Key,
+		oldKeys:  conf.OldKeys,
+		settings: conf.Settings,
+
+		pairs: map[string]*types.TagPair{},
+	}
+
+	if m.dataPath != "" {
+		err := m.ReadSnapshot("")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Memory) Name() string {
+	return m.name
+}
+
+func (m *Memory) Key() *[32]byte {
+	return m.key
+}
+
+func (m *Memory) KeyRing() cryptag.KeyRing {
+	return append(append(cryptag.KeyRing{}, m.oldKeys...), m.key)
+}
+
+func (m *Memory) Settings() Settings {
+	return m.settings
+}
+
+func (m *Memory) ToConfig() (*Config, error) {
+	config := Config{
+		Name:     m.name,
+		Type:     TypeMemory,
+		Key:      m.key,
+		OldKeys:  m.oldKeys,
+		Local:    true,
+		DataPath: m.dataPath,
+		Settings: m.settings,
+	}
+	return &config, nil
+}
+
+//
+// TagPairs
+//
+
+func (m *Memory) AllTagPairs(oldPairs types.TagPairs) (types.TagPairs, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Only decrypt TagPairs that oldPairs doesn't have
+	known := types.NewTagPairIndex(oldPairs)
+
+	pairs := make(types.TagPairs, 0, len(m.pairs))
+	for random, stored := range m.pairs {
+		if pair, ok := known.ByRandom(random); ok {
+			pairs = append(pairs, pair)
+			continue
+		}
+
+		pair, err := m.decryptedPair(stored)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
+// TagPairsFromRandomTags returns the TagPairs with the given
+// RandomTags, skipping those that don't exist.
+func (m *Memory) TagPairsFromRandomTags(randtags cryptag.RandomTags) (types.TagPairs, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pairs types.TagPairs
+	for _, random := range randtags {
+		stored, ok := m.pairs[random]
+		if !ok {
+			continue
+		}
+
+		pair, err := m.decryptedPair(stored)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
+func (m *Memory) SaveTagPair(pair *types.TagPair) error {
+	if len(pair.PlainEncrypted) == 0 || len(pair.Random) == 0 || pair.Nonce == nil || *pair.Nonce == [24]byte{} {
+		return errors.New("Invalid tag pair; requires plain_encrypted, random, and nonce fields")
+	}
+	if err := ValidRandomTag(pair.Random); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pairs[pair.Random] = copyTagPair(pair)
+	return nil
+}
+
+// DeleteTagPairs deletes the TagPairs with the given RandomTags.
+func (m *Memory) DeleteTagPairs(randtags cryptag.RandomTags) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, random := range randtags {
+		delete(m.pairs, random)
+	}
+	return nil
+}
+
+//
+// Rows
+//
+
+func (m *Memory) ListRows(randtags cryptag.RandomTags) (types.Rows, error) {
+	if len(randtags) == 0 {
+		return nil, errors.New("Must query by 1 or more tags")
+	}
+	return m.rowsFromRandomTagsPage(randtags, false, types.PageOptions{})
+}
+
+func (m *Memory) RowsFromRandomTags(randtags cryptag.RandomTags) (types.Rows, error) {
+	if len(randtags) == 0 {
+		return nil, errors.New("Must query by 1 or more tags")
+	}
+	return m.rowsFromRandomTagsPage(randtags, true, types.PageOptions{})
+}
+
+// SaveRow saves row, replacing the Row with the same RandomTags (in
+// the same order), if any.
+func (m *Memory) SaveRow(row *types.Row) error {
+	if len(row.Encrypted) == 0 || len(row.RandomTags) == 0 || row.Nonce == nil || *row.Nonce == [24]byte{} {
+		return errors.New("Invalid row; requires Encrypted, RandomTags, Nonce fields")
+	}
+	if err := validRandomTags(row.RandomTags); err != nil {
+		return err
+	}
+
+	saved := copyRow(row, true)
+	key := strings.Join(row.RandomTags, "-")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Like re-saved row files, re-saved Rows keep their place in
+	// line (see types.PageOptions)
+	for i, existing := range m.rows {
+		if strings.Join(existing.RandomTags, "-") == key {
+			m.rows[i] = saved
+			return nil
+		}
+	}
+
+	m.rows = append(m.rows, saved)
+	return nil
+}
+
+// DeleteRows deletes the Rows having all of randtags.
+func (m *Memory) DeleteRows(randtags cryptag.RandomTags) error {
+	if len(randtags) == 0 {
+		return fmt.Errorf("Must query by 1 or more tags")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := make([]*types.Row, 0, len(m.rows))
+	for _, row := range m.rows {
+		if !fun.SliceContainsAll(row.RandomTags, randtags) {
+			kept = append(kept, row)
+		}
+	}
+
+	if len(kept) == len(m.rows) {
+		return types.ErrRowsNotFound
+	}
+
+	m.rows = kept
+	return nil
+}
+
+// RowsFromRandomQuery returns the Rows matching q.
+func (m *Memory) RowsFromRandomQuery(q *RandomQuery) (types.Rows, error) {
+	return m.rowsMatchingPage(q.Matches, true, types.PageOptions{})
+}
+
+// ListRowsFromRandomQuery returns the Rows matching q, without their
+// data.
+func (m *Memory) ListRowsFromRandomQuery(q *RandomQuery) (types.Rows, error) {
+	return m.rowsMatchingPage(q.Matches, false, types.PageOptions{})
+}
+
+// RowsByIDs returns the Rows having any of idRandTags.
+func (m *Memory) RowsByIDs(idRandTags cryptag.RandomTags) (types.Rows, error) {
+	q := &RandomQuery{Op: OpTag, RandomTags: idRandTags}
+	return m.rowsMatchingPage(q.Matches, true, types.PageOptions{})
+}
+
+// ListRowsPage is like ListRows but only returns the Rows that opts
+// selects.
+func (m *Memory) ListRowsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error) {
+	if len(randtags) == 0 {
+		return nil, errors.New("Must query by 1 or more tags")
+	}
+	return m.rowsFromRandomTagsPage(randtags, false, opts)
+}
+
+// RowsFromRandomTagsPage is like RowsFromRandomTags but only returns
+// the Rows that opts selects.
+func (m *Memory) RowsFromRandomTagsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error) {
+	if len(randtags) == 0 {
+		return nil, errors.New("Must query by 1 or more tags")
+	}
+	return m.rowsFromRandomTagsPage(randtags, true, opts)
+}
+
+//
+// Snapshots
+//
+
+// WriteSnapshot encrypts m's contents with its newest key and saves
+// them to filename, or to m's DataPath if filename is empty.
+func (m *Memory) WriteSnapshot(filename string) error {
+	if filename == "" {
+		filename = m.dataPath
+	}
+	if filename == "" {
+		return ErrNoSnapshotPath
+	}
+	if m.key == nil {
+		return cryptag.ErrNilKey
+	}
+
+	m.mu.RLock()
+	snap := memorySnapshot{
+		TagPairs: make(types.TagPairs, 0, len(m.pairs)),
+		Rows:     m.rows,
+	}
+	for _, pair := range m.pairs {
+		snap.TagPairs = append(snap.TagPairs, pair)
+	}
+	plain, err := json.Marshal(&snap)
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	nonce, err := cryptag.RandomNonce()
+	if err != nil {
+		return err
+	}
+
+	enc, err := cryptag.EncryptVersioned(plain, nonce, m.key)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&memorySnapshotFile{
+		KeyID:     cryptag.KeyID(m.key),
+		Nonce:     nonce,
+		Encrypted: enc,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, b, 0600)
+}
+
+// ReadSnapshot replaces m's contents with those of the snapshot saved
+// (by WriteSnapshot) to filename, or to m's DataPath if filename is
+// empty.
+func (m *Memory) ReadSnapshot(filename string) error {
+	if filename == "" {
+		filename = m.dataPath
+	}
+	if filename == "" {
+		return ErrNoSnapshotPath
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var f memorySnapshotFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("Error unmarshaling snapshot: %v", err)
+	}
+
+	keys, err := m.KeyRing().Candidates(f.KeyID)
+	if err != nil {
+		return fmt.Errorf("Error decrypting snapshot: %v", err)
+	}
+
+	var plain []byte
+	for _, key := range keys {
+		if plain, err = cryptag.DecryptVersioned(f.Encrypted, f.Nonce, key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Error decrypting snapshot: %v", err)
+	}
+
+	var snap memorySnapshot
+	if err = json.Unmarshal(plain, &snap); err != nil {
+		return fmt.Errorf("Error unmarshaling snapshot: %v", err)
+	}
+
+	pairs := make(map[string]*types.TagPair, len(snap.TagPairs))
+	for _, pair := range snap.TagPairs {
+		pairs[pair.Random] = pair
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pairs = pairs
+	m.rows = snap.Rows
+
+	return nil
+}
+
+//
+// Helpers
+//
+
+func (m *Memory) rowsFromRandomTagsPage(randtags cryptag.RandomTags, includeData bool, opts types.PageOptions) (types.Rows, error) {
+	hasAll := func(rowTags []string) bool {
+		return fun.SliceContainsAll(rowTags, randtags)
+	}
+	return m.rowsMatchingPage(hasAll, includeData, opts)
+}
+
+// rowsMatchingPage returns copies of the Rows whose RandomTags satisfy
+// match that opts selects, with their data if includeData.
+func (m *Memory) rowsMatchingPage(match func(rowTags []string) bool, includeData bool, opts types.PageOptions) (types.Rows, error) {
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches types.Rows
+	for _, row := range m.rows {
+		if match(row.RandomTags) {
+			matches = append(matches, row)
+		}
+	}
+
+	if opts.Order == types.OrderNewest {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+
+	start, end := opts.Bounds(len(matches))
+
+	rows := make(types.Rows, 0, end-start)
+	for _, row := range matches[start:end] {
+		rows = append(rows, copyRow(row, includeData))
+	}
+
+	if len(rows) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	return rows, nil
+}
+
+// decryptedPair returns a copy of stored with its PlainTag decrypted.
+func (m *Memory) decryptedPair(stored *types.TagPair) (*types.TagPair, error) {
+	pair := copyTagPair(stored)
+	if err := pair.DecryptWithKeyRing(m.KeyRing()); err != nil {
+		return nil, fmt.Errorf("Error from pair.DecryptWithKeyRing: %v", err)
+	}
+	return pair, nil
+}
+
+// copyTagPair returns a deep copy of pair's stored (encrypted)
+// fields, so that neither Memory nor its callers see the other's
+// changes.
+func copyTagPair(pair *types.TagPair) *types.TagPair {
+	return &types.TagPair{
+		PlainEncrypted: copyBytes(pair.PlainEncrypted),
+		Random:         pair.Random,
+		Nonce:          copyNonce(pair.Nonce),
+		KeyID:          pair.KeyID,
+	}
+}
+
+// copyRow returns a deep copy of row's stored (encrypted) fields,
+// leaving out its data unless includeData.
+func copyRow(row *types.Row, includeData bool) *types.Row {
+	dup := &types.Row{
+		RandomTags: append([]string{}, row.RandomTags...),
+		Streamed:   row.Streamed,
+		KeyID:      row.KeyID,
+	}
+	if !includeData {
+		return dup
+	}
+	dup.Encrypted = copyBytes(row.Encrypted)
+	dup.Nonce = copyNonce(row.Nonce)
+	dup.Signer = copyBytes(row.Signer)
+	dup.Signature = copyBytes(row.Signature)
+	return dup
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func copyNonce(nonce *[24]byte) *[24]byte {
+	if nonce == nil {
+		return nil
+	}
+	dup := *nonce
+	return &dup
+}
@@ -0,0 +1,156 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMemoryLocked(t *testing.T) {
+	cfg := &Config{Name: t.Name(), Type: TypeMemory}
+	if err := cfg.Canonicalize(); err != nil {
+		t.Fatal(err)
+	}
+	key := cfg.Key
+
+	if err := cfg.Lock("correct horse"); err != nil {
+		t.Fatalf("Error locking Config: %v", err)
+	}
+
+	// As read from disk before being unlocked
+	cfg.Key, cfg.OldKeys = nil, nil
+	assert.True(t, cfg.Locked())
+
+	_, err := NewMemory(cfg)
+	assert.Equal(t, ErrConfigLocked, err)
+
+	assert.Nil(t, cfg.Unlock("correct horse"))
+	m, err := NewMemory(cfg)
+	if assert.Nil(t, err) {
+		assert.Equal(t, key, m.Key())
+	}
+}
+
+func TestMemoryCopies(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	row := mustCreateRow(t, m, nil, "original", "copies")
+	encrypted := append([]byte{}, row.Encrypted...)
+	nonce := *row.Nonce
+
+	// Changing the Row that was saved...
+	row.Encrypted[0]++
+	row.Nonce[0]++
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"copies"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, encrypted, rows[0].Encrypted)
+	assert.Equal(t, nonce, *rows[0].Nonce)
+
+	// ...or one that was fetched doesn't change what's stored
+	rows[0].Encrypted[0]++
+	rows[0].Nonce[0]++
+
+	rows, err = RowsFromPlainTags(m, nil, []string{"copies"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"original"}, decryptedData(rows))
+	}
+
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, pair := range pairs {
+		pair.PlainEncrypted[0]++
+		pair.Nonce[0]++
+	}
+
+	pairs, err = m.AllTagPairs(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pairs.WithPlainPattern("copies")))
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryptag-memory-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "one", "snapshot")
+	mustCreateRow(t, m, nil, "two", "snapshot")
+
+	filename := path.Join(dir, "snapshot")
+	assert.Nil(t, m.WriteSnapshot(filename))
+
+	loaded, err := NewMemory(&Config{Name: m.Name(), Type: TypeMemory,
+		Key: m.Key(), DataPath: filename})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	randtags, pairs := rowRandomTags(t, loaded, "snapshot")
+	rows, err := loaded.RowsFromRandomTagsPage(randtags,
+		types.PageOptions{Order: types.OrderOldest})
+	if assert.Nil(t, err) {
+		assert.Nil(t, rows.Populate(loaded.Key(), pairs))
+		assert.Equal(t, []string{"one", "two"}, dataInOrder(rows))
+	}
+
+	// Snapshots can only be read with the key they were written with
+	otherKey, _ := cryptag.RandomKey()
+	_, err = NewMemory(&Config{Name: m.Name(), Type: TypeMemory,
+		Key: otherKey, DataPath: filename})
+	assert.NotNil(t, err)
+}
+
+func TestMemoryRandomQuery(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	one := mustCreateRow(t, m, nil, "one", "work")
+	mustCreateRow(t, m, nil, "two", "home")
+
+	randtags, pairs := rowRandomTags(t, m, "work", "home")
+	q := &RandomQuery{Op: OpTag, RandomTags: randtags}
+	rows, err := m.RowsFromRandomQuery(q)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rows))
+
+	listed, err := m.ListRowsFromRandomQuery(q)
+	if assert.Nil(t, err) {
+		for _, row := range listed {
+			assert.Nil(t, row.Encrypted)
+		}
+	}
+
+	idRandTags, _ := rowRandomTags(t, m, one.IDTag())
+	rows, err = m.RowsByIDs(idRandTags)
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(rows)) {
+		assert.Nil(t, rows.Populate(m.Key(), pairs))
+		assert.Equal(t, []string{"one"}, decryptedData(rows))
+	}
+}
+
+// rowRandomTags returns the RandomTags of plaintags in m, and all of
+// m's TagPairs.
+func rowRandomTags(t *testing.T, m *Memory, plaintags ...string) (cryptag.RandomTags, types.TagPairs) {
+	all, err := m.AllTagPairs(nil)
+	if err != nil {
+		t.Fatalf("Error getting TagPairs: %v", err)
+	}
+	pairs, err := all.WithAllPlainTags(plaintags)
+	if err != nil {
+		t.Fatalf("Error getting TagPairs of %v: %v", plaintags, err)
+	}
+	return pairs.AllRandom(), all
+}
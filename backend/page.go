@@ -0,0 +1,150 @@
+// Steve Phillips / elimisteve
+// 2017.04.18
+
+package backend
+
+import (
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/rowutil"
+	"github.com/cryptag/cryptag/types"
+)
+
+// PagingBackend is implemented by Backends that can return just one
+// page of the Rows matching a query (see types.PageOptions) rather
+// than all of them.
+type PagingBackend interface {
+	// Like ListRows and RowsFromRandomTags, respectively, but
+	// return only the matching Rows that opts selects, ordered by
+	// when they were first saved under their current RandomTags if
+	// opts.Order is set (see types.OrderOldest)
+	ListRowsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error)
+	RowsFromRandomTagsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error)
+}
+
+// RowsFromPlainTagsPage is like RowsFromPlainTags but only returns
+// the Rows that opts selects.  Backends that aren't PagingBackends
+// return every matching Row, which is then ordered by its "created:"
+// tag and paged locally.  So are Rows matching prefix or range
+// patterns, or tags with duplicate TagPairs.  (PagingBackends order
+// Rows by when they were saved instead, which usually but not always
+// agrees; see types.OrderOldest.)
+//
+// Rows sealed to other users (see CreateRowForRecipients) are
+// skipped after paging, so pages can come up short.
+func RowsFromPlainTagsPage(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, opts types.PageOptions) (types.Rows, error) {
+	var fetchPage func(cryptag.RandomTags) (types.Rows, error)
+	if pbk, ok := bk.(PagingBackend); ok {
+		fetchPage = func(randtags cryptag.RandomTags) (types.Rows, error) {
+			return pbk.RowsFromRandomTagsPage(randtags, opts)
+		}
+	}
+	return getRowsPage(bk, pairs, plaintags, opts, bk.RowsFromRandomTags, fetchPage)
+}
+
+// ListRowsFromPlainTagsPage is like ListRowsFromPlainTags but only
+// returns the Rows that opts selects; see RowsFromPlainTagsPage.
+func ListRowsFromPlainTagsPage(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, opts types.PageOptions) (types.Rows, error) {
+	var fetchPage func(cryptag.RandomTags) (types.Rows, error)
+	if pbk, ok := bk.(PagingBackend); ok {
+		fetchPage = func(randtags cryptag.RandomTags) (types.Rows, error) {
+			return pbk.ListRowsPage(randtags, opts)
+		}
+	}
+	return getRowsPage(bk, pairs, plaintags, opts, bk.ListRows, fetchPage)
+}
+
+// RowsFromQueryPage is like RowsFromQuery but only returns the Rows
+// that opts selects.  Queries of plain tags alone are paged like
+// RowsFromPlainTagsPage pages; other queries are paged locally, so
+// ordered by "created:" tag.
+func RowsFromQueryPage(bk Backend, pairs types.TagPairs, q *Query, opts types.PageOptions) (types.Rows, error) {
+	if plaintags, ok := q.conjunction(); ok {
+		return RowsFromPlainTagsPage(bk, pairs, plaintags, opts)
+	}
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+	rows, err := RowsFromQuery(bk, pairs, q)
+	if err != nil {
+		return nil, err
+	}
+	return pageRows(rows, opts)
+}
+
+// ListRowsFromQueryPage is like ListRowsFromQuery but only returns
+// the Rows that opts selects; see RowsFromQueryPage.
+func ListRowsFromQueryPage(bk Backend, pairs types.TagPairs, q *Query, opts types.PageOptions) (types.Rows, error) {
+	if plaintags, ok := q.conjunction(); ok {
+		return ListRowsFromPlainTagsPage(bk, pairs, plaintags, opts)
+	}
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+	rows, err := ListRowsFromQuery(bk, pairs, q)
+	if err != nil {
+		return nil, err
+	}
+	return pageRows(rows, opts)
+}
+
+// getRowsPage fetches the page of Rows that opts selects with
+// fetchPage, or fetches every matching Row with fetchAll then pages
+// them locally if fetchPage is nil.
+func getRowsPage(bk Backend, pairs types.TagPairs, plaintags cryptag.PlainTags, opts types.PageOptions, fetchAll, fetchPage func(cryptag.RandomTags) (types.Rows, error)) (types.Rows, error) {
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+
+	native := fetchPage != nil
+
+	// Patterns are matched by fetching the union of several
+	// queries, which can't be paged natively
+	for _, plain := range plaintags {
+		if types.IsTagPattern(plain) {
+			native = false
+		}
+	}
+
+	// So are tags with duplicate TagPairs
+	if native && !opts.IsZero() && !UsesBlindTags(bk) {
+		var err error
+		if pairs, err = tagPairsIfNeeded(bk, pairs); err != nil {
+			return nil, err
+		}
+		if hasDuplicatePairs(pairs, types.UnescapeTags(plaintags)) {
+			native = false
+		}
+	}
+
+	if !native || opts.IsZero() {
+		rows, err := getRows(bk, pairs, plaintags, fetchAll)
+		if err != nil {
+			return nil, err
+		}
+		return pageRows(rows, opts)
+	}
+
+	return getRows(bk, pairs, plaintags, fetchPage)
+}
+
+// PageRows orders rows by their "created:" tags (if opts.Order is
+// set) then returns the ones opts selects.  rows must be populated.
+func PageRows(rows types.Rows, opts types.PageOptions) (types.Rows, error) {
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+	return pageRows(rows, opts)
+}
+
+func pageRows(rows types.Rows, opts types.PageOptions) (types.Rows, error) {
+	if opts.Order != types.OrderNone {
+		rows.Sort(rowutil.ByTagPrefix("created:", opts.Order == types.OrderOldest))
+	}
+
+	start, end := opts.Bounds(len(rows))
+	if start == end {
+		return nil, types.ErrRowsNotFound
+	}
+
+	return rows[start:end], nil
+}
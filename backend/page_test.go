@@ -0,0 +1,92 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// dataInOrder returns the data of each of rows, in order.
+func dataInOrder(rows types.Rows) []string {
+	data := make([]string, 0, len(rows))
+	for _, row := range rows {
+		data = append(data, string(row.Decrypted()))
+	}
+	return data
+}
+
+func TestRowsFromPlainTagsPage(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	for _, data := range []string{"one", "two", "three", "four"} {
+		mustCreateRow(t, m, nil, data, "work")
+	}
+
+	tests := []struct {
+		opts types.PageOptions
+		data []string
+	}{
+		{types.PageOptions{Limit: 2, Order: types.OrderOldest}, []string{"one", "two"}},
+		{types.PageOptions{Limit: 2, Offset: 1, Order: types.OrderOldest}, []string{"two", "three"}},
+		{types.PageOptions{Limit: 3, Order: types.OrderNewest}, []string{"four", "three", "two"}},
+		{types.PageOptions{Offset: 3, Order: types.OrderNewest}, []string{"one"}},
+	}
+	for _, tt := range tests {
+		rows, err := RowsFromPlainTagsPage(m, nil, []string{"work"}, tt.opts)
+		assert.Nil(t, err, "%+v", tt.opts)
+		assert.Equal(t, tt.data, dataInOrder(rows), "%+v", tt.opts)
+	}
+
+	_, err := RowsFromPlainTagsPage(m, nil, []string{"work"},
+		types.PageOptions{Offset: 4, Order: types.OrderOldest})
+	assert.Equal(t, types.ErrRowsNotFound, err)
+}
+
+func TestPageRowsByCreated(t *testing.T) {
+	var rows types.Rows
+	for _, created := range []string{"20170103", "20170101", "20170104", "20170102"} {
+		row, err := types.NewRowSimple([]byte(created), []string{"created:" + created + "000000"})
+		if err != nil {
+			t.Fatalf("Error creating Row: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	page, err := PageRows(rows, types.PageOptions{Limit: 2, Order: types.OrderOldest})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"20170101", "20170102"}, dataInOrder(page))
+
+	page, err = PageRows(rows, types.PageOptions{Limit: 3, Offset: 1, Order: types.OrderNewest})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"20170103", "20170102", "20170101"}, dataInOrder(page))
+}
+
+func TestRowsFromPlainTagsPageAfterMergeTag(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "one", "work")
+	moved := mustCreateRow(t, m, nil, "two", "job")
+	mustCreateRow(t, m, nil, "three", "work")
+
+	assert.Nil(t, MergeTag(m, "job", "work"))
+
+	// Paged natively, the moved Row was last saved under its current
+	// RandomTags, so it comes last...
+	opts := types.PageOptions{Limit: 10, Order: types.OrderOldest}
+	rows, err := RowsFromPlainTagsPage(m, nil, []string{"work"}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"one", "three", "two"}, dataInOrder(rows))
+
+	// ...but keeps its "created:" tag, which local paging orders by
+	rows, err = RowsFromPlainTagsPage(unqueryableBackend{m}, nil, []string{"work"}, opts)
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(rows)) {
+		for _, row := range rows {
+			if string(row.Decrypted()) == "two" {
+				assert.Equal(t, moved.Created(), row.Created())
+			}
+		}
+	}
+}
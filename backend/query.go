@@ -0,0 +1,819 @@
+// Steve Phillips / elimisteve
+// 2017.04.15
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// A Query selects Rows by their plain tags using AND, OR, NOT, and
+// grouping, e.g.,
+//
+//	(github | gitlab) -archived
+//	type:password (work OR (home AND -old))
+//
+// Tags separated by spaces (or `&`, or `AND`) must all match; `|` or
+// `OR` matches either side; a leading `-` or `!`, or `NOT`, negates;
+// parentheses group.  AND binds tighter than OR, so `github | gitlab
+// -archived` means `github | (gitlab -archived)`.  Tags that contain
+// spaces or any of these characters can be double-quoted (`"tag with
+// spaces"`).  A query of plain tags alone, such as `work
+// type:password`, means what it always has.  Any tag can instead be a
+// prefix or range pattern such as `when:201703*` or
+// `created:>=20170101` (see types.MatchesTag), which matches Rows
+// having any tag that matches it; escape the `*` or operator with a
+// backslash, e.g. `50\*`, to match such a tag exactly (see
+// types.EscapeTag).
+//
+// Queries are compiled to RandomQuery values that Backends
+// implementing QueryBackend evaluate themselves; other Backends are
+// queried for the tags that every match must have, and the results
+// filtered locally.
+type Query struct {
+	node queryNode
+}
+
+var (
+	ErrEmptyQuery = errors.New("Empty query")
+)
+
+// MaxQueryAlternatives is the most separate fetches that a query is
+// evaluated with on Backends that aren't QueryBackends (see
+// RandomQuery.Alternatives); past it, every Row that could match is
+// fetched at once (if need be, every Row) and filtered locally.
+var MaxQueryAlternatives = 10
+
+// ParseQuery parses s into a Query; see Query for the syntax.
+func ParseQuery(s string) (*Query, error) {
+	toks, err := lexQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query `%s`: %v", s, err)
+	}
+	if len(toks) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	p := &queryParser{toks: toks}
+	node, err := p.parseOr()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %s", p.toks[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query `%s`: %v", s, err)
+	}
+
+	return &Query{node: node}, nil
+}
+
+// TagsQuery returns a Query matching Rows with all of plaintags.
+func TagsQuery(plaintags []string) *Query {
+	and := &andNode{}
+	for _, plain := range plaintags {
+		and.nodes = append(and.nodes, &tagNode{plain})
+	}
+	return &Query{node: and}
+}
+
+// And returns a Query matching Rows matched by both q and other.
+func (q *Query) And(other *Query) *Query {
+	return &Query{node: &andNode{nodes: []queryNode{q.node, other.node}}}
+}
+
+// String returns q in the syntax ParseQuery accepts.
+func (q *Query) String() string {
+	return q.node.String()
+}
+
+// PlainTags returns each plain tag that q mentions.
+func (q *Query) PlainTags() []string {
+	var plaintags []string
+	seen := map[string]bool{}
+	q.node.walk(func(plain string) {
+		if !seen[plain] {
+			seen[plain] = true
+			plaintags = append(plaintags, plain)
+		}
+	})
+	return plaintags
+}
+
+// Matches reports whether a Row with the given plain tags matches q.
+func (q *Query) Matches(plaintags []string) bool {
+	has := make(map[string]bool, len(plaintags))
+	for _, plain := range plaintags {
+		has[plain] = true
+	}
+	return q.node.matches(has)
+}
+
+// Compile converts q into a RandomQuery, using lookup to get the
+// RandomTags each plain tag maps to.  A plain tag that lookup returns
+// no RandomTags for matches no Rows.
+func (q *Query) Compile(lookup func(plain string) cryptag.RandomTags) *RandomQuery {
+	return q.node.compile(lookup)
+}
+
+// hasPatterns reports whether q contains any prefix or range
+// patterns.
+func (q *Query) hasPatterns() bool {
+	found := false
+	q.node.walk(func(plain string) {
+		if types.IsTagPattern(plain) {
+			found = true
+		}
+	})
+	return found
+}
+
+// required returns the plain tags that every Row matching q has.
+func (q *Query) required() []string {
+	return q.node.required()
+}
+
+// conjunction returns the plain tags q is made of if q just matches
+// Rows having all of them (as TagsQuery queries do).
+func (q *Query) conjunction() (plaintags []string, ok bool) {
+	return appendConjunction(nil, q.node)
+}
+
+func appendConjunction(plaintags []string, node queryNode) ([]string, bool) {
+	switch n := node.(type) {
+	case *tagNode:
+		return append(plaintags, n.plain), true
+	case *andNode:
+		for _, child := range n.nodes {
+			var ok bool
+			if plaintags, ok = appendConjunction(plaintags, child); !ok {
+				return nil, false
+			}
+		}
+		return plaintags, true
+	}
+	return nil, false
+}
+
+// RandomQuery is a Query compiled to operations on RandomTags, so that
+// it can be evaluated by untrusted Backends.
+type RandomQuery struct {
+	Op string // One of the Op* constants
+
+	// For OpTag: matches Rows with any of these (one unless a plain
+	// tag has duplicate TagPairs)
+	RandomTags cryptag.RandomTags `json:",omitempty"`
+
+	// For OpAnd, OpOr, and OpNot (exactly 1)
+	Args []*RandomQuery `json:",omitempty"`
+}
+
+const (
+	OpTag = "tag"
+	OpAnd = "and"
+	OpOr  = "or"
+	OpNot = "not"
+)
+
+// Matches reports whether a Row with the given RandomTags matches rq.
+func (rq *RandomQuery) Matches(randtags []string) bool {
+	has := make(map[string]bool, len(randtags))
+	for _, random := range randtags {
+		has[random] = true
+	}
+	return rq.matches(has)
+}
+
+func (rq *RandomQuery) matches(has map[string]bool) bool {
+	switch rq.Op {
+	case OpTag:
+		for _, random := range rq.RandomTags {
+			if has[random] {
+				return true
+			}
+		}
+		return false
+	case OpAnd:
+		for _, arg := range rq.Args {
+			if !arg.matches(has) {
+				return false
+			}
+		}
+		return true
+	case OpOr:
+		for _, arg := range rq.Args {
+			if arg.matches(has) {
+				return true
+			}
+		}
+		return false
+	case OpNot:
+		return len(rq.Args) == 1 && !rq.Args[0].matches(has)
+	}
+	return false
+}
+
+// Required returns the RandomTags that every Row matching rq has, for
+// Backends that can only select Rows having all of a set of
+// RandomTags.
+func (rq *RandomQuery) Required() cryptag.RandomTags {
+	switch rq.Op {
+	case OpTag:
+		if len(rq.RandomTags) == 1 {
+			return rq.RandomTags
+		}
+	case OpAnd:
+		var randtags []string
+		for _, arg := range rq.Args {
+			randtags = appendMissing(randtags, arg.Required()...)
+		}
+		return randtags
+	case OpOr:
+		if len(rq.Args) == 0 {
+			return nil
+		}
+		randtags := []string(rq.Args[0].Required())
+		for _, arg := range rq.Args[1:] {
+			randtags = intersect(randtags, arg.Required())
+		}
+		return randtags
+	}
+	return nil
+}
+
+// Alternatives returns sets of RandomTags such that every Row
+// matching rq has all the RandomTags in at least one of them, or nil
+// if there's no better answer than Required.  Lets Backends that can
+// only select Rows having all of a set of RandomTags fetch the union
+// of several such selections instead of every Row.
+func (rq *RandomQuery) Alternatives() []cryptag.RandomTags {
+	switch rq.Op {
+	case OpTag:
+		if len(rq.RandomTags) < 2 {
+			return nil
+		}
+		alts := make([]cryptag.RandomTags, len(rq.RandomTags))
+		for i, random := range rq.RandomTags {
+			alts[i] = cryptag.RandomTags{random}
+		}
+		return alts
+	case OpAnd:
+		required := rq.Required()
+		for _, arg := range rq.Args {
+			alts := arg.Alternatives()
+			if alts == nil {
+				continue
+			}
+			for i := range alts {
+				both := append([]string(nil), required...)
+				alts[i] = appendMissing(both, alts[i]...)
+			}
+			return alts
+		}
+	case OpOr:
+		var alts []cryptag.RandomTags
+		for _, arg := range rq.Args {
+			argAlts := arg.Alternatives()
+			if argAlts == nil {
+				required := arg.Required()
+				if len(required) == 0 {
+					// Could match anything
+					return nil
+				}
+				argAlts = []cryptag.RandomTags{required}
+			}
+			alts = append(alts, argAlts...)
+		}
+		return alts
+	}
+	return nil
+}
+
+// QueryBackend is implemented by Backends that can evaluate
+// RandomQuery values themselves.
+type QueryBackend interface {
+	// Like RowsFromRandomTags and ListRows, respectively, but
+	// return the Rows matching q
+	RowsFromRandomQuery(q *RandomQuery) (types.Rows, error)
+	ListRowsFromRandomQuery(q *RandomQuery) (types.Rows, error)
+}
+
+// RowsFromQuery is like RowsFromPlainTags but returns the Rows
+// matching q.
+func RowsFromQuery(bk Backend, pairs types.TagPairs, q *Query) (types.Rows, error) {
+	fetch := bk.RowsFromRandomTags
+	if qbk, ok := bk.(QueryBackend); ok {
+		return getRowsByQuery(bk, pairs, q, qbk.RowsFromRandomQuery, fetch)
+	}
+	return getRowsByQuery(bk, pairs, q, nil, fetch)
+}
+
+// ListRowsFromQuery is like ListRowsFromPlainTags but returns the
+// Rows matching q.
+func ListRowsFromQuery(bk Backend, pairs types.TagPairs, q *Query) (types.Rows, error) {
+	fetch := bk.ListRows
+	if qbk, ok := bk.(QueryBackend); ok {
+		return getRowsByQuery(bk, pairs, q, qbk.ListRowsFromRandomQuery, fetch)
+	}
+	return getRowsByQuery(bk, pairs, q, nil, fetch)
+}
+
+func getRowsByQuery(bk Backend, pairs types.TagPairs, q *Query, fetchByQuery func(*RandomQuery) (types.Rows, error), fetchByRandom func(cryptag.RandomTags) (types.Rows, error)) (types.Rows, error) {
+	var index []byte
+	if pairs == nil {
+		var err error
+		if index, err = blindIndex(bk); err != nil {
+			return nil, err
+		}
+		if index == nil {
+			if pairs, err = bk.AllTagPairs(nil); err != nil {
+				return nil, err
+			}
+			if len(pairs) == 0 {
+				return nil, types.ErrTagPairNotFound
+			}
+		}
+	}
+
+	// Patterns can only be expanded by decrypting every PlainTag
+	if index != nil && pairs == nil && q.hasPatterns() {
+		var err error
+		if pairs, err = bk.AllTagPairs(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	pairIndex := types.IndexTagPairs(pairs)
+
+	lookup := func(plain string) cryptag.RandomTags {
+		if types.IsTagPattern(plain) {
+			return pairs.WithPlainPattern(plain).AllRandom()
+		}
+		plain = types.UnescapeTag(plain)
+		if index != nil {
+			return cryptag.RandomTags{BlindRandomTag(index, plain)}
+		}
+		return pairIndex.ByPlain(plain).AllRandom()
+	}
+
+	// As with RowsFromPlainTags, a tag that every match must have
+	// but that doesn't exist is an error
+	for _, plain := range q.required() {
+		if len(lookup(plain)) > 0 {
+			continue
+		}
+		if types.IsTagPattern(plain) {
+			return nil, types.ErrRowsNotFound
+		}
+		return nil, fmt.Errorf("PlainTag `%s` not found", types.UnescapeTag(plain))
+	}
+
+	rq := q.Compile(lookup)
+
+	var rows types.Rows
+	var err error
+
+	if fetchByQuery != nil {
+		rows, err = fetchByQuery(rq)
+	} else {
+		rows, err = fetchByAlternatives(rq, lookup, fetchByRandom)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip Rows sealed to other users
+	rows = rows.Readable()
+
+	if len(rows) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	if index != nil {
+		return rows, populateRowsBlind(bk, rows)
+	}
+
+	if err = rows.PopulateWithKeyRing(KeyRing(bk), pairs); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// fetchByAlternatives evaluates rq locally, fetching candidate Rows
+// with fetchByRandom.
+func fetchByAlternatives(rq *RandomQuery, lookup func(string) cryptag.RandomTags, fetchByRandom func(cryptag.RandomTags) (types.Rows, error)) (types.Rows, error) {
+	// Each alternative is another round trip, so past a point, fetch
+	// a superset of the matches all at once instead
+	alts := rq.Alternatives()
+	if len(alts) > MaxQueryAlternatives {
+		alts = nil
+	}
+	if alts == nil {
+		if randtags := rq.Required(); len(randtags) > 0 {
+			alts = []cryptag.RandomTags{randtags}
+		} else {
+			// Could match anything; start from every Row, under each
+			// of "all"'s TagPairs (see RepairDuplicateTags)
+			for _, random := range lookup("all") {
+				alts = append(alts, cryptag.RandomTags{random})
+			}
+		}
+	}
+
+	var rows types.Rows
+	seen := map[string]bool{}
+
+	for _, randtags := range alts {
+		candidates, err := fetchByRandom(randtags)
+		if err == types.ErrRowsNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range candidates {
+			// A Row's RandomTags identify it
+			key := strings.Join(row.RandomTags, "-")
+			if seen[key] || !rq.Matches(row.RandomTags) {
+				continue
+			}
+			seen[key] = true
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+//
+// Query AST
+//
+
+type queryNode interface {
+	String() string
+	matches(has map[string]bool) bool
+	required() []string
+	compile(lookup func(plain string) cryptag.RandomTags) *RandomQuery
+	walk(fn func(plain string))
+}
+
+type tagNode struct {
+	plain string
+}
+
+func (n *tagNode) String() string {
+	if n.plain == "" || strings.ContainsAny(n.plain, queryChars+"\\ \t\n") ||
+		n.plain[0] == '-' || n.plain[0] == '!' || isQueryKeyword(n.plain) {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(n.plain) + `"`
+	}
+	return n.plain
+}
+
+func (n *tagNode) required() []string   { return []string{n.plain} }
+func (n *tagNode) walk(fn func(string)) { fn(n.plain) }
+
+func (n *tagNode) matches(has map[string]bool) bool {
+	if !types.IsTagPattern(n.plain) {
+		return has[types.UnescapeTag(n.plain)]
+	}
+	for plain := range has {
+		if types.MatchesTag(n.plain, plain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *tagNode) compile(lookup func(string) cryptag.RandomTags) *RandomQuery {
+	return &RandomQuery{Op: OpTag, RandomTags: lookup(n.plain)}
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (n *notNode) String() string                   { return "-" + n.node.String() }
+func (n *notNode) matches(has map[string]bool) bool { return !n.node.matches(has) }
+func (n *notNode) required() []string               { return nil }
+func (n *notNode) walk(fn func(string))             { n.node.walk(fn) }
+
+func (n *notNode) compile(lookup func(string) cryptag.RandomTags) *RandomQuery {
+	return &RandomQuery{Op: OpNot, Args: []*RandomQuery{n.node.compile(lookup)}}
+}
+
+type andNode struct {
+	nodes []queryNode
+}
+
+func (n *andNode) String() string {
+	return "(" + joinNodes(n.nodes, " ") + ")"
+}
+
+func (n *andNode) matches(has map[string]bool) bool {
+	for _, node := range n.nodes {
+		if !node.matches(has) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *andNode) required() []string {
+	var plaintags []string
+	for _, node := range n.nodes {
+		plaintags = appendMissing(plaintags, node.required()...)
+	}
+	return plaintags
+}
+
+func (n *andNode) walk(fn func(string)) {
+	for _, node := range n.nodes {
+		node.walk(fn)
+	}
+}
+
+func (n *andNode) compile(lookup func(string) cryptag.RandomTags) *RandomQuery {
+	return &RandomQuery{Op: OpAnd, Args: compileNodes(n.nodes, lookup)}
+}
+
+type orNode struct {
+	nodes []queryNode
+}
+
+func (n *orNode) String() string {
+	return "(" + joinNodes(n.nodes, " | ") + ")"
+}
+
+func (n *orNode) matches(has map[string]bool) bool {
+	for _, node := range n.nodes {
+		if node.matches(has) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *orNode) required() []string {
+	plaintags := n.nodes[0].required()
+	for _, node := range n.nodes[1:] {
+		plaintags = intersect(plaintags, node.required())
+	}
+	return plaintags
+}
+
+func (n *orNode) walk(fn func(string)) {
+	for _, node := range n.nodes {
+		node.walk(fn)
+	}
+}
+
+func (n *orNode) compile(lookup func(string) cryptag.RandomTags) *RandomQuery {
+	return &RandomQuery{Op: OpOr, Args: compileNodes(n.nodes, lookup)}
+}
+
+func joinNodes(nodes []queryNode, sep string) string {
+	strs := make([]string, len(nodes))
+	for i, node := range nodes {
+		strs[i] = node.String()
+	}
+	return strings.Join(strs, sep)
+}
+
+func compileNodes(nodes []queryNode, lookup func(string) cryptag.RandomTags) []*RandomQuery {
+	compiled := make([]*RandomQuery, len(nodes))
+	for i, node := range nodes {
+		compiled[i] = node.compile(lookup)
+	}
+	return compiled
+}
+
+// intersect returns the strings in both a and b.
+func intersect(a, b []string) []string {
+	var both []string
+	for _, s := range a {
+		for _, s2 := range b {
+			if s == s2 {
+				both = append(both, s)
+				break
+			}
+		}
+	}
+	return both
+}
+
+// appendMissing appends each of strs not already in dst to dst.
+func appendMissing(dst []string, strs ...string) []string {
+	for _, s := range strs {
+		found := false
+		for _, existing := range dst {
+			if existing == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
+
+//
+// Lexing and parsing
+//
+
+// Characters that end a tag and have special meaning in queries
+const queryChars = `()|&"`
+
+type queryTokenType int
+
+const (
+	tokTag queryTokenType = iota
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type queryToken struct {
+	typ  queryTokenType
+	text string
+}
+
+func (t queryToken) String() string {
+	if t.typ == tokTag {
+		return fmt.Sprintf("tag `%s`", t.text)
+	}
+	return fmt.Sprintf("`%s`", t.text)
+}
+
+func isQueryKeyword(s string) bool {
+	return s == "AND" || s == "OR" || s == "NOT"
+}
+
+func lexQuery(s string) ([]queryToken, error) {
+	var toks []queryToken
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, queryToken{tokLParen, "("})
+			i++
+		case r == ')':
+			toks = append(toks, queryToken{tokRParen, ")"})
+			i++
+		case r == '|':
+			toks = append(toks, queryToken{tokOr, "|"})
+			i++
+		case r == '&':
+			toks = append(toks, queryToken{tokAnd, "&"})
+			i++
+		case r == '-' || r == '!':
+			toks = append(toks, queryToken{tokNot, string(r)})
+			i++
+		case r == '"':
+			var tag []rune
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				tag = append(tag, runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated quote")
+			}
+			i++
+			toks = append(toks, queryToken{tokTag, string(tag)})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) &&
+				!strings.ContainsRune(queryChars, runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			switch word {
+			case "AND":
+				toks = append(toks, queryToken{tokAnd, word})
+			case "OR":
+				toks = append(toks, queryToken{tokOr, word})
+			case "NOT":
+				toks = append(toks, queryToken{tokNot, word})
+			default:
+				toks = append(toks, queryToken{tokTag, word})
+			}
+		}
+	}
+
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []queryToken
+	pos  int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.toks) {
+		return queryToken{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// parseOr parses `and ( OR and )*`
+func (p *queryParser) parseOr() (queryNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := &orNode{nodes: []queryNode{node}}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.typ != tokOr {
+			break
+		}
+		p.pos++
+		if node, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		or.nodes = append(or.nodes, node)
+	}
+
+	if len(or.nodes) == 1 {
+		return or.nodes[0], nil
+	}
+	return or, nil
+}
+
+// parseAnd parses `unary ( [AND] unary )*`
+func (p *queryParser) parseAnd() (queryNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	and := &andNode{nodes: []queryNode{node}}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.typ == tokOr || tok.typ == tokRParen {
+			break
+		}
+		if tok.typ == tokAnd {
+			p.pos++
+		}
+		if node, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		and.nodes = append(and.nodes, node)
+	}
+
+	if len(and.nodes) == 1 {
+		return and.nodes[0], nil
+	}
+	return and, nil
+}
+
+// parseUnary parses `NOT unary | ( or ) | tag`
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of query")
+	}
+	p.pos++
+
+	switch tok.typ {
+	case tokNot:
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node}, nil
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok = p.peek(); !ok || tok.typ != tokRParen {
+			return nil, errors.New("missing `)`")
+		}
+		p.pos++
+		return node, nil
+	case tokTag:
+		return &tagNode{tok.text}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %s", tok)
+}
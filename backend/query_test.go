@@ -0,0 +1,212 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// unqueryableBackend hides Memory's QueryBackend methods so that
+// queries are evaluated locally.
+type unqueryableBackend struct {
+	Backend
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		str     string
+		matches []string
+		misses  []string
+	}{
+		{"work type:password", "(work type:password)",
+			[]string{"work", "type:password", "all"}, []string{"work"}},
+		{"github | gitlab -archived", "(github | (gitlab -archived))",
+			[]string{"gitlab"}, []string{"gitlab", "archived"}},
+		{"github OR gitlab AND NOT archived", "(github | (gitlab -archived))",
+			[]string{"github", "archived"}, []string{"archived"}},
+		{"type:password (work OR (home & !old))",
+			"(type:password (work | (home -old)))",
+			[]string{"type:password", "home"}, []string{"type:password", "home", "old"}},
+		{`"tag with spaces" "a|b" "say \"hi\""`, `("tag with spaces" "a|b" "say \"hi\"")`,
+			[]string{"tag with spaces", "a|b", `say "hi"`}, []string{"a|b"}},
+		{`"-dash" "OR"`, `("-dash" "OR")`, []string{"-dash", "OR"}, []string{"dash", "OR"}},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if !assert.Nil(t, err, test.query) {
+			continue
+		}
+		assert.Equal(t, test.str, q.String(), test.query)
+		assert.True(t, q.Matches(test.matches), "%s should match %v",
+			test.query, test.matches)
+		assert.False(t, q.Matches(test.misses), "%s shouldn't match %v",
+			test.query, test.misses)
+
+		// String's output parses to the same Query
+		again, err := ParseQuery(q.String())
+		if assert.Nil(t, err) {
+			assert.Equal(t, q.String(), again.String())
+		}
+	}
+
+	for _, bad := range []string{"", "  ", "(work", "work)", `"open`, "work |", "-"} {
+		_, err := ParseQuery(bad)
+		assert.NotNil(t, err, "`%s` should be invalid", bad)
+	}
+}
+
+func TestTagsQuery(t *testing.T) {
+	// Tags aren't parsed, so can contain query syntax
+	q := TagsQuery([]string{"(a|b)", "-x", "!y", `"z"`})
+	assert.True(t, q.Matches([]string{"(a|b)", "-x", "!y", `"z"`}))
+	assert.False(t, q.Matches([]string{"a", "x"}))
+
+	again, err := ParseQuery(q.String())
+	if assert.Nil(t, err) {
+		assert.Equal(t, q.String(), again.String())
+	}
+}
+
+func TestRowsFromQuery(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "gh", "github", "type:text")
+	mustCreateRow(t, m, nil, "gl", "gitlab", "type:text")
+	mustCreateRow(t, m, nil, "old gl", "gitlab", "archived", "type:text")
+	mustCreateRow(t, m, nil, "parens", "(a|b)", "type:text")
+
+	tests := []struct {
+		query string
+		data  []string
+	}{
+		{"github | gitlab", []string{"gh", "gl", "old gl"}},
+		{"(github | gitlab) -archived", []string{"gh", "gl"}},
+		{"gitlab archived", []string{"old gl"}},
+		{`"(a|b)"`, []string{"parens"}},
+		{"-github -gitlab", []string{"parens"}},
+	}
+
+	for _, bk := range []Backend{m, unqueryableBackend{m}} {
+		for _, test := range tests {
+			q, err := ParseQuery(test.query)
+			if !assert.Nil(t, err) {
+				continue
+			}
+			rows, err := RowsFromQuery(bk, nil, q)
+			if assert.Nil(t, err, "%T: %s", bk, test.query) {
+				assert.Equal(t, test.data, decryptedData(rows), "%T: %s", bk, test.query)
+			}
+		}
+
+		q, _ := ParseQuery("github -github")
+		_, err := RowsFromQuery(bk, nil, q)
+		assert.Equal(t, types.ErrRowsNotFound, err)
+
+		q, _ = ParseQuery("nope | github")
+		rows, err := RowsFromQuery(bk, nil, q)
+		if assert.Nil(t, err) {
+			assert.Equal(t, []string{"gh"}, decryptedData(rows))
+		}
+
+		q, _ = ParseQuery("nope github")
+		_, err = RowsFromQuery(bk, nil, q)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRowsFromQueryDuplicateAll(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	createRowsWithDuplicateAll(t, m)
+
+	// Pure negation starts from every Row, under each "all" TagPair
+	q, err := ParseQuery("-work")
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, bk := range []Backend{m, unqueryableBackend{m}} {
+		rows, err := RowsFromQuery(bk, nil, q)
+		if assert.Nil(t, err, "%T", bk) {
+			assert.Equal(t, []string{"four", "three"}, decryptedData(rows), "%T", bk)
+		}
+	}
+}
+
+// countingBackend counts how many times Rows are fetched from it.
+type countingBackend struct {
+	Backend
+	fetches int
+}
+
+func (bk *countingBackend) RowsFromRandomTags(randtags cryptag.RandomTags) (types.Rows, error) {
+	bk.fetches++
+	return bk.Backend.RowsFromRandomTags(randtags)
+}
+
+func TestRowsFromPlainTagsEscaped(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "star", "50*")
+	mustCreateRow(t, m, nil, "fifty", "500")
+	mustCreateRow(t, m, nil, "gt", "note:>big")
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"50*"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"fifty", "star"}, decryptedData(rows))
+	}
+
+	rows, err = RowsFromPlainTags(m, nil, []string{`50\*`})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"star"}, decryptedData(rows))
+	}
+
+	rows, err = RowsFromPlainTags(m, nil, []string{types.EscapeTag("note:>big")})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"gt"}, decryptedData(rows))
+	}
+
+	q, err := ParseQuery(`50\* | nope`)
+	if assert.Nil(t, err) {
+		rows, err = RowsFromQuery(unqueryableBackend{m}, nil, q)
+		if assert.Nil(t, err) {
+			assert.Equal(t, []string{"star"}, decryptedData(rows))
+		}
+	}
+}
+
+func TestRowsFromQueryMaxAlternatives(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	var want []string
+	for i := 0; i < MaxQueryAlternatives+5; i++ {
+		data := fmt.Sprintf("when:201703%02d", i+1)
+		mustCreateRow(t, m, nil, data, data)
+		want = append(want, data)
+	}
+	mustCreateRow(t, m, nil, "other", "when:20170401")
+
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	bk := &countingBackend{Backend: m}
+	rows, err := RowsFromPlainTags(bk, pairs, []string{"when:201703*"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, want, decryptedData(rows))
+	}
+	assert.Equal(t, 1, bk.fetches)
+
+	// Few enough to fetch separately
+	bk = &countingBackend{Backend: m}
+	rows, err = RowsFromPlainTags(bk, pairs, []string{"when:2017030*"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, want[:9], decryptedData(rows))
+	}
+	assert.Equal(t, 9, bk.fetches)
+}
@@ -0,0 +1,102 @@
+// Steve Phillips / elimisteve
+// 2017.04.23
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/elimisteve/fun"
+)
+
+// Bounds on Settings.TagLength.  Shorter RandomTags collide more
+// often; longer ones make Row filenames longer.
+const (
+	MinTagLength = 6
+	MaxTagLength = 32
+)
+
+// Max length of a Row's filename (its RandomTags joined with "-") on
+// most filesystems
+const maxRowFilenameLength = 255
+
+// How many times to generate a new RandomTag after colliding with an
+// existing one
+const maxTagCollisionRetries = 10
+
+var (
+	ErrTagCollision = errors.New("Couldn't generate a RandomTag that isn't" +
+		" already in use")
+)
+
+// ValidRandomTag returns an error unless random is safe to store in
+// every kind of Backend.  Only characters in RANDOM_TAG_ALPHABET are
+// allowed: filesystem Row filenames join RandomTags with "-", the
+// webserver API joins them with ",", and Dropbox paths are
+// case-insensitive and searched by word.
+func ValidRandomTag(random string) error {
+	if random == "" {
+		return fmt.Errorf("Invalid empty RandomTag")
+	}
+	for _, r := range random {
+		if !strings.ContainsRune(RANDOM_TAG_ALPHABET, r) {
+			return fmt.Errorf("Invalid RandomTag `%s`; may only contain `%s`",
+				random, RANDOM_TAG_ALPHABET)
+		}
+	}
+	return nil
+}
+
+func validRandomTags(randtags cryptag.RandomTags) error {
+	for _, random := range randtags {
+		if err := ValidRandomTag(random); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RandomTagLength returns the length of the RandomTags that s calls
+// for.
+func (s Settings) RandomTagLength() int {
+	if s.TagMode == TagModeHMAC {
+		return BlindTagLength
+	}
+	if s.TagLength == 0 {
+		return RANDOM_TAG_LENGTH
+	}
+	return s.TagLength
+}
+
+func newRandomTag(length int) string {
+	return fun.RandomString(RANDOM_TAG_ALPHABET, length)
+}
+
+// uniqueRandomTag returns a new RandomTag that neither known (which
+// may be nil) nor bk has a TagPair for.
+func uniqueRandomTag(bk Backend, known *types.TagPairIndex) (string, error) {
+	length := GetSettings(bk).RandomTagLength()
+
+	for i := 0; i < maxTagCollisionRetries; i++ {
+		random := newRandomTag(length)
+		if known != nil && known.HasRandom(random) {
+			continue
+		}
+
+		// Another client may have created it since known was
+		// fetched
+		existing, err := bk.TagPairsFromRandomTags(cryptag.RandomTags{random})
+		if err != nil {
+			return "", fmt.Errorf("Error checking for RandomTag collision: %v", err)
+		}
+		if len(existing) == 0 {
+			return random, nil
+		}
+	}
+
+	return "", ErrTagCollision
+}
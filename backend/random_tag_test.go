@@ -0,0 +1,75 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidRandomTag(t *testing.T) {
+	for _, random := range []string{"abc123", RANDOM_TAG_ALPHABET,
+		newRandomTag(RANDOM_TAG_LENGTH)} {
+		assert.Nil(t, ValidRandomTag(random), random)
+	}
+
+	for _, random := range []string{"", "ABC123", "abc-123", "abc,123",
+		"abc 123", "abc/123", "../abc", "abcé"} {
+		assert.NotNil(t, ValidRandomTag(random), random)
+	}
+}
+
+// collidingBackend claims that the first collisions RandomTags checked
+// are already in use.
+type collidingBackend struct {
+	Backend
+	collisions int
+	checked    cryptag.RandomTags
+	err        error
+}
+
+func (bk *collidingBackend) Settings() Settings {
+	return GetSettings(bk.Backend)
+}
+
+func (bk *collidingBackend) TagPairsFromRandomTags(randtags cryptag.RandomTags) (types.TagPairs, error) {
+	if bk.err != nil {
+		return nil, bk.err
+	}
+	bk.checked = append(bk.checked, randtags...)
+	if len(bk.checked) <= bk.collisions {
+		return types.TagPairs{&types.TagPair{Random: randtags[0]}}, nil
+	}
+	return bk.Backend.TagPairsFromRandomTags(randtags)
+}
+
+func TestUniqueRandomTag(t *testing.T) {
+	m := newTestMemory(t, Settings{TagLength: 12})
+
+	// Retries after colliding...
+	bk := &collidingBackend{Backend: m, collisions: 3}
+	random, err := uniqueRandomTag(bk, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 4, len(bk.checked))
+		assert.Equal(t, random, bk.checked[3])
+		assert.Equal(t, 12, len(random))
+		assert.Nil(t, ValidRandomTag(random))
+	}
+
+	// ...but not forever
+	bk = &collidingBackend{Backend: m, collisions: maxTagCollisionRetries}
+	_, err = uniqueRandomTag(bk, nil)
+	assert.Equal(t, ErrTagCollision, err)
+	assert.Equal(t, maxTagCollisionRetries, len(bk.checked))
+
+	// A failed check isn't mistaken for a free RandomTag
+	bk = &collidingBackend{Backend: m, err: errors.New("Network is down")}
+	_, err = uniqueRandomTag(bk, nil)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrTagCollision, err)
+}
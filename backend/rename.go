@@ -0,0 +1,229 @@
+// Steve Phillips / elimisteve
+// 2017.04.21
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+var (
+	ErrSameTag     = errors.New("Old and new tags are the same")
+	ErrEmptyTag    = errors.New("Tag cannot be empty")
+	ErrReservedTag = errors.New(`Can't rename "all" or "id:..." tags`)
+)
+
+// RenameTag renames the PlainTag oldPlain to newPlain.  Since Rows
+// refer to tags by RandomTag, this normally just re-encrypts
+// oldPlain's TagPair; no Rows are touched.  If newPlain already
+// exists, or if bk uses blind tags (whose RandomTags depend on their
+// PlainTags), the tags are merged with MergeTag instead.
+func RenameTag(bk Backend, oldPlain, newPlain string) error {
+	if err := checkTagRename(oldPlain, newPlain); err != nil {
+		return err
+	}
+
+	pairs, err := bk.AllTagPairs(nil)
+	if err != nil {
+		return fmt.Errorf("Error fetching TagPairs: %v", err)
+	}
+	idx := types.NewTagPairIndex(pairs)
+
+	oldPairs := idx.ByPlain(oldPlain)
+	if len(oldPairs) == 0 {
+		return fmt.Errorf("PlainTag `%s` not found", oldPlain)
+	}
+
+	if len(idx.ByPlain(newPlain)) > 0 || UsesBlindTags(bk) {
+		return mergeTag(bk, pairs, oldPlain, newPlain)
+	}
+
+	cipherName := GetSettings(bk).Cipher
+
+	for _, old := range oldPairs {
+		pair, err := NewTagPairWithCipher(bk.Key(), newPlain, cipherName)
+		if err != nil {
+			return err
+		}
+		pair.Random = old.Random
+
+		if err = bk.SaveTagPair(pair); err != nil {
+			return fmt.Errorf("Error saving renamed TagPair: %v", err)
+		}
+	}
+
+	if types.Debug {
+		log.Printf("RenameTag: renamed %d TagPairs from `%s` to `%s`\n",
+			len(oldPairs), oldPlain, newPlain)
+	}
+
+	return nil
+}
+
+// MergeTag re-tags every Row tagged with oldPlain with newPlain
+// (creating newPlain if need be), then deletes oldPlain.  Rows are
+// re-saved (and re-encrypted, since their envelopes commit to their
+// RandomTags) under their new RandomTags and their old copies
+// deleted.  If any of them are sealed to or signed by other users,
+// nothing is re-tagged and a *SealedToOthersError or
+// *SignedByOthersError, respectively, is returned.
+//
+// bk must support deleting TagPairs.  If interrupted, call again to
+// resume; Rows already re-tagged are skipped.
+func MergeTag(bk Backend, oldPlain, newPlain string) error {
+	if err := checkTagRename(oldPlain, newPlain); err != nil {
+		return err
+	}
+
+	pairs, err := bk.AllTagPairs(nil)
+	if err != nil {
+		return fmt.Errorf("Error fetching TagPairs: %v", err)
+	}
+
+	return mergeTag(bk, pairs, oldPlain, newPlain)
+}
+
+func mergeTag(bk Backend, pairs types.TagPairs, oldPlain, newPlain string) error {
+	deleter, ok := bk.(TagPairDeleter)
+	if !ok {
+		return ErrTagPairDeleteNotSupported
+	}
+
+	index, err := blindIndex(bk)
+	if err != nil {
+		return err
+	}
+
+	idx := types.NewTagPairIndex(pairs)
+
+	oldRandom := idx.ByPlain(oldPlain).AllRandom()
+	if len(oldRandom) == 0 {
+		return fmt.Errorf("PlainTag `%s` not found", oldPlain)
+	}
+
+	newPairs, err := createTagsFromPlain(bk, bk.Key(), []string{newPlain}, pairs, index)
+	if err != nil {
+		return err
+	}
+	if len(newPairs) > 0 {
+		idx.Add(newPairs...)
+	}
+	if len(idx.ByPlain(newPlain)) == 0 {
+		return fmt.Errorf("Error creating TagPair for `%s`", newPlain)
+	}
+	newRandom := idx.ByPlain(newPlain)[0].Random
+
+	if err = retagRows(bk, pairs, oldPlain, newPlain, oldRandom, newRandom); err != nil {
+		return err
+	}
+
+	if err = deleter.DeleteTagPairs(oldRandom); err != nil {
+		return fmt.Errorf("Error deleting old TagPairs: %v", err)
+	}
+
+	return nil
+}
+
+// retagRows moves every Row tagged with any of oldRandom (of
+// oldPlain) to newRandom (of newPlain).
+func retagRows(bk Backend, pairs types.TagPairs, oldPlain, newPlain string, oldRandom cryptag.RandomTags, newRandom string) error {
+	var rows types.Rows
+	for _, random := range oldRandom {
+		matches, err := bk.RowsFromRandomTags(cryptag.RandomTags{random})
+		if err != nil && err != types.ErrRowsNotFound {
+			return fmt.Errorf("Error fetching Rows: %v", err)
+		}
+		rows = append(rows, matches...)
+	}
+
+	// Don't re-tag any Rows unless all of them can be read and
+	// re-signed
+	if err := checkSealedToOthers(rows); err != nil {
+		return err
+	}
+	if err := checkSignedByOthers(rows); err != nil {
+		return err
+	}
+
+	// Deleting the old copy of a Row deletes every Row having all
+	// of its RandomTags, so move Rows with more tags first
+	rows.Sort(func(r1, r2 *types.Row) bool {
+		return len(r1.RandomTags) > len(r2.RandomTags)
+	})
+
+	for _, row := range rows {
+		if err := retagRow(bk, row, pairs, oldPlain, newPlain, oldRandom, newRandom); err != nil {
+			return fmt.Errorf("Error re-tagging Row with RandomTags %v: %v",
+				row.RandomTags, err)
+		}
+	}
+
+	if types.Debug {
+		log.Printf("retagRows: re-tagged %d Rows from `%s` to `%s`\n",
+			len(rows), oldPlain, newPlain)
+	}
+
+	return nil
+}
+
+// retagRow moves row from the RandomTags oldRandom (of oldPlain) to
+// newRandom (of newPlain).
+func retagRow(bk Backend, row *types.Row, pairs types.TagPairs, oldPlain, newPlain string, oldRandom cryptag.RandomTags, newRandom string) error {
+	if err := row.PopulateWithKeyRing(KeyRing(bk), pairs); err != nil {
+		return err
+	}
+
+	isOld := make(map[string]bool, len(oldRandom))
+	for _, random := range oldRandom {
+		isOld[random] = true
+	}
+
+	// Decoys depend on the real RandomTags, so are made anew below
+	for _, decoy := range row.Decoys() {
+		isOld[decoy] = true
+	}
+
+	var randtags cryptag.RandomTags
+	for _, random := range row.RandomTags {
+		if !isOld[random] && random != newRandom {
+			randtags = append(randtags, random)
+		}
+	}
+	randtags = append(randtags, newRandom)
+
+	randtags, err := hideTagStructure(bk, row.IDTag(), randtags)
+	if err != nil {
+		return err
+	}
+
+	var plaintags []string
+	for _, plain := range row.PlainTags() {
+		if plain != oldPlain && plain != newPlain {
+			plaintags = append(plaintags, plain)
+		}
+	}
+	plaintags = append(plaintags, newPlain)
+
+	return moveRow(bk, bk.Key(), row, plaintags, randtags)
+}
+
+func checkTagRename(oldPlain, newPlain string) error {
+	if oldPlain == "" || newPlain == "" {
+		return ErrEmptyTag
+	}
+	if oldPlain == newPlain {
+		return ErrSameTag
+	}
+	for _, plain := range []string{oldPlain, newPlain} {
+		if plain == "all" || strings.HasPrefix(plain, "id:") {
+			return ErrReservedTag
+		}
+	}
+	return nil
+}
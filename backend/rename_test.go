@@ -0,0 +1,124 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameTag(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	row := mustCreateRow(t, m, nil, "one", "githbu", "work")
+
+	before, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	oldRandom := before.WithPlainPattern("githbu")[0].Random
+
+	if !assert.Nil(t, RenameTag(m, "githbu", "github")) {
+		return
+	}
+
+	// Only the TagPair changed; the Row wasn't touched
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, len(before), len(pairs))
+	assert.Equal(t, 0, len(pairs.WithPlainPattern("githbu")))
+	if renamed := pairs.WithPlainPattern("github"); assert.Equal(t, 1, len(renamed)) {
+		assert.Equal(t, oldRandom, renamed[0].Random)
+	}
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"github", "work"})
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(rows)) {
+		assert.Equal(t, row.RandomTags, rows[0].RandomTags)
+		assert.True(t, rows[0].HasPlainTag("github"))
+	}
+
+	assert.NotNil(t, RenameTag(m, "githbu", "github"))
+}
+
+func TestRenameTagExisting(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "one", "githbu")
+	mustCreateRow(t, m, nil, "two", "github")
+
+	// Merged, since "github" already exists
+	if !assert.Nil(t, RenameTag(m, "githbu", "github")) {
+		return
+	}
+
+	pairs, err := m.AllTagPairs(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 0, len(pairs.WithPlainPattern("githbu")))
+	assert.Equal(t, 1, len(pairs.WithPlainPattern("github")))
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"github"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"one", "two"}, decryptedData(rows))
+	}
+}
+
+func TestRenameTagInvalid(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	row := mustCreateRow(t, m, nil, "one", "work")
+
+	tests := []struct {
+		oldPlain, newPlain string
+		err                error
+	}{
+		{"all", "everything", ErrReservedTag},
+		{"work", "all", ErrReservedTag},
+		{row.IDTag(), "id:mine", ErrReservedTag},
+		{"work", "id:mine", ErrReservedTag},
+		{"work", "work", ErrSameTag},
+		{"", "work", ErrEmptyTag},
+		{"work", "", ErrEmptyTag},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.err, RenameTag(m, tt.oldPlain, tt.newPlain),
+			"%q -> %q", tt.oldPlain, tt.newPlain)
+		assert.Equal(t, tt.err, MergeTag(m, tt.oldPlain, tt.newPlain),
+			"%q -> %q", tt.oldPlain, tt.newPlain)
+	}
+
+	assert.NotNil(t, RenameTag(m, "nonexistent", "work"))
+}
+
+func TestMergeTagSealedToOthers(t *testing.T) {
+	useTestIdentity(t)
+	m := newTestMemory(t, Settings{})
+
+	mustCreateRow(t, m, nil, "mine", "githbu")
+
+	other, err := taber.RandomKey()
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	_, err = CreateRowForRecipients(m, nil, []byte("theirs"),
+		[]string{"githbu"}, []*taber.Keys{other})
+	if err != nil {
+		t.Fatalf("Error creating sealed Row: %v", err)
+	}
+
+	err = MergeTag(m, "githbu", "github")
+	if assert.IsType(t, &SealedToOthersError{}, err) {
+		assert.Equal(t, 1, len(err.(*SealedToOthersError).RandomTags))
+	}
+
+	// Nothing was re-tagged
+	rows, err := RowsFromPlainTags(m, nil, []string{"githbu"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"mine"}, decryptedData(rows))
+	}
+	_, err = RowsFromPlainTags(m, nil, []string{"github"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,445 @@
+// Steve Phillips / elimisteve
+// 2017.04.04
+
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+var (
+	ErrRotationInProgress = errors.New("A key rotation with a different new key" +
+		" is already in progress for this Backend")
+	ErrRotationKeyUnknown = errors.New("Cannot decrypt new key of in-progress" +
+		" key rotation with current Backend key")
+)
+
+// rotation is the on-disk state of an in-progress key rotation, which
+// lets RotateKey pick up where it left off after being interrupted.
+type rotation struct {
+	// New key, encrypted with the Backend's current (old) key
+	NewKeyEncrypted []byte    `json:"new_key_encrypted"`
+	NewKeyNonce     *[24]byte `json:"new_key_nonce"`
+
+	// SHA-256 of the new key; lets us tell when the Backend has
+	// already been switched over to it
+	NewKeyHash []byte `json:"new_key_hash"`
+
+	// Every TagPair's RandomTag -> PlainTag mapping, encrypted with
+	// the new key, since TagPairs that have already been
+	// re-encrypted can no longer be fetched with the old key
+	PairsEncrypted []byte    `json:"pairs_encrypted,omitempty"`
+	PairsNonce     *[24]byte `json:"pairs_nonce,omitempty"`
+
+	newKey *[32]byte
+	pairs  map[string]string
+}
+
+func rotationFile(bkName string) string {
+	return path.Join(cryptag.TrustedBasePath, "rotations", bkName+".json")
+}
+
+// RotateKey re-encrypts every Row and TagPair in bk under newKey
+// (with fresh nonces), keeping all RandomTags the same so that
+// queries still work, then saves bk's updated Config to
+// cryptag.BackendPath with newKey as its newest key.  Old keys stay
+// in the Config's key ring.  If newKey is nil, a new random key is
+// used.
+//
+// (To switch keys without re-encrypting existing data right away,
+// use UpdateKey instead.)
+//
+// Rows signed by other users are left encrypted with the old key
+// (which stays in the key ring) so as to keep their signatures; they
+// are listed in the *SignedByOthersError returned once the rotation
+// is otherwise done.
+//
+// Progress is saved to disk as RotateKey goes; if interrupted, call
+// it again (with the same newKey, or nil) to resume.  bk itself keeps
+// using the old key; load it again afterward to use the new one.
+func RotateKey(bk Backend, newKey *[32]byte) error {
+	oldKey := bk.Key()
+	if oldKey == nil {
+		return cryptag.ErrNilKey
+	}
+
+	stateFile := rotationFile(bk.Name())
+
+	rot, err := loadRotation(stateFile, oldKey)
+	if err != nil {
+		return err
+	}
+
+	if rot == nil {
+		if newKey == nil {
+			if newKey, err = cryptag.RandomKey(); err != nil {
+				return err
+			}
+		}
+		if rot, err = newRotation(oldKey, newKey); err != nil {
+			return err
+		}
+		if err = rot.save(stateFile); err != nil {
+			return err
+		}
+	} else if newKey != nil && *newKey != *rot.newKey {
+		return ErrRotationInProgress
+	}
+
+	if rot.isDoneFor(oldKey) {
+		// Config was updated but state file not yet removed
+		return os.Remove(stateFile)
+	}
+
+	// Remember all TagPairs before re-encrypting any of them
+	if rot.pairs == nil {
+		pairs, err := bk.AllTagPairs(nil)
+		if err != nil {
+			return fmt.Errorf("Error fetching TagPairs: %v", err)
+		}
+
+		rot.pairs = make(map[string]string, len(pairs))
+		for _, pair := range pairs {
+			rot.pairs[pair.Random] = pair.Plain()
+		}
+
+		if err = rot.save(stateFile); err != nil {
+			return err
+		}
+	}
+
+	skipped, err := rotateRows(bk, rot)
+	if err != nil {
+		return err
+	}
+
+	if err = rotateTagPairs(bk, rot); err != nil {
+		return err
+	}
+
+	cfg, err := bk.ToConfig()
+	if err != nil {
+		return err
+	}
+	cfg.AddKey(rot.newKey)
+
+	if err = cfg.Update(cryptag.BackendPath); err != nil {
+		return fmt.Errorf("Error saving Backend Config with new key: %v", err)
+	}
+
+	if err = os.Remove(stateFile); err != nil {
+		return err
+	}
+
+	if len(skipped) > 0 {
+		return &SignedByOthersError{RandomTags: skipped}
+	}
+	return nil
+}
+
+// rotateRows re-encrypts bk's Rows under rot.newKey, skipping those
+// signed by other users, whose RandomTags are returned.
+func rotateRows(bk Backend, rot *rotation) (skipped []cryptag.RandomTags, err error) {
+	// "all" may have duplicate TagPairs; see RepairDuplicateTags
+	var allRandom cryptag.RandomTags
+	for random, plain := range rot.pairs {
+		if plain == "all" {
+			allRandom = append(allRandom, random)
+		}
+	}
+
+	rows, err := rowsWithAnyRandomTag(bk, allRandom)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching Rows: %v", err)
+	}
+
+	ring := KeyRing(bk)
+
+	for _, row := range rows {
+		if row.KeyID == cryptag.KeyID(rot.newKey) {
+			// Already re-encrypted before being interrupted
+			continue
+		}
+		if row.HasRecipients() {
+			// Sealed to recipients, not encrypted with bk's key
+			continue
+		}
+		if signedByOthers(row) {
+			// Still decryptable with the old key
+			skipped = append(skipped, row.RandomTags)
+			continue
+		}
+		if row.Streamed {
+			err = rotateStreamedRow(bk, row, ring, rot.newKey)
+		} else {
+			err = rotateRow(bk, row, ring, rot.newKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error re-encrypting Row with RandomTags %v: %v",
+				row.RandomTags, err)
+		}
+	}
+
+	if types.Debug {
+		log.Printf("rotateRows: re-encrypted %d Rows\n", len(rows)-len(skipped))
+	}
+
+	return skipped, nil
+}
+
+// rowsWithAnyRandomTag returns every Row in bk tagged with at least
+// one of randtags, such as each of the RandomTags of a PlainTag with
+// duplicate TagPairs.
+func rowsWithAnyRandomTag(bk Backend, randtags cryptag.RandomTags) (types.Rows, error) {
+	var rows types.Rows
+	seen := map[string]bool{}
+
+	for _, random := range randtags {
+		matches, err := bk.RowsFromRandomTags(cryptag.RandomTags{random})
+		if err == types.ErrRowsNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range matches {
+			// A Row's RandomTags identify it
+			key := strings.Join(row.RandomTags, "-")
+			if !seen[key] {
+				seen[key] = true
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func rotateRow(bk Backend, row *types.Row, ring cryptag.KeyRing, newKey *[32]byte) error {
+	keys, err := ring.Candidates(row.KeyID)
+	if err != nil {
+		return err
+	}
+
+	// Re-encrypt the decrypted data as-is, envelope and all
+	var plain []byte
+	for _, key := range keys {
+		if plain, err = cryptag.DecryptVersioned(row.Encrypted, row.Nonce, key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	nonce, err := cryptag.RandomNonce()
+	if err != nil {
+		return err
+	}
+
+	enc, err := cryptag.EncryptWithCipher(GetSettings(bk).Cipher, plain, nonce, newKey)
+	if err != nil {
+		return err
+	}
+
+	row.Encrypted = enc
+	row.Nonce = nonce
+	row.KeyID = cryptag.KeyID(newKey)
+
+	// The old signature no longer matches
+	if err = resignRow(row); err != nil {
+		return err
+	}
+
+	return bk.SaveRow(row)
+}
+
+func rotateStreamedRow(bk Backend, row *types.Row, ring cryptag.KeyRing, newKey *[32]byte) error {
+	sbk, ok := bk.(StreamBackend)
+	if !ok {
+		return ErrStreamingNotSupported
+	}
+
+	// Fails before anything is overwritten if the stream doesn't
+	// decrypt
+	src, err := openRowStream(sbk, row, ring)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	nonce, err := cryptag.RandomNonce()
+	if err != nil {
+		return err
+	}
+
+	newRow := *row
+	newRow.Nonce = nonce
+	newRow.KeyID = cryptag.KeyID(newKey)
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		_, err := cryptag.EncryptStream(pw, src, nonce, newKey)
+		pw.CloseWithError(err)
+	}()
+
+	err = sbk.SaveRowStream(&newRow, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+
+	return nil
+}
+
+func rotateTagPairs(bk Backend, rot *rotation) error {
+	cipherName := GetSettings(bk).Cipher
+
+	for random, plain := range rot.pairs {
+		nonce, err := cryptag.RandomNonce()
+		if err != nil {
+			return err
+		}
+
+		enc, err := cryptag.EncryptWithCipher(cipherName, []byte(plain), nonce,
+			rot.newKey)
+		if err != nil {
+			return err
+		}
+
+		pair := types.NewTagPair(enc, random, nonce, plain)
+		pair.KeyID = cryptag.KeyID(rot.newKey)
+
+		if err = bk.SaveTagPair(pair); err != nil {
+			return fmt.Errorf("Error saving re-encrypted TagPair: %v", err)
+		}
+	}
+
+	if types.Debug {
+		log.Printf("rotateTagPairs: re-encrypted %d TagPairs\n", len(rot.pairs))
+	}
+
+	return nil
+}
+
+//
+// Rotation state
+//
+
+func newRotation(oldKey, newKey *[32]byte) (*rotation, error) {
+	nonce, err := cryptag.RandomNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := cryptag.Encrypt(newKey[:], nonce, oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(newKey[:])
+
+	rot := &rotation{
+		NewKeyEncrypted: enc,
+		NewKeyNonce:     nonce,
+		NewKeyHash:      hash[:],
+		newKey:          newKey,
+	}
+	return rot, nil
+}
+
+// loadRotation loads the in-progress rotation stored in stateFile,
+// if any, decrypting its new key with oldKey.  Returns nil, nil if
+// there is no rotation in progress.
+func loadRotation(stateFile string, oldKey *[32]byte) (*rotation, error) {
+	b, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rot rotation
+	if err = json.Unmarshal(b, &rot); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling key rotation state `%s`: %v",
+			stateFile, err)
+	}
+
+	if rot.isDoneFor(oldKey) {
+		rot.newKey = oldKey
+		return &rot, nil
+	}
+
+	keyB, err := cryptag.Decrypt(rot.NewKeyEncrypted, rot.NewKeyNonce, oldKey)
+	if err != nil {
+		return nil, ErrRotationKeyUnknown
+	}
+	if rot.newKey, err = cryptag.ConvertKey(keyB); err != nil {
+		return nil, err
+	}
+
+	if len(rot.PairsEncrypted) > 0 {
+		pairsB, err := cryptag.Decrypt(rot.PairsEncrypted, rot.PairsNonce,
+			rot.newKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error decrypting saved TagPairs: %v", err)
+		}
+		if err = json.Unmarshal(pairsB, &rot.pairs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &rot, nil
+}
+
+// isDoneFor reports whether key is the new key, meaning the Backend
+// using key has already been switched over to it.
+func (rot *rotation) isDoneFor(key *[32]byte) bool {
+	hash := sha256.Sum256(key[:])
+	return bytes.Equal(hash[:], rot.NewKeyHash)
+}
+
+func (rot *rotation) save(stateFile string) error {
+	if rot.pairs != nil {
+		pairsB, err := json.Marshal(rot.pairs)
+		if err != nil {
+			return err
+		}
+		nonce, err := cryptag.RandomNonce()
+		if err != nil {
+			return err
+		}
+		rot.PairsEncrypted, err = cryptag.Encrypt(pairsB, nonce, rot.newKey)
+		if err != nil {
+			return err
+		}
+		rot.PairsNonce = nonce
+	}
+
+	b, err := json.Marshal(rot)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(path.Dir(stateFile), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(stateFile, b, 0600)
+}
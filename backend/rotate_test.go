@@ -0,0 +1,121 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestInterrupted = errors.New("Interrupted by test")
+
+// interruptedBackend fails every SaveRow call after the first left.
+type interruptedBackend struct {
+	*Memory
+	left int
+}
+
+func (bk *interruptedBackend) SaveRow(row *types.Row) error {
+	if bk.left == 0 {
+		return errTestInterrupted
+	}
+	bk.left--
+	return bk.Memory.SaveRow(row)
+}
+
+// createRowsWithDuplicateAll creates Rows in m, one of which is
+// tagged with a second TagPair for "all".
+func createRowsWithDuplicateAll(t *testing.T, m *Memory) {
+	mustCreateRow(t, m, nil, "one", "work")
+	mustCreateRow(t, m, nil, "two", "work")
+	mustCreateRow(t, m, nil, "three", "home")
+
+	dupAll, err := createTag(m, m.Key(), "all", nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating duplicate TagPair: %v", err)
+	}
+	mustCreateRow(t, m, types.TagPairs{dupAll}, "four", "home")
+
+	pairs, err := m.AllTagPairs(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pairs.Duplicates()["all"]))
+}
+
+func assertAllRowsUseKey(t *testing.T, m *Memory, key *[32]byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, row := range m.rows {
+		assert.Equal(t, cryptag.KeyID(key), row.KeyID, "%v", row.RandomTags)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	createRowsWithDuplicateAll(t, m)
+
+	oldKey := m.Key()
+	newKey, _ := cryptag.RandomKey()
+
+	assert.Nil(t, RotateKey(m, newKey))
+	assertAllRowsUseKey(t, m, newKey)
+
+	_, err := os.Stat(rotationFile(m.Name()))
+	assert.True(t, os.IsNotExist(err))
+
+	reloadConfig(t, m)
+	assert.Equal(t, newKey, m.Key())
+	assert.Equal(t, cryptag.KeyRing{oldKey, newKey}, m.KeyRing())
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"all"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"four", "one", "three", "two"}, decryptedData(rows))
+}
+
+func TestRotateKeyResume(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	createRowsWithDuplicateAll(t, m)
+
+	newKey, _ := cryptag.RandomKey()
+
+	err := RotateKey(&interruptedBackend{m, 2}, newKey)
+	assert.NotNil(t, err)
+
+	// Resuming with a different key isn't allowed
+	otherKey, _ := cryptag.RandomKey()
+	assert.Equal(t, ErrRotationInProgress, RotateKey(m, otherKey))
+
+	// nil means "whichever key the interrupted rotation was using"
+	assert.Nil(t, RotateKey(m, nil))
+	assertAllRowsUseKey(t, m, newKey)
+
+	reloadConfig(t, m)
+	assert.Equal(t, newKey, m.Key())
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"home"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"four", "three"}, decryptedData(rows))
+}
+
+func TestMigrateToBlindTagsDuplicateAll(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	createRowsWithDuplicateAll(t, m)
+
+	assert.Nil(t, MigrateToBlindTags(m))
+	reloadConfig(t, m)
+	assert.True(t, UsesBlindTags(m))
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"all"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"four", "one", "three", "two"}, decryptedData(rows))
+
+	pairs, err := m.AllTagPairs(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pairs.Duplicates()))
+}
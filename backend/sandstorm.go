@@ -31,7 +31,14 @@ func SandstormFromConfig(cfg *Config) (*WebserverBackend, error) {
 	}
 	baseURL, authToken := info[0], info[1]
 
-	return NewWebserverBackend((*cfg.Key)[:], cfg.Name, baseURL, authToken)
+	wb, err := NewWebserverBackend((*cfg.Key)[:], cfg.Name, baseURL, authToken)
+	if err != nil {
+		return nil, err
+	}
+	wb.oldKeys = cfg.OldKeys
+	wb.settings = cfg.Settings
+
+	return wb, nil
 }
 
 func CreateSandstormWebserver(key []byte, bkName, webkey string) (*WebserverBackend, error) {
@@ -0,0 +1,190 @@
+// Steve Phillips / elimisteve
+// 2017.04.25
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cryptag/cryptag/types"
+)
+
+// Saved searches are named Queries stored in a Backend as encrypted
+// Rows tagged "type:savedsearch", so that they sync to every machine
+// using it.  Their names are stored in their (encrypted) data rather
+// than in tags so that naming one doesn't create a new TagPair.
+
+var (
+	ErrSavedSearchNotFound = errors.New("Saved search not found")
+	ErrEmptySearchName     = errors.New("Saved search name cannot be empty")
+)
+
+// SavedSearch is a named Query; see ParseQuery for its syntax, which
+// includes plain lists of tags.
+type SavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+
+	row *types.Row
+}
+
+// Parse parses s.Query.
+func (s *SavedSearch) Parse() (*Query, error) {
+	return ParseQuery(s.Query)
+}
+
+// SaveSearch saves query under name, replacing the saved search
+// already named name, if any.
+func SaveSearch(bk Backend, pairs types.TagPairs, name, query string) (*SavedSearch, error) {
+	if name == "" {
+		return nil, ErrEmptySearchName
+	}
+	if _, err := ParseQuery(query); err != nil {
+		return nil, err
+	}
+
+	pairs, err := tagPairsIfNeeded(bk, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	searches, err := savedSearches(bk, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SavedSearch{Name: name, Query: query}
+
+	s.row, err = CreateJSONRow(bk, pairs, s, []string{"type:savedsearch"})
+	if err != nil {
+		return nil, fmt.Errorf("Error saving search: %v", err)
+	}
+
+	// Delete the searches that s replaces
+	for _, old := range searches {
+		if old.Name != name {
+			continue
+		}
+		err = DeleteRows(bk, pairs, []string{old.row.IDTag()})
+		if err != nil && err != types.ErrRowsNotFound {
+			return nil, fmt.Errorf("Error deleting old version of saved search: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// SavedSearches returns bk's saved searches, sorted by name.
+func SavedSearches(bk Backend, pairs types.TagPairs) ([]*SavedSearch, error) {
+	all, err := savedSearches(bk, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	// If a search was saved on two machines at once, keep the newest
+	var searches []*SavedSearch
+	for i, s := range all {
+		if i == len(all)-1 || all[i+1].Name != s.Name {
+			searches = append(searches, s)
+		}
+	}
+
+	return searches, nil
+}
+
+// GetSavedSearch returns the saved search named name.
+func GetSavedSearch(bk Backend, pairs types.TagPairs, name string) (*SavedSearch, error) {
+	searches, err := SavedSearches(bk, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range searches {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+
+	return nil, ErrSavedSearchNotFound
+}
+
+// RunSavedSearch returns the Rows that the saved search named name
+// matches, paged according to opts.
+func RunSavedSearch(bk Backend, pairs types.TagPairs, name string, opts types.PageOptions) (types.Rows, error) {
+	s, err := GetSavedSearch(bk, pairs, name)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := s.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return RowsFromQueryPage(bk, pairs, q, opts)
+}
+
+// DeleteSavedSearch deletes the saved search named name.
+func DeleteSavedSearch(bk Backend, pairs types.TagPairs, name string) error {
+	pairs, err := tagPairsIfNeeded(bk, pairs)
+	if err != nil {
+		return err
+	}
+
+	searches, err := savedSearches(bk, pairs)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, s := range searches {
+		if s.Name != name {
+			continue
+		}
+		found = true
+		err = DeleteRows(bk, pairs, []string{s.row.IDTag()})
+		if err != nil && err != types.ErrRowsNotFound {
+			return err
+		}
+	}
+
+	if !found {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// savedSearches returns every saved search in bk, sorted by name then
+// by when they were saved.
+func savedSearches(bk Backend, pairs types.TagPairs) ([]*SavedSearch, error) {
+	rows, err := RowsFromPlainTags(bk, pairs, []string{"type:savedsearch"})
+	if err == types.ErrRowsNotFound || err == types.ErrTagPairNotFound ||
+		(err != nil && isPlainTagNotFound(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	searches := make([]*SavedSearch, 0, len(rows))
+	for _, row := range rows {
+		var s SavedSearch
+		if err = json.Unmarshal(row.Decrypted(), &s); err != nil {
+			return nil, fmt.Errorf("Error parsing saved search: %v", err)
+		}
+		s.row = row
+		searches = append(searches, &s)
+	}
+
+	sort.SliceStable(searches, func(i, j int) bool {
+		if searches[i].Name != searches[j].Name {
+			return searches[i].Name < searches[j].Name
+		}
+		return searches[i].row.Created().Before(searches[j].row.Created())
+	})
+
+	return searches, nil
+}
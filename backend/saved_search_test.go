@@ -0,0 +1,139 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func savedSearchQueries(t *testing.T, bk Backend) map[string]string {
+	searches, err := SavedSearches(bk, nil)
+	if err != nil {
+		t.Fatalf("Error getting saved searches: %v", err)
+	}
+	queries := map[string]string{}
+	for _, s := range searches {
+		queries[s.Name] = s.Query
+	}
+	return queries
+}
+
+func TestSaveSearch(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	// None saved yet
+	assert.Equal(t, map[string]string{}, savedSearchQueries(t, m))
+	_, err := GetSavedSearch(m, nil, "work")
+	assert.Equal(t, ErrSavedSearchNotFound, err)
+
+	_, err = SaveSearch(m, nil, "", "work")
+	assert.Equal(t, ErrEmptySearchName, err)
+	_, err = SaveSearch(m, nil, "bad", "(work")
+	assert.NotNil(t, err)
+
+	for _, q := range []string{"work", "work -archived"} {
+		if _, err = SaveSearch(m, nil, "work", q); err != nil {
+			t.Fatalf("Error saving search: %v", err)
+		}
+	}
+	if _, err = SaveSearch(m, nil, "code", "github | gitlab"); err != nil {
+		t.Fatalf("Error saving search: %v", err)
+	}
+
+	// Saving under the same name replaces the old one
+	assert.Equal(t, map[string]string{"code": "github | gitlab",
+		"work": "work -archived"}, savedSearchQueries(t, m))
+
+	all, err := savedSearches(m, nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, len(all))
+	}
+}
+
+func TestSavedSearchesSavedAtOnce(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	// As two machines saving the same search at once would, neither
+	// seeing the other's
+	for _, q := range []string{"older", "newer"} {
+		_, err := CreateJSONRow(m, nil, &SavedSearch{Name: "work", Query: q},
+			[]string{"type:savedsearch"})
+		if err != nil {
+			t.Fatalf("Error saving search: %v", err)
+		}
+	}
+
+	assert.Equal(t, map[string]string{"work": "newer"}, savedSearchQueries(t, m))
+
+	s, err := GetSavedSearch(m, nil, "work")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "newer", s.Query)
+	}
+
+	// Deleting it deletes both
+	assert.Nil(t, DeleteSavedSearch(m, nil, "work"))
+	all, err := savedSearches(m, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(all))
+}
+
+func TestRunSavedSearch(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "github", "github", "work")
+	mustCreateRow(t, m, nil, "gitlab", "gitlab", "work")
+	mustCreateRow(t, m, nil, "old gitlab", "gitlab", "work", "archived")
+	mustCreateRow(t, m, nil, "home", "home")
+
+	searches := map[string]string{
+		"code":   "(github | gitlab) -archived",
+		"gitlab": "gitlab work",
+	}
+	for name, q := range searches {
+		if _, err := SaveSearch(m, nil, name, q); err != nil {
+			t.Fatalf("Error saving search: %v", err)
+		}
+	}
+
+	rows, err := RunSavedSearch(m, nil, "code", types.PageOptions{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"github", "gitlab"}, decryptedData(rows))
+	}
+
+	// Plain lists of tags
+	rows, err = RunSavedSearch(m, nil, "gitlab", types.PageOptions{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"gitlab", "old gitlab"}, decryptedData(rows))
+	}
+
+	rows, err = RunSavedSearch(m, nil, "gitlab", types.PageOptions{Limit: 1,
+		Order: types.OrderNewest})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"old gitlab"}, decryptedData(rows))
+	}
+
+	_, err = RunSavedSearch(m, nil, "nope", types.PageOptions{})
+	assert.Equal(t, ErrSavedSearchNotFound, err)
+}
+
+func TestDeleteSavedSearch(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	assert.Equal(t, ErrSavedSearchNotFound, DeleteSavedSearch(m, nil, "work"))
+
+	for _, name := range []string{"work", "home"} {
+		if _, err := SaveSearch(m, nil, name, name); err != nil {
+			t.Fatalf("Error saving search: %v", err)
+		}
+	}
+
+	assert.Nil(t, DeleteSavedSearch(m, nil, "work"))
+	assert.Equal(t, map[string]string{"home": "home"}, savedSearchQueries(t, m))
+
+	assert.Equal(t, ErrSavedSearchNotFound, DeleteSavedSearch(m, nil, "work"))
+	_, err := RunSavedSearch(m, nil, "work", types.PageOptions{})
+	assert.Equal(t, ErrSavedSearchNotFound, err)
+}
@@ -0,0 +1,477 @@
+// Steve Phillips / elimisteve
+// 2017.04.20
+
+package backend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/rowutil"
+	"github.com/cryptag/cryptag/types"
+)
+
+// Full-text search over a Backend's text Rows ("type:text") uses an
+// inverted index kept on this machine, since Backends can't read what
+// they store.  The index is encrypted with a key derived from the
+// Backend's key and stored under SearchIndexDir, in a file named
+// after the Backend and the ID of that key so that different Backends
+// with the same name don't share one.  It's created by the
+// first search, updated as this client creates and deletes Rows, and
+// caught up with Rows created or deleted elsewhere before each search.
+
+// SearchIndexDir is where the encrypted search index of each Backend
+// is stored.
+var SearchIndexDir = filepath.Join(cryptag.TrustedBasePath, "search")
+
+// searchMu guards the search index files
+var searchMu sync.Mutex
+
+// searchIndex maps the "id:..." tag of each indexed Row to the terms
+// in its text.
+type searchIndex struct {
+	Docs map[string][]string `json:"docs"`
+
+	postings map[string]map[string]bool // term -> id tags
+	changed  bool
+}
+
+// searchIndexFile is how a searchIndex is stored on disk.
+type searchIndexFile struct {
+	KeyID     string    `json:"key_id"`
+	Nonce     *[24]byte `json:"nonce"`
+	Encrypted []byte    `json:"encrypted"`
+}
+
+// Search returns the text Rows of bk containing phrase (ignoring case
+// and punctuation), oldest first.  If pairs is nil, the TagPairs
+// needed are fetched from bk.
+func Search(bk Backend, pairs types.TagPairs, phrase string) (types.Rows, error) {
+	terms := searchTerms(phrase)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	idx, err := loadSearchIndex(bk)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = idx.catchUp(bk, pairs); err != nil {
+		return nil, err
+	}
+
+	if err = saveSearchIndex(bk, idx); err != nil {
+		return nil, err
+	}
+
+	ids := idx.candidates(terms)
+	if len(ids) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	rows, err := RowsByIDs(bk, pairs, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches types.Rows
+	for _, row := range rows {
+		if containsTerms(searchTerms(string(row.Decrypted())), terms) {
+			matches = append(matches, row)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, types.ErrRowsNotFound
+	}
+
+	matches.Sort(rowutil.ByTagPrefix("created:", true))
+
+	return matches, nil
+}
+
+// UpdateSearchIndex creates bk's search index, or catches it up with
+// the Rows created or deleted since it was last updated.
+func UpdateSearchIndex(bk Backend, pairs types.TagPairs) error {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	idx, err := loadSearchIndex(bk)
+	if err != nil {
+		return err
+	}
+
+	if err = idx.catchUp(bk, pairs); err != nil {
+		return err
+	}
+
+	return saveSearchIndex(bk, idx)
+}
+
+// DeleteSearchIndex deletes bk's search index, if it has one.
+func DeleteSearchIndex(bk Backend) error {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	for _, key := range KeyRing(bk) {
+		err := os.Remove(searchIndexPath(bk, key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRows adds rows to bk's search index if it has one.  Errors are
+// logged rather than returned since the next search catches the index
+// up anyway.
+func indexRows(bk Backend, rows ...*types.Row) {
+	updateSearchIndexIfExists(bk, func(idx *searchIndex) {
+		for _, row := range rows {
+			idx.add(row)
+		}
+	})
+}
+
+// unindexRows removes rows from bk's search index if it has one.
+func unindexRows(bk Backend, rows types.Rows) {
+	updateSearchIndexIfExists(bk, func(idx *searchIndex) {
+		for _, row := range rows {
+			idx.remove(row.IDTag())
+		}
+	})
+}
+
+func updateSearchIndexIfExists(bk Backend, update func(*searchIndex)) {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	if !hasSearchIndex(bk) {
+		return
+	}
+
+	idx, err := loadSearchIndex(bk)
+	if err == nil {
+		update(idx)
+		err = saveSearchIndex(bk, idx)
+	}
+	if err != nil {
+		log.Printf("Error updating search index of Backend `%s`: %v\n",
+			bk.Name(), err)
+	}
+}
+
+func hasSearchIndex(bk Backend) bool {
+	key := KeyRing(bk).Newest()
+	if key == nil {
+		return false
+	}
+	_, err := os.Stat(searchIndexPath(bk, key))
+	return err == nil
+}
+
+// searchIndexPath returns where bk's search index encrypted with
+// (a key derived from) key is stored.
+func searchIndexPath(bk Backend, key *[32]byte) string {
+	return filepath.Join(SearchIndexDir,
+		fmt.Sprintf("%s-%s.json", bk.Name(), cryptag.KeyID(key)))
+}
+
+// removeOldSearchIndexes removes bk's search indexes encrypted with
+// keys other than its newest, which are rebuilt rather than used.
+func removeOldSearchIndexes(bk Backend) {
+	ring := KeyRing(bk)
+	for _, key := range ring[:len(ring)-1] {
+		err := os.Remove(searchIndexPath(bk, key))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing old search index of Backend `%s`: %v\n",
+				bk.Name(), err)
+		}
+	}
+}
+
+// searchIndexKey derives the key that bk's search index is encrypted
+// with from key, bk's newest key.
+func searchIndexKey(key *[32]byte) *[32]byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("cryptag search index key"))
+
+	var indexKey [32]byte
+	copy(indexKey[:], mac.Sum(nil))
+	return &indexKey
+}
+
+// loadSearchIndex reads and decrypts bk's search index, returning an
+// empty one if bk doesn't have one yet or only has one encrypted with
+// a key bk no longer uses (in which case it's rebuilt from scratch).
+func loadSearchIndex(bk Backend) (*searchIndex, error) {
+	key := KeyRing(bk).Newest()
+	if key == nil {
+		return nil, cryptag.ErrNilKey
+	}
+
+	idx := newSearchIndex()
+
+	b, err := ioutil.ReadFile(searchIndexPath(bk, key))
+	if os.IsNotExist(err) {
+		removeOldSearchIndexes(bk)
+		idx.changed = true
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f searchIndexFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling search index: %v", err)
+	}
+
+	if f.KeyID != cryptag.KeyID(key) {
+		if types.Debug {
+			log.Printf("Rebuilding search index of Backend `%s` after key change\n",
+				bk.Name())
+		}
+		idx.changed = true
+		return idx, nil
+	}
+
+	plain, err := cryptag.DecryptVersioned(f.Encrypted, f.Nonce, searchIndexKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting search index: %v", err)
+	}
+
+	if err = json.Unmarshal(plain, idx); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling search index: %v", err)
+	}
+	if idx.Docs == nil {
+		idx.Docs = map[string][]string{}
+	}
+
+	for id, terms := range idx.Docs {
+		idx.post(id, terms)
+	}
+
+	return idx, nil
+}
+
+// saveSearchIndex encrypts and saves idx as bk's search index, if it
+// has changed.
+func saveSearchIndex(bk Backend, idx *searchIndex) error {
+	if !idx.changed {
+		return nil
+	}
+
+	key := KeyRing(bk).Newest()
+	if key == nil {
+		return cryptag.ErrNilKey
+	}
+
+	plain, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := cryptag.RandomNonce()
+	if err != nil {
+		return err
+	}
+
+	enc, err := cryptag.EncryptVersioned(plain, nonce, searchIndexKey(key))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&searchIndexFile{
+		KeyID:     cryptag.KeyID(key),
+		Nonce:     nonce,
+		Encrypted: enc,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(SearchIndexDir, 0700); err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(searchIndexPath(bk, key), b, 0600); err != nil {
+		return err
+	}
+
+	idx.changed = false
+	return nil
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		Docs:     map[string][]string{},
+		postings: map[string]map[string]bool{},
+	}
+}
+
+// add indexes row's text, if row is a populated text Row.
+func (idx *searchIndex) add(row *types.Row) {
+	if !row.HasPlainTag("type:text") || row.HasPlainTag("type:file") {
+		return
+	}
+	id := row.IDTag()
+	if id == "" {
+		return
+	}
+
+	idx.remove(id)
+
+	var terms []string
+	seen := map[string]bool{}
+	for _, term := range searchTerms(string(row.Decrypted())) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	idx.Docs[id] = terms
+	idx.post(id, terms)
+	idx.changed = true
+}
+
+func (idx *searchIndex) post(id string, terms []string) {
+	for _, term := range terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[string]bool{}
+		}
+		idx.postings[term][id] = true
+	}
+}
+
+func (idx *searchIndex) remove(id string) {
+	terms, ok := idx.Docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.Docs, id)
+	idx.changed = true
+}
+
+// candidates returns the IDs of the indexed Rows containing every
+// one of terms.
+func (idx *searchIndex) candidates(terms []string) []string {
+	var ids []string
+	for id := range idx.postings[terms[0]] {
+		hasAll := true
+		for _, term := range terms[1:] {
+			if !idx.postings[term][id] {
+				hasAll = false
+				break
+			}
+		}
+		if hasAll {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// catchUp indexes bk's text Rows that idx is missing and removes
+// those that no longer exist.
+func (idx *searchIndex) catchUp(bk Backend, pairs types.TagPairs) error {
+	// No text Rows, or no "type:text" tag yet
+	rows, err := ListRowsFromPlainTags(bk, pairs, []string{"type:text"})
+	if err != nil && err != types.ErrRowsNotFound &&
+		err != types.ErrTagPairNotFound && !isPlainTagNotFound(err) {
+		return err
+	}
+
+	existing := make(map[string]bool, len(rows))
+	var missing []string
+	for _, row := range rows {
+		if row.HasPlainTag("type:file") {
+			continue
+		}
+		id := row.IDTag()
+		if id == "" {
+			continue
+		}
+		existing[id] = true
+		if _, ok := idx.Docs[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	for id := range idx.Docs {
+		if !existing[id] {
+			idx.remove(id)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if types.Debug {
+		log.Printf("Adding %d Rows to search index of Backend `%s`\n",
+			len(missing), bk.Name())
+	}
+
+	newRows, err := RowsByIDs(bk, pairs, missing)
+	if err != nil && err != types.ErrRowsNotFound {
+		return err
+	}
+	for _, row := range newRows {
+		idx.add(row)
+	}
+
+	return nil
+}
+
+// isPlainTagNotFound reports whether err is the error that
+// TagPairs.WithAllPlainTags returns when a PlainTag doesn't exist.
+func isPlainTagNotFound(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "PlainTag `") && strings.HasSuffix(msg, "` not found")
+}
+
+// searchTerms splits text into lowercase words.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// containsTerms reports whether phrase appears, in order, in terms.
+func containsTerms(terms, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(terms); i++ {
+		match := true
+		for j := range phrase {
+			if terms[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainsTerms(t *testing.T) {
+	terms := searchTerms("The quick brown fox -- jumps over the lazy dog!")
+	assert.Equal(t, []string{"the", "quick", "brown", "fox", "jumps", "over",
+		"the", "lazy", "dog"}, terms)
+
+	tests := []struct {
+		phrase string
+		want   bool
+	}{
+		{"quick", true},
+		{"Quick, brown", true},
+		{"the lazy dog", true},
+		{"the quick", true},
+		{"brown quick", false},
+		{"fox over", false},
+		{"lazy dog cat", false},
+		{"quic", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, containsTerms(terms, searchTerms(tt.phrase)), tt.phrase)
+	}
+}
+
+func newTestTextRow(t *testing.T, id, text string, plaintags ...string) *types.Row {
+	row, err := types.NewRowSimple([]byte(text),
+		append([]string{"id:" + id, "type:text"}, plaintags...))
+	if err != nil {
+		t.Fatalf("Error creating Row: %v", err)
+	}
+	return row
+}
+
+func TestSearchIndexCandidates(t *testing.T) {
+	idx := newSearchIndex()
+	idx.add(newTestTextRow(t, "1", "red green blue"))
+	idx.add(newTestTextRow(t, "2", "green blue"))
+	idx.add(newTestTextRow(t, "3", "blue blue blue"))
+	idx.add(newTestTextRow(t, "4", "red green blue", "type:file"))
+
+	tests := []struct {
+		terms []string
+		ids   []string
+	}{
+		{[]string{"blue"}, []string{"id:1", "id:2", "id:3"}},
+		{[]string{"green", "blue"}, []string{"id:1", "id:2"}},
+		{[]string{"blue", "red"}, []string{"id:1"}},
+		{[]string{"red", "purple"}, nil},
+		{[]string{"purple"}, nil},
+	}
+	for _, tt := range tests {
+		ids := idx.candidates(tt.terms)
+		sort.Strings(ids)
+		assert.Equal(t, tt.ids, ids, "%v", tt.terms)
+	}
+
+	// Re-adding a Row replaces its terms
+	idx.add(newTestTextRow(t, "1", "purple"))
+	assert.Equal(t, []string{"id:1"}, idx.candidates([]string{"purple"}))
+	assert.Equal(t, 0, len(idx.candidates([]string{"red"})))
+
+	idx.remove("id:1")
+	assert.Equal(t, 0, len(idx.candidates([]string{"purple"})))
+}
+
+func TestSearchIndexCatchUp(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+
+	// No text Rows yet
+	idx := newSearchIndex()
+	assert.Nil(t, idx.catchUp(m, nil))
+	assert.Equal(t, 0, len(idx.Docs))
+
+	one := mustCreateRow(t, m, nil, "one fish", "type:text")
+	mustCreateRow(t, m, nil, "two fish", "type:text")
+	mustCreateRow(t, m, nil, "red fish", "type:text", "type:file")
+	mustCreateRow(t, m, nil, "blue fish", "type:password")
+
+	assert.Nil(t, idx.catchUp(m, nil))
+	assert.Equal(t, 2, len(idx.Docs))
+	assert.Equal(t, 2, len(idx.candidates([]string{"fish"})))
+
+	assert.Nil(t, m.DeleteRows(one.RandomTags))
+	mustCreateRow(t, m, nil, "old fish", "type:text")
+
+	assert.Nil(t, idx.catchUp(m, nil))
+	assert.Equal(t, 2, len(idx.Docs))
+	assert.Equal(t, 0, len(idx.candidates([]string{"one"})))
+	assert.Equal(t, 1, len(idx.candidates([]string{"old"})))
+}
+
+func TestSearchIndexPerKey(t *testing.T) {
+	m := newTestMemory(t, Settings{})
+	mustCreateRow(t, m, nil, "the first backend", "type:text")
+
+	// Another Backend with the same name but a different key
+	other, err := NewMemory(&Config{Name: m.Name(), Type: TypeMemory})
+	if err != nil {
+		t.Fatalf("Error creating Memory backend: %v", err)
+	}
+	mustCreateRow(t, other, nil, "the second backend", "type:text")
+
+	rows, err := Search(m, nil, "backend")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"the first backend"}, decryptedData(rows))
+
+	rows, err = Search(other, nil, "backend")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"the second backend"}, decryptedData(rows))
+
+	oldPath := searchIndexPath(m, m.Key())
+	assert.NotEqual(t, oldPath, searchIndexPath(other, other.Key()))
+
+	// Rotating keys replaces the index encrypted with the old one
+	newKey, _ := cryptag.RandomKey()
+	assert.Nil(t, RotateKey(m, newKey))
+	reloadConfig(t, m)
+
+	rows, err = Search(m, nil, "first")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"the first backend"}, decryptedData(rows))
+
+	_, err = os.Stat(oldPath)
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, hasSearchIndex(m))
+
+	assert.Nil(t, DeleteSearchIndex(m))
+	assert.False(t, hasSearchIndex(m))
+	assert.True(t, hasSearchIndex(other))
+}
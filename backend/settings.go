@@ -0,0 +1,178 @@
+// Steve Phillips / elimisteve
+// 2017.04.07
+
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+// Row filenames are made of their RandomTags, so don't let them get
+// too long
+const maxMinTags = 20
+
+// Settings are per-Backend options, saved in each Backend's Config,
+// that control how data is encoded, encrypted, and stored.  The zero
+// value is the default (and legacy) behavior.  Rows whose data is
+// streamed only honor some of them; see CreateRowFromReader.
+type Settings struct {
+	// How to pad Row data before encryption: "" (no padding),
+	// "pow2", or "block:N"; see types.ParsePadding
+	Padding string `json:",omitempty"`
+
+	// How to compress Row data before padding and encryption: ""
+	// (no compression) or "gzip".  Compression can leak information
+	// about the contents of Rows through their size, so leave it off
+	// if that matters to you
+	Compression string `json:",omitempty"`
+
+	// Name of the cryptag.Cipher to encrypt new Rows and TagPairs
+	// with; "" means cryptag.DefaultCipher
+	Cipher string `json:",omitempty"`
+
+	// How RandomTags are generated: TagModeRandom or TagModeHMAC.
+	// Switch existing Backends to TagModeHMAC with
+	// MigrateToBlindTags
+	TagMode string `json:",omitempty"`
+
+	// ID of the key that TagModeHMAC's blind index key is derived
+	// from
+	TagKeyID string `json:",omitempty"`
+
+	// Pad each new Row's RandomTags with decoy RandomTags until it
+	// has at least this many, hiding how many tags it really has
+	MinTags int `json:",omitempty"`
+
+	// Sign each new Row with this user's identity so that others
+	// sharing this Backend can verify who wrote it (see types.Row.Sign)
+	Sign bool `json:",omitempty"`
+
+	// Length of new RandomTags in TagModeRandom; 0 means
+	// RANDOM_TAG_LENGTH.  Longer tags make collisions (which are
+	// detected and retried) rarer on Backends with many tags
+	TagLength int `json:",omitempty"`
+}
+
+// HasSettings is implemented by Backends that have Settings.
+type HasSettings interface {
+	Settings() Settings
+}
+
+// GetSettings returns bk's Settings, or the default Settings if bk
+// doesn't have any.
+func GetSettings(bk Backend) Settings {
+	if hs, ok := bk.(HasSettings); ok {
+		return hs.Settings()
+	}
+	return Settings{}
+}
+
+// Valid returns an error if any of s's values are invalid.
+func (s Settings) Valid() error {
+	_, err := s.RowOptions()
+	return err
+}
+
+// RowOptions returns the types.RowOptions that s calls for.
+func (s Settings) RowOptions() (types.RowOptions, error) {
+	padding, err := types.ParsePadding(s.Padding)
+	if err != nil {
+		return types.RowOptions{}, err
+	}
+
+	if err = types.ValidCompression(s.Compression); err != nil {
+		return types.RowOptions{}, err
+	}
+
+	if _, err = cryptag.GetCipher(s.Cipher); err != nil {
+		return types.RowOptions{}, err
+	}
+
+	if s.TagMode != TagModeRandom && s.TagMode != TagModeHMAC {
+		return types.RowOptions{}, fmt.Errorf("Unknown tag mode `%s`", s.TagMode)
+	}
+
+	if s.MinTags < 0 || s.MinTags > maxMinTags {
+		return types.RowOptions{}, fmt.Errorf("MinTags must be between 0 and %d",
+			maxMinTags)
+	}
+
+	if s.TagLength != 0 && (s.TagLength < MinTagLength || s.TagLength > MaxTagLength) {
+		return types.RowOptions{}, fmt.Errorf("TagLength must be between %d and %d",
+			MinTagLength, MaxTagLength)
+	}
+
+	// Row filenames are made of their RandomTags
+	if s.MinTags*(s.RandomTagLength()+1) > maxRowFilenameLength {
+		return types.RowOptions{}, fmt.Errorf("MinTags of %d tags of length %d"+
+			" would make Row filenames too long", s.MinTags, s.RandomTagLength())
+	}
+
+	opts := types.RowOptions{
+		Padding:     padding,
+		Compression: s.Compression,
+		Cipher:      s.Cipher,
+	}
+	return opts, nil
+}
+
+// Set sets the setting called name (case-insensitive) to value.
+func (s *Settings) Set(name, value string) error {
+	updated := *s
+
+	switch strings.ToLower(name) {
+	case "padding":
+		updated.Padding = value
+	case "compression":
+		updated.Compression = value
+	case "cipher":
+		updated.Cipher = value
+	case "mintags":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid number `%s`: %v", value, err)
+		}
+		updated.MinTags = n
+	case "taglength":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid number `%s`: %v", value, err)
+		}
+		updated.TagLength = n
+	case "sign":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid boolean `%s`: %v", value, err)
+		}
+		updated.Sign = b
+	default:
+		return fmt.Errorf("Unknown setting `%s`", name)
+	}
+
+	if err := updated.Valid(); err != nil {
+		return err
+	}
+
+	*s = updated
+	return nil
+}
+
+// UpdateSetting sets bk's setting called name to value then saves
+// bk's Config.  Only affects data saved from then on.
+func UpdateSetting(bk Backend, name, value string) error {
+	cfg, err := bk.ToConfig()
+	if err != nil {
+		return err
+	}
+
+	if err = cfg.Settings.Set(name, value); err != nil {
+		return err
+	}
+
+	return cfg.Update(cryptag.BackendPath)
+}
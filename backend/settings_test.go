@@ -0,0 +1,31 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsRowFilenameLength(t *testing.T) {
+	tests := []struct {
+		settings Settings
+		valid    bool
+	}{
+		{Settings{MinTags: maxMinTags}, true},
+		{Settings{MinTags: 10, TagLength: 24}, true},
+		{Settings{MinTags: 11, TagLength: 24}, false},
+		{Settings{MinTags: 15, TagMode: TagModeHMAC}, true},
+
+		// TagLength is ignored in TagModeHMAC, but the length of blind
+		// tags still counts
+		{Settings{MinTags: 16, TagMode: TagModeHMAC}, false},
+		{Settings{MinTags: maxMinTags, TagMode: TagModeHMAC}, false},
+	}
+	for _, tt := range tests {
+		err := tt.settings.Valid()
+		assert.Equal(t, tt.valid, err == nil, "%+v: %v", tt.settings, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// createSignedRows creates a Row signed by this user with the data
+// "mine" and one signed by someone else with the data "theirs", both
+// tagged with plaintags.  Returns the other user's signing ID.
+func createSignedRows(t *testing.T, m *Memory, plaintags ...string) (theirSignerID string) {
+	mustCreateRow(t, m, nil, "mine", plaintags...)
+
+	row := mustCreateRow(t, m, nil, "theirs", plaintags...)
+	_, theirs, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Error generating signing key: %v", err)
+	}
+	row.Signer, row.Signature = nil, nil
+	if err = row.Sign(theirs); err != nil {
+		t.Fatalf("Error signing Row: %v", err)
+	}
+	if err = m.SaveRow(row); err != nil {
+		t.Fatalf("Error saving Row: %v", err)
+	}
+
+	return types.SignerID(theirs.Public().(ed25519.PublicKey))
+}
+
+// authors maps the data of each of rows to its verified author.
+func authors(t *testing.T, rows types.Rows) map[string]string {
+	authors := map[string]string{}
+	for _, row := range rows {
+		id, verified := row.Author()
+		assert.True(t, verified, "Row `%s` not verified", row.Decrypted())
+		authors[string(row.Decrypted())] = id
+	}
+	return authors
+}
+
+func TestRotateKeySignedRows(t *testing.T) {
+	self := useTestIdentity(t)
+	m := newTestMemory(t, Settings{Sign: true})
+
+	theirs := createSignedRows(t, m, "shared")
+	oldKeyID := cryptag.KeyID(m.Key())
+
+	err := RotateKey(m, nil)
+	if assert.IsType(t, &SignedByOthersError{}, err) {
+		assert.Len(t, err.(*SignedByOthersError).RandomTags, 1)
+	}
+	reloadConfig(t, m)
+
+	rows, err := RowsFromPlainTags(m, nil, []string{"shared"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]string{"mine": signerIDOf(self), "theirs": theirs},
+		authors(t, rows))
+
+	for _, row := range rows {
+		if string(row.Decrypted()) == "mine" {
+			assert.Equal(t, cryptag.KeyID(m.Key()), row.KeyID)
+		} else {
+			// Left as is
+			assert.Equal(t, oldKeyID, row.KeyID)
+		}
+	}
+}
+
+func TestMergeTagSignedRows(t *testing.T) {
+	self := useTestIdentity(t)
+	m := newTestMemory(t, Settings{Sign: true})
+
+	createSignedRows(t, m, "shared")
+	mustCreateRow(t, m, nil, "also mine", "solo")
+
+	// Refuses to re-tag any of the Rows
+	err := MergeTag(m, "shared", "common")
+	if assert.IsType(t, &SignedByOthersError{}, err) {
+		assert.Len(t, err.(*SignedByOthersError).RandomTags, 1)
+	}
+	rows, err := RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"mine", "theirs"}, decryptedData(rows))
+	}
+
+	// This user's own Rows are re-signed
+	if !assert.Nil(t, MergeTag(m, "solo", "single")) {
+		return
+	}
+	rows, err = RowsFromPlainTags(m, nil, []string{"single"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]string{"also mine": signerIDOf(self)},
+			authors(t, rows))
+	}
+}
+
+func TestCreateRowFromReaderSign(t *testing.T) {
+	useTestIdentity(t)
+
+	dir, err := ioutil.TempDir("", "cryptag-signed-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileSystem(&Config{Name: t.Name(), DataPath: path.Join(dir, "data"),
+		Settings: Settings{Sign: true}})
+	if err != nil {
+		t.Fatalf("Error creating FileSystem backend: %v", err)
+	}
+
+	// Not streamed, so that it can be signed
+	row, err := CreateRowFromReader(fs, nil, strings.NewReader("signed"),
+		[]string{"stream"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.False(t, row.Streamed)
+	assert.True(t, row.IsSigned())
+}
@@ -0,0 +1,196 @@
+// Steve Phillips / elimisteve
+// 2017.04.02
+
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+)
+
+var (
+	ErrStreamingNotSupported = errors.New("backend: Backend cannot store streamed rows")
+)
+
+// StreamBackend is implemented by Backends that can store a Row's
+// encrypted data as a stream rather than in row.Encrypted, so that
+// large Rows (e.g., multi-gigabyte files) never have to be held in
+// memory all at once.
+type StreamBackend interface {
+	Backend
+
+	// SaveRowStream saves row (which should have row.Streamed set)
+	// along with the encrypted stream read from encrypted.
+	SaveRowStream(row *types.Row, encrypted io.Reader) error
+
+	// RowStream returns the encrypted stream of data belonging to
+	// row, which must have been saved with SaveRowStream.
+	RowStream(row *types.Row) (io.ReadCloser, error)
+}
+
+// OpenRowStream returns an io.ReadCloser that reads and decrypts
+// row's streamed data from bk.
+func OpenRowStream(bk Backend, row *types.Row) (io.ReadCloser, error) {
+	if !row.Streamed {
+		return nil, types.ErrRowNotStreamed
+	}
+
+	sbk, ok := bk.(StreamBackend)
+	if !ok {
+		return nil, ErrStreamingNotSupported
+	}
+
+	rc, err := openRowStream(sbk, row, KeyRing(bk))
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure this data belongs to row before handing it over
+	if err = row.ReadStreamEnvelope(rc.br); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// openRowStream returns a bufferedReadCloser that reads and decrypts
+// row's streamed data using whichever key in ring it was encrypted
+// with.  The envelope at the front of the data is not removed.
+func openRowStream(sbk StreamBackend, row *types.Row, ring cryptag.KeyRing) (*bufferedReadCloser, error) {
+	keys, err := ring.Candidates(row.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		rc, err := sbk.RowStream(row)
+		if err != nil {
+			return nil, err
+		}
+
+		dec, err := cryptag.NewDecryptReader(rc, row.Nonce, key)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+
+		// Make sure the first chunk decrypts with this key
+		br := bufio.NewReader(dec)
+		_, err = br.Peek(1)
+		if err == nil || err == io.EOF {
+			return &bufferedReadCloser{br, rc}, nil
+		}
+
+		rc.Close()
+
+		if err != cryptag.ErrDecrypt {
+			return nil, err
+		}
+	}
+
+	return nil, cryptag.ErrDecrypt
+}
+
+type bufferedReadCloser struct {
+	br *bufio.Reader
+	io.Closer
+}
+
+func (brc *bufferedReadCloser) Read(p []byte) (int, error) {
+	return brc.br.Read(p)
+}
+
+// CreateRowFromReader creates a new Row containing the data read from
+// src, tagged with plaintags.  If bk is a StreamBackend, the data is
+// encrypted and saved as a stream; otherwise src is read into memory
+// and the Row is created with CreateRow.
+//
+// Streamed data is always encrypted in chunks (see
+// cryptag.EncryptStream) with bk.Key(), so the Cipher, Padding, and
+// Compression in bk's Settings aren't applied to it, and the server
+// storing it can see its exact length.  Streamed Rows can't be signed
+// either, so if bk's Settings call for signing (see Settings.Sign),
+// src is read into memory and the Row created with CreateRow instead.
+// Streamed Rows can't be sealed to recipients; use
+// CreateRowForRecipients for that.
+func CreateRowFromReader(bk Backend, pairs types.TagPairs, src io.Reader, plaintags []string) (*types.Row, error) {
+	return createRowFromReader(bk, pairs, src, plaintags, nil)
+}
+
+// createRowFromReader is CreateRowFromReader but only validates the
+// tags in plaintags that aren't in oldTags; see createRow.
+func createRowFromReader(bk Backend, pairs types.TagPairs, src io.Reader, plaintags, oldTags []string) (*types.Row, error) {
+	sbk, ok := bk.(StreamBackend)
+	if !ok || GetSettings(bk).Sign {
+		rowData, err := ioutil.ReadAll(src)
+		if err != nil {
+			return nil, err
+		}
+		return createRow(bk, pairs, rowData, plaintags, oldTags, nil)
+	}
+
+	if err := validateNewTags(plaintags, oldTags); err != nil {
+		return nil, err
+	}
+
+	row, err := types.NewRow(nil, plaintags)
+	if err != nil {
+		return nil, err
+	}
+	row.Streamed = true
+
+	pairs, err = tagPairsIfNeeded(bk, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = PopulateRowBeforeSave(bk, row, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		_, err := row.EncryptStream(pw, src, bk.Key())
+		pw.CloseWithError(err)
+	}()
+
+	err = sbk.SaveRowStream(row, pr)
+	if err != nil {
+		// Unblock the encrypting goroutine if it's still running
+		pr.CloseWithError(err)
+		return nil, err
+	}
+
+	return row, nil
+}
+
+// createFileRowStream creates a new Row from the contents of filename
+// without reading the entire file into memory at once.  Only the tags
+// in plaintags that aren't in oldTags are validated; see createRow.
+func createFileRowStream(bk Backend, pairs types.TagPairs, filename string, plaintags, oldTags []string) (*types.Row, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening file `%s`: %v", filename, err)
+	}
+	defer f.Close()
+
+	return createRowFromReader(bk, pairs, f, plaintags, oldTags)
+}
+
+// tempFileFor returns the name of a temporary file in the same
+// directory as filename, suitable for writing to then renaming.
+func tempFileFor(filename string) string {
+	return filepath.Join(filepath.Dir(filename),
+		"."+filepath.Base(filename)+".tmp-"+cryptag.NowStr())
+}
@@ -0,0 +1,430 @@
+// Steve Phillips / elimisteve
+// 2017.04.13
+
+package backend
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/types"
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/elimisteve/fun"
+)
+
+// A Backend's team is the list of users (identified by their miniLock
+// IDs) allowed to use it.  The team, along with the Backend's key
+// ring, is stored in a special Row sealed to every member (see
+// CreateRowForRecipients), so each member can fetch the Backend's
+// current key with their identity.  Removing a member re-keys the
+// Backend so that they can't read anything saved afterward.
+//
+// Since anyone can save a Row with the team's RandomTag, the team Row
+// is signed by the member who saved it, and each client only accepts
+// a team signed by one of the members it already trusts: those of
+// the last team it accepted (or saved), or one added with
+// TrustTeamSigner.
+
+// TeamRandomTag is the RandomTag of the Row storing a Backend's team.
+// It's fixed (and has no TagPair) so that members can find it without
+// already having the Backend's key.
+const TeamRandomTag = "cryptagteam"
+
+var (
+	ErrNoTeam            = errors.New("Backend has no team")
+	ErrNotTeamMember     = errors.New("No team member with that ID")
+	ErrAlreadyTeamMember = errors.New("Already a team member")
+	ErrRemoveSelf        = errors.New("Can't remove yourself from the team")
+
+	ErrTeamNotSigned       = errors.New("Team Row isn't signed")
+	ErrUntrustedTeamSigner = errors.New("Team Row wasn't signed by a trusted" +
+		" team member; if you trust them, add their signing ID with" +
+		" `cryptag team trust`")
+)
+
+// Team is the decrypted contents of a Backend's team Row.
+type Team struct {
+	Members []TeamMember `json:"members"`
+
+	// Backend's key ring, oldest first
+	Keys cryptag.KeyRing `json:"keys"`
+
+	// ID of the key that the Backend's blind index key is derived
+	// from (see Settings.TagKeyID); "" if it doesn't use blind tags
+	TagKeyID string `json:"tag_key_id,omitempty"`
+}
+
+type TeamMember struct {
+	ID       string `json:"id"`        // miniLock ID
+	SignerID string `json:"signer_id"` // See types.SignerID
+	Name     string `json:"name,omitempty"`
+}
+
+// Member returns the member of team with the miniLock ID id, or nil.
+func (team *Team) Member(id string) *TeamMember {
+	for i := range team.Members {
+		if team.Members[i].ID == id {
+			return &team.Members[i]
+		}
+	}
+	return nil
+}
+
+// signers returns the signing IDs of team's members.
+func (team *Team) signers() []string {
+	ids := make([]string, 0, len(team.Members))
+	for _, member := range team.Members {
+		ids = append(ids, member.SignerID)
+	}
+	return ids
+}
+
+// GetTeam fetches and decrypts bk's team, which must be sealed to
+// this user's identity (see UseIdentity) and signed by a team member
+// this user trusts (see TrustTeamSigner).  The team's members are
+// then trusted in turn, so that changes they make are accepted from
+// now on and those of removed members aren't.  Returns ErrNoTeam if
+// bk doesn't have a team.
+func GetTeam(bk Backend) (*Team, error) {
+	rows, err := bk.RowsFromRandomTags(cryptag.RandomTags{TeamRandomTag})
+	if err == types.ErrRowsNotFound {
+		return nil, ErrNoTeam
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNoTeam
+	}
+
+	row := rows[0]
+	if !row.HasRecipients() {
+		return nil, fmt.Errorf("Team Row isn't sealed to team members")
+	}
+	if err = row.Decrypt(nil); err != nil {
+		return nil, err
+	}
+	if err = row.Verify(); err != nil {
+		return nil, err
+	}
+
+	// Only take keys from a team that a trusted member saved
+	if !row.IsSigned() {
+		return nil, ErrTeamNotSigned
+	}
+	if err = row.VerifySignature(); err != nil {
+		return nil, err
+	}
+	trusted, err := trustedTeamSigners(bk.Name())
+	if err != nil {
+		return nil, err
+	}
+	signer, _ := row.Author()
+	if !fun.SliceContains(trusted, signer) {
+		return nil, ErrUntrustedTeamSigner
+	}
+
+	var team Team
+	if err = json.Unmarshal(row.Decrypted(), &team); err != nil {
+		return nil, fmt.Errorf("Error parsing team: %v", err)
+	}
+
+	if err = saveTrustedTeamSigners(bk.Name(), team.signers()); err != nil {
+		return nil, err
+	}
+
+	return &team, nil
+}
+
+// AddTeamMember adds the user with miniLock ID id and signing ID
+// signerID (both shown by `cryptag identity`) to bk's team, giving
+// them access to bk's key ring.  If bk has no team yet, one is
+// created with this user as its first member.
+func AddTeamMember(bk Backend, id, signerID, name string) error {
+	if _, err := ParseRecipients([]string{id}); err != nil {
+		return err
+	}
+	if err := checkSignerID(signerID); err != nil {
+		return err
+	}
+
+	team, err := GetTeam(bk)
+	if err == ErrNoTeam {
+		team, err = newTeam(bk)
+	}
+	if err != nil {
+		return err
+	}
+
+	if team.Member(id) != nil {
+		return ErrAlreadyTeamMember
+	}
+	team.Members = append(team.Members, TeamMember{ID: id, SignerID: signerID,
+		Name: name})
+
+	return saveTeam(bk, team)
+}
+
+// RemoveTeamMember removes the user with miniLock ID id from bk's
+// team, then re-keys bk: a new key is added to bk's key ring (and
+// saved to bk's Config) and only shared with the remaining members,
+// so that the removed member can't read anything saved from now on.
+// If bk uses blind tags, its blind index key is replaced, too, and
+// every TagPair and Row moved to its new RandomTags and re-encrypted
+// with the new key (see RekeyBlindTags), so that the removed member
+// can't tell which tags new Rows have.  Other members should then run
+// SyncTeamKeys.  (Otherwise, to also re-encrypt existing data under
+// the new key, use RotateKey.)
+//
+// If interrupted while moving Rows, or if they can't be moved because
+// some are sealed to or signed by other members (see
+// SealedToOthersError and SignedByOthersError), the member is still
+// removed; load bk again and call RekeyBlindTags to finish (or have
+// those members do so).
+func RemoveTeamMember(bk Backend, id string) error {
+	team, err := GetTeam(bk)
+	if err != nil {
+		return err
+	}
+
+	self, err := selfID()
+	if err != nil {
+		return err
+	}
+	if id == self {
+		return ErrRemoveSelf
+	}
+
+	var remaining []TeamMember
+	for _, member := range team.Members {
+		if member.ID != id {
+			remaining = append(remaining, member)
+		}
+	}
+	if len(remaining) == len(team.Members) {
+		return ErrNotTeamMember
+	}
+	team.Members = remaining
+
+	newKey, err := cryptag.RandomKey()
+	if err != nil {
+		return err
+	}
+	team.Keys = KeyRing(bk).Add(newKey)
+
+	// Don't lose the new key if interrupted
+	cfg, err := bk.ToConfig()
+	if err != nil {
+		return err
+	}
+	cfg.AddKey(newKey)
+
+	if err = cfg.Update(cryptag.BackendPath); err != nil {
+		return err
+	}
+
+	// Share the new key before using it
+	if err = saveTeam(bk, team); err != nil {
+		return err
+	}
+
+	// The old blind index key is derived from a key the removed
+	// member has
+	if UsesBlindTags(bk) {
+		return rekeyBlindTags(bk, newKey)
+	}
+
+	return nil
+}
+
+// SyncTeamKeys updates bk's Config with the key ring stored in bk's
+// team, such as after another member was removed and bk re-keyed.
+// Keys only in bk's local Config are kept.
+func SyncTeamKeys(bk Backend) error {
+	team, err := GetTeam(bk)
+	if err != nil {
+		return err
+	}
+	if len(team.Keys) == 0 {
+		return fmt.Errorf("Team has no keys")
+	}
+
+	cfg, err := bk.ToConfig()
+	if err != nil {
+		return err
+	}
+
+	ring := cfg.KeyRing()
+	for _, key := range team.Keys {
+		ring = ring.Add(key)
+	}
+	// Ensure the team's newest key ends up newest
+	ring = ring.Add(team.Keys.Newest())
+
+	cfg.Key = ring.Newest()
+	cfg.OldKeys = ring[:len(ring)-1]
+
+	if team.TagKeyID != "" {
+		cfg.TagMode = TagModeHMAC
+		cfg.TagKeyID = team.TagKeyID
+	}
+
+	return cfg.Update(cryptag.BackendPath)
+}
+
+// TrustTeamSigner trusts the team member with signing ID signerID
+// (shown by `cryptag identity`) to save bk's team, such as the member
+// who added this user to it.
+func TrustTeamSigner(bk Backend, signerID string) error {
+	if err := checkSignerID(signerID); err != nil {
+		return err
+	}
+
+	trusted, err := trustedTeamSigners(bk.Name())
+	if err != nil {
+		return err
+	}
+	if fun.SliceContains(trusted, signerID) {
+		return nil
+	}
+
+	return saveTrustedTeamSigners(bk.Name(), append(trusted, signerID))
+}
+
+func newTeam(bk Backend) (*Team, error) {
+	keys, err := LoadIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("Error loading identity: %v", err)
+	}
+	self, err := keys.EncodeID()
+	if err != nil {
+		return nil, err
+	}
+
+	team := &Team{
+		Members:  []TeamMember{{ID: self, SignerID: signerIDOf(keys)}},
+		Keys:     KeyRing(bk),
+		TagKeyID: GetSettings(bk).TagKeyID,
+	}
+	return team, nil
+}
+
+// selfID returns the miniLock ID of this user's identity.
+func selfID() (string, error) {
+	keys, err := LoadIdentity()
+	if err != nil {
+		return "", fmt.Errorf("Error loading identity: %v", err)
+	}
+	return keys.EncodeID()
+}
+
+func signerIDOf(keys *taber.Keys) string {
+	return types.SignerID(SigningKey(keys).Public().(ed25519.PublicKey))
+}
+
+func checkSignerID(signerID string) error {
+	pub, err := base64.RawURLEncoding.DecodeString(signerID)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("Invalid signing ID `%s`", signerID)
+	}
+	return nil
+}
+
+// saveTeam seals team to its members, signs it with this user's
+// identity, and saves it to bk, replacing bk's existing team Row, if
+// any.  team's members are then trusted (see GetTeam).
+func saveTeam(bk Backend, team *Team) error {
+	keys, err := LoadIdentity()
+	if err != nil {
+		return fmt.Errorf("Error loading identity: %v", err)
+	}
+
+	ids := make([]string, len(team.Members))
+	for i, member := range team.Members {
+		ids[i] = member.ID
+	}
+
+	recipients, err := ParseRecipients(ids)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(team)
+	if err != nil {
+		return err
+	}
+
+	row, err := types.NewRowSimple(b, nil)
+	if err != nil {
+		return err
+	}
+	row.RandomTags = cryptag.RandomTags{TeamRandomTag}
+
+	opts, err := GetSettings(bk).RowOptions()
+	if err != nil {
+		return err
+	}
+	opts.Recipients = recipients
+
+	// Key unused since row is sealed to recipients
+	if err = row.EncryptWithOptions(nil, opts); err != nil {
+		return err
+	}
+
+	if err = row.Sign(SigningKey(keys)); err != nil {
+		return err
+	}
+
+	if err = bk.SaveRow(row); err != nil {
+		return err
+	}
+
+	return saveTrustedTeamSigners(bk.Name(), team.signers())
+}
+
+//
+// Trusted team members
+//
+
+func trustedTeamSignersFile(bkName string) string {
+	return path.Join(cryptag.TrustedBasePath, "teams", bkName+".json")
+}
+
+// trustedTeamSigners returns the signing IDs of the members of the
+// Backend called bkName's team that this user trusts.
+func trustedTeamSigners(bkName string) ([]string, error) {
+	b, err := ioutil.ReadFile(trustedTeamSignersFile(bkName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var signers []string
+	if err = json.Unmarshal(b, &signers); err != nil {
+		return nil, fmt.Errorf("Error parsing trusted team members: %v", err)
+	}
+	return signers, nil
+}
+
+func saveTrustedTeamSigners(bkName string, signers []string) error {
+	b, err := json.Marshal(signers)
+	if err != nil {
+		return err
+	}
+
+	filename := trustedTeamSignersFile(bkName)
+	if err = os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, b, 0600)
+}
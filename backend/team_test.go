@@ -0,0 +1,199 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package backend
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestIdentity creates (once) and loads this user's identity.
+func useTestIdentity(t *testing.T) *taber.Keys {
+	keys, err := LoadIdentity()
+	if os.IsNotExist(err) {
+		keys, err = CreateIdentity()
+	}
+	if err != nil {
+		t.Fatalf("Error creating identity: %v", err)
+	}
+	if err = UseIdentity(); err != nil {
+		t.Fatalf("Error using identity: %v", err)
+	}
+	return keys
+}
+
+// newTestMember returns the miniLock ID and signing ID of a new,
+// random user.
+func newTestMember(t *testing.T) (id, signerID string) {
+	keys, err := taber.RandomKey()
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	if id, err = keys.EncodeID(); err != nil {
+		t.Fatalf("Error encoding miniLock ID: %v", err)
+	}
+	return id, signerIDOf(keys)
+}
+
+func TestAddTeamMember(t *testing.T) {
+	self := useTestIdentity(t)
+	m := newTestMemory(t, Settings{})
+
+	_, err := GetTeam(m)
+	assert.Equal(t, ErrNoTeam, err)
+
+	id, signerID := newTestMember(t)
+
+	assert.NotNil(t, AddTeamMember(m, id, "nope", "bob"))
+	if !assert.Nil(t, AddTeamMember(m, id, signerID, "bob")) {
+		return
+	}
+	assert.Equal(t, ErrAlreadyTeamMember, AddTeamMember(m, id, signerID, "bob"))
+
+	team, err := GetTeam(m)
+	if !assert.Nil(t, err) {
+		return
+	}
+	selfID, _ := self.EncodeID()
+	assert.Equal(t, []TeamMember{
+		{ID: selfID, SignerID: signerIDOf(self)},
+		{ID: id, SignerID: signerID, Name: "bob"},
+	}, team.Members)
+	assert.Equal(t, KeyRing(m), team.Keys)
+}
+
+func TestGetTeamTrust(t *testing.T) {
+	self := useTestIdentity(t)
+	m := newTestMemory(t, Settings{})
+
+	id, signerID := newTestMember(t)
+	if !assert.Nil(t, AddTeamMember(m, id, signerID, "")) {
+		return
+	}
+
+	// Only trusting someone else, as if just added by them
+	err := saveTrustedTeamSigners(m.Name(), []string{signerID})
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = GetTeam(m)
+	assert.Equal(t, ErrUntrustedTeamSigner, err)
+
+	assert.Nil(t, TrustTeamSigner(m, signerIDOf(self)))
+	_, err = GetTeam(m)
+	assert.Nil(t, err)
+
+	// Unsigned teams, which anyone could have saved, aren't accepted
+	rows, err := m.RowsFromRandomTags(cryptag.RandomTags{TeamRandomTag})
+	if !assert.Nil(t, err) {
+		return
+	}
+	rows[0].Signer, rows[0].Signature = nil, nil
+	if !assert.Nil(t, m.SaveRow(rows[0])) {
+		return
+	}
+	_, err = GetTeam(m)
+	assert.Equal(t, ErrTeamNotSigned, err)
+}
+
+func TestRemoveTeamMember(t *testing.T) {
+	self := useTestIdentity(t)
+	m := newTestMemory(t, Settings{})
+
+	mustCreateRow(t, m, nil, "before", "shared")
+	if !assert.Nil(t, MigrateToBlindTags(m)) {
+		return
+	}
+	reloadConfig(t, m)
+
+	id, signerID := newTestMember(t)
+	if !assert.Nil(t, AddTeamMember(m, id, signerID, "")) {
+		return
+	}
+
+	selfID, _ := self.EncodeID()
+	assert.Equal(t, ErrRemoveSelf, RemoveTeamMember(m, selfID))
+
+	// Keep a copy of the Config as it was before, as another member's
+	oldKey, oldTagKeyID := m.key, m.settings.TagKeyID
+	stale, err := m.ToConfig()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if !assert.Nil(t, RemoveTeamMember(m, id)) {
+		return
+	}
+	assert.Equal(t, ErrNotTeamMember, RemoveTeamMember(m, id))
+
+	reloadConfig(t, m)
+	assert.NotEqual(t, *oldKey, *m.key)
+	assert.Equal(t, cryptag.KeyID(m.key), m.settings.TagKeyID)
+	assert.NotEqual(t, oldTagKeyID, m.settings.TagKeyID)
+
+	team, err := GetTeam(m)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, team.Members, 1)
+	assert.Equal(t, m.settings.TagKeyID, team.TagKeyID)
+
+	// Rows were moved to the new blind index
+	rows, err := RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"before"}, decryptedData(rows))
+	}
+
+	// ...and neither they nor their TagPairs can be read with the key
+	// the removed member has
+	assertUnreadableWithKey(t, m, oldKey)
+
+	// The other members then sync
+	newKey, newTagKeyID := m.key, m.settings.TagKeyID
+	if !assert.Nil(t, stale.Update(cryptag.BackendPath)) {
+		return
+	}
+	reloadConfig(t, m)
+	assert.Equal(t, oldTagKeyID, m.settings.TagKeyID)
+
+	if !assert.Nil(t, SyncTeamKeys(m)) {
+		return
+	}
+	reloadConfig(t, m)
+	assert.Equal(t, *newKey, *m.key)
+	assert.Equal(t, newTagKeyID, m.settings.TagKeyID)
+
+	rows, err = RowsFromPlainTags(m, nil, []string{"shared"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"before"}, decryptedData(rows))
+	}
+}
+
+// assertUnreadableWithKey asserts that none of m's TagPairs or Rows
+// (other than those sealed to recipients) can be decrypted with key.
+func assertUnreadableWithKey(t *testing.T, m *Memory, key *[32]byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ring := cryptag.KeyRing{key}
+
+	for _, stored := range m.pairs {
+		pair := copyTagPair(stored)
+		assert.NotEqual(t, cryptag.KeyID(key), pair.KeyID, pair.Random)
+		assert.NotNil(t, pair.DecryptWithKeyRing(ring), pair.Random)
+	}
+
+	for _, stored := range m.rows {
+		row := copyRow(stored, true)
+		if row.HasRecipients() {
+			continue
+		}
+		assert.NotEqual(t, cryptag.KeyID(key), row.KeyID, "%v", row.RandomTags)
+		assert.NotNil(t, row.Decrypt(key), "%v", row.RandomTags)
+	}
+}
@@ -13,6 +13,7 @@ const (
 	TypeFileSystem    = "filesystem"
 	TypeWebserver     = "webserver"
 	TypeSandstorm     = "sandstorm" // Uses webserver + WebserverBackend code
+	TypeMemory        = "memory"    // See Memory
 )
 
 var (
@@ -38,7 +38,9 @@ type WebserverBackend struct {
 
 	authToken string
 
-	key *[32]byte
+	key      *[32]byte
+	oldKeys  []*[32]byte
+	settings Settings
 }
 
 func NewWebserverBackend(key []byte, serverName, serverBaseUrl, authToken string) (*WebserverBackend, error) {
@@ -115,8 +117,15 @@ func WebserverFromConfig(conf *Config) (*WebserverBackend, error) {
 		return nil, err
 	}
 
-	return NewWebserverBackend((*conf.Key)[:], conf.Name, webConf.BaseURL,
+	wb, err := NewWebserverBackend((*conf.Key)[:], conf.Name, webConf.BaseURL,
 		webConf.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+	wb.oldKeys = conf.OldKeys
+	wb.settings = conf.Settings
+
+	return wb, nil
 }
 
 func (wb *WebserverBackend) ToConfig() (*Config, error) {
@@ -124,9 +133,11 @@ func (wb *WebserverBackend) ToConfig() (*Config, error) {
 		return nil, cryptag.ErrNilKey
 	}
 	c := Config{
-		Name: wb.serverName,
-		Type: wb.bkType,
-		Key:  wb.key,
+		Name:     wb.serverName,
+		Type:     wb.bkType,
+		Key:      wb.key,
+		OldKeys:  wb.oldKeys,
+		Settings: wb.settings,
 	}
 
 	if wb.bkType == TypeWebserver {
@@ -177,6 +188,14 @@ func (wb *WebserverBackend) Key() *[32]byte {
 	return wb.key
 }
 
+func (wb *WebserverBackend) KeyRing() cryptag.KeyRing {
+	return append(append(cryptag.KeyRing{}, wb.oldKeys...), wb.key)
+}
+
+func (wb *WebserverBackend) Settings() Settings {
+	return wb.settings
+}
+
 func (wb *WebserverBackend) AllTagPairs(oldPairs types.TagPairs) (types.TagPairs, error) {
 	pairs, err := wb.getTagsFromUrl(wb.tagsUrl)
 	if err != nil {
@@ -189,6 +208,9 @@ func (wb *WebserverBackend) SaveRow(row *types.Row) error {
 	if len(row.Encrypted) == 0 || len(row.RandomTags) == 0 || row.Nonce == nil || *row.Nonce == [24]byte{} {
 		return errors.New("Invalid row; requires Encrypted, RandomTags, Nonce fields")
 	}
+	if err := validRandomTags(row.RandomTags); err != nil {
+		return err
+	}
 
 	rowBytes, err := json.Marshal(row)
 	if err != nil {
@@ -218,6 +240,10 @@ func (wb *WebserverBackend) SaveRow(row *types.Row) error {
 }
 
 func (wb *WebserverBackend) SaveTagPair(pair *types.TagPair) error {
+	if err := ValidRandomTag(pair.Random); err != nil {
+		return err
+	}
+
 	pairBytes, err := json.Marshal(pair)
 	if err != nil {
 		return err
@@ -260,6 +286,23 @@ func (wb *WebserverBackend) TagPairsFromRandomTags(randtags cryptag.RandomTags)
 	return wb.getTagsFromUrl(url)
 }
 
+func (wb *WebserverBackend) DeleteTagPairs(randtags cryptag.RandomTags) error {
+	fullURL := wb.tagsUrl + "/delete?tags=" + strings.Join(randtags, ",")
+	resp, err := wb.get(fullURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Error deleting tags; got status code %d and body `%s`",
+			resp.StatusCode, body)
+	}
+
+	return nil
+}
+
 func (wb *WebserverBackend) ListRows(randtags cryptag.RandomTags) (types.Rows, error) {
 	fullURL := wb.rowsUrl + "/list?tags=" + strings.Join(randtags, ",")
 	return wb.getRowsFromUrl(fullURL)
@@ -270,6 +313,26 @@ func (wb *WebserverBackend) RowsFromRandomTags(randtags cryptag.RandomTags) (typ
 	return wb.getRowsFromUrl(fullURL)
 }
 
+// ListRowsPage is like ListRows but only returns the Rows that opts
+// selects; the server does the paging.
+func (wb *WebserverBackend) ListRowsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error) {
+	fullURL := wb.rowsUrl + "/list?tags=" + strings.Join(randtags, ",")
+	return wb.getRowsFromUrl(withPageOptions(fullURL, opts))
+}
+
+// RowsFromRandomTagsPage is like RowsFromRandomTags but only returns
+// the Rows that opts selects; the server does the paging.
+func (wb *WebserverBackend) RowsFromRandomTagsPage(randtags cryptag.RandomTags, opts types.PageOptions) (types.Rows, error) {
+	fullURL := wb.rowsUrl + "?tags=" + strings.Join(randtags, ",")
+	return wb.getRowsFromUrl(withPageOptions(fullURL, opts))
+}
+
+// RowsByIDs fetches the Rows having any of idRandTags in one request.
+func (wb *WebserverBackend) RowsByIDs(idRandTags cryptag.RandomTags) (types.Rows, error) {
+	fullURL := wb.rowsUrl + "/ids?ids=" + strings.Join(idRandTags, ",")
+	return wb.getRowsFromUrl(fullURL)
+}
+
 func (wb *WebserverBackend) DeleteRows(randtags cryptag.RandomTags) error {
 	fullURL := wb.rowsUrl + "/delete?tags=" + strings.Join(randtags, ",")
 	resp, err := wb.get(fullURL)
@@ -291,6 +354,15 @@ func (wb *WebserverBackend) DeleteRows(randtags cryptag.RandomTags) error {
 // Helper Methods
 //
 
+// withPageOptions adds opts to url, which must already have a query
+// string, as query parameters.
+func withPageOptions(url string, opts types.PageOptions) string {
+	if params := opts.Values().Encode(); params != "" {
+		url += "&" + params
+	}
+	return url
+}
+
 // getRowsFromUrl fetches the encrypted rows from url. Does not
 // decrypt and populate them.
 func (wb *WebserverBackend) getRowsFromUrl(url string) (types.Rows, error) {
@@ -326,14 +398,16 @@ func (wb *WebserverBackend) getTagsFromUrl(url string) (types.TagPairs, error) {
 		return nil, fmt.Errorf("Error fetching pairs: %v", err)
 	}
 
+	ring := wb.KeyRing()
+
 	wg := &sync.WaitGroup{}
 	wg.Add(len(pairs))
 
 	for _, pair := range pairs {
 		go func(pair *types.TagPair) {
 			// TODO: Return first error
-			if err = pair.Decrypt(wb.key); err != nil {
-				log.Printf("Error from pair.Decrypt: %v", err)
+			if err = pair.DecryptWithKeyRing(ring); err != nil {
+				log.Printf("Error from pair.DecryptWithKeyRing: %v", err)
 			}
 			wg.Done()
 		}(pair)
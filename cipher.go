@@ -0,0 +1,237 @@
+// Steve Phillips / elimisteve
+// 2017.04.09
+
+package cryptag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Names of the built-in Ciphers
+const (
+	CipherSecretbox         = "secretbox"
+	CipherXChaCha20Poly1305 = "xchacha20poly1305"
+)
+
+// Data encrypted with EncryptVersioned starts with this header, which
+// says which Cipher sealed it, so that algorithms can be added (and
+// the default changed) without breaking existing data:
+//
+//	magic (4 bytes) | format version (1) | cipher ID (1) | ciphertext
+//
+// Legacy data has no header and was sealed with secretbox.
+const (
+	formatVersion   = 1
+	formatHeaderLen = 4 + 1 + 1
+)
+
+var formatMagic = []byte("\x00CTC")
+
+// DefaultCipher is the name of the Cipher EncryptVersioned uses.
+var DefaultCipher = CipherSecretbox
+
+var (
+	ErrUnknownCipher    = errors.New("Unknown cipher")
+	ErrCipherRegistered = errors.New("Cipher with that name or ID already registered")
+)
+
+// Cipher is an authenticated encryption algorithm that uses 32-byte
+// keys and 24-byte nonces.
+type Cipher interface {
+	// Name is how users refer to this Cipher, e.g. in Backend
+	// configs
+	Name() string
+
+	// ID is stored in the header of everything this Cipher
+	// encrypts; it must never change
+	ID() byte
+
+	Seal(plain []byte, nonce *[24]byte, key *[32]byte) ([]byte, error)
+	Open(cipher []byte, nonce *[24]byte, key *[32]byte) ([]byte, error)
+}
+
+var ciphers = struct {
+	mu     sync.RWMutex
+	byName map[string]Cipher
+	byID   map[byte]Cipher
+}{
+	byName: map[string]Cipher{},
+	byID:   map[byte]Cipher{},
+}
+
+func init() {
+	RegisterCipher(secretboxCipher{})
+	RegisterCipher(xchachaCipher{})
+}
+
+// RegisterCipher makes c available to EncryptWithCipher and
+// DecryptVersioned.
+func RegisterCipher(c Cipher) error {
+	ciphers.mu.Lock()
+	defer ciphers.mu.Unlock()
+
+	if _, exists := ciphers.byName[c.Name()]; exists {
+		return ErrCipherRegistered
+	}
+	if _, exists := ciphers.byID[c.ID()]; exists {
+		return ErrCipherRegistered
+	}
+
+	ciphers.byName[c.Name()] = c
+	ciphers.byID[c.ID()] = c
+
+	return nil
+}
+
+// GetCipher returns the registered Cipher called name, or the
+// DefaultCipher if name is empty.
+func GetCipher(name string) (Cipher, error) {
+	if name == "" {
+		name = DefaultCipher
+	}
+
+	ciphers.mu.RLock()
+	defer ciphers.mu.RUnlock()
+
+	c, ok := ciphers.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%v `%s`", ErrUnknownCipher, name)
+	}
+	return c, nil
+}
+
+func cipherByID(id byte) Cipher {
+	ciphers.mu.RLock()
+	defer ciphers.mu.RUnlock()
+
+	return ciphers.byID[id]
+}
+
+// EncryptVersioned encrypts plain with the DefaultCipher and prepends
+// a format header; see EncryptWithCipher.
+func EncryptVersioned(plain []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	return EncryptWithCipher("", plain, nonce, key)
+}
+
+// EncryptWithCipher encrypts plain with the Cipher called cipherName
+// (or the DefaultCipher if cipherName is empty) and prepends a header
+// saying which Cipher was used.  Decrypt the result with
+// DecryptVersioned.
+func EncryptWithCipher(cipherName string, plain []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	c, err := GetCipher(cipherName)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := c.Seal(plain, nonce, key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, formatHeaderLen+len(sealed))
+	out = append(out, formatMagic...)
+	out = append(out, formatVersion, c.ID())
+	out = append(out, sealed...)
+
+	return out, nil
+}
+
+// DecryptVersioned decrypts data encrypted by EncryptWithCipher, as
+// well as legacy, headerless data encrypted by Encrypt.
+func DecryptVersioned(cipher []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	if !bytes.HasPrefix(cipher, formatMagic) || len(cipher) < formatHeaderLen {
+		return Decrypt(cipher, nonce, key)
+	}
+
+	version, id := cipher[4], cipher[5]
+
+	c := cipherByID(id)
+	if version != formatVersion || c == nil {
+		// Could be legacy data that happens to start with
+		// formatMagic
+		plain, err := Decrypt(cipher, nonce, key)
+		if err != nil {
+			return nil, fmt.Errorf("Unsupported format version %d or"+
+				" cipher ID %d", version, id)
+		}
+		return plain, nil
+	}
+
+	plain, err := c.Open(cipher[formatHeaderLen:], nonce, key)
+	if err != nil {
+		if legacy, lerr := Decrypt(cipher, nonce, key); lerr == nil {
+			return legacy, nil
+		}
+		return nil, err
+	}
+
+	return plain, nil
+}
+
+//
+// Built-in Ciphers
+//
+
+// secretboxCipher is NaCl's secretbox (XSalsa20-Poly1305), what
+// Encrypt and Decrypt use.
+type secretboxCipher struct{}
+
+func (secretboxCipher) Name() string { return CipherSecretbox }
+func (secretboxCipher) ID() byte     { return 1 }
+
+func (secretboxCipher) Seal(plain []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	return Encrypt(plain, nonce, key)
+}
+
+func (secretboxCipher) Open(cipher []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	return Decrypt(cipher, nonce, key)
+}
+
+type xchachaCipher struct{}
+
+func (xchachaCipher) Name() string { return CipherXChaCha20Poly1305 }
+func (xchachaCipher) ID() byte     { return 2 }
+
+func (xchachaCipher) Seal(plain []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	if nonce == nil {
+		return nil, ErrNilNonce
+	}
+	if key == nil {
+		return nil, ErrNilKey
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nil, nonce[:], plain, nil), nil
+}
+
+func (xchachaCipher) Open(cipher []byte, nonce *[24]byte, key *[32]byte) ([]byte, error) {
+	if nonce == nil {
+		return nil, ErrNilNonce
+	}
+	if key == nil {
+		return nil, ErrNilKey
+	}
+	if len(cipher) == 0 {
+		return nil, ErrDecryptEmpty
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, nonce[:], cipher, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
@@ -0,0 +1,60 @@
+// Steve Phillips / elimisteve
+// 2017.04.09
+
+package cryptag
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptWithCipher(t *testing.T) {
+	plain := []byte("Original plaintext to be encrypted then decrypted")
+	key, _ := RandomKey()
+
+	for _, name := range []string{"", CipherSecretbox, CipherXChaCha20Poly1305} {
+		nonce, _ := RandomNonce()
+
+		enc, err := EncryptWithCipher(name, plain, nonce, key)
+		if err != nil {
+			t.Fatalf("Error encrypting with cipher `%s`: %v", name, err)
+		}
+
+		dec, err := DecryptVersioned(enc, nonce, key)
+		if err != nil {
+			t.Fatalf("Error decrypting with cipher `%s`: %v", name, err)
+		}
+		assert.Equal(t, plain, dec)
+
+		// Tampering is detected
+		enc[len(enc)-1] ^= 1
+		_, err = DecryptVersioned(enc, nonce, key)
+		assert.NotNil(t, err, "Tampered ciphertext decrypted without error")
+	}
+}
+
+func TestDecryptVersionedLegacy(t *testing.T) {
+	plain := []byte("Headerless data from before ciphers were pluggable")
+	nonce, _ := RandomNonce()
+	key, _ := RandomKey()
+
+	enc, err := Encrypt(plain, nonce, key)
+	if err != nil {
+		t.Fatalf("Error encrypting: %v", err)
+	}
+
+	dec, err := DecryptVersioned(enc, nonce, key)
+	if err != nil {
+		t.Fatalf("Error decrypting legacy data: %v", err)
+	}
+	assert.Equal(t, plain, dec)
+}
+
+func TestGetCipherUnknown(t *testing.T) {
+	_, err := GetCipher("rot13")
+	assert.NotNil(t, err)
+
+	err = RegisterCipher(secretboxCipher{})
+	assert.Equal(t, ErrCipherRegistered, err)
+}
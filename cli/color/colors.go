@@ -48,3 +48,16 @@ func TextRows(rows types.Rows) string {
 func TextAndTags(text string, tags []string) string {
 	return BlackOnCyan(text) + "    " + Tags(tags)
 }
+
+// Author returns a colorized description of who signed r, if anyone,
+// and whether their signature was verified.
+func Author(r *types.Row) string {
+	id, verified := r.Author()
+	switch {
+	case id == "":
+		return BlackOnWhite("unverified (unsigned)")
+	case !verified:
+		return BlackOnWhite("unverified (signed by " + id + ")")
+	}
+	return BlackOnCyan("verified") + " signed by " + id
+}
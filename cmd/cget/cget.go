@@ -15,6 +15,7 @@ import (
 	"github.com/cryptag/cryptag/cli"
 	"github.com/cryptag/cryptag/cli/color"
 	"github.com/cryptag/cryptag/rowutil"
+	"github.com/cryptag/cryptag/types"
 )
 
 var (
@@ -78,7 +79,7 @@ func main() {
 
 		for _, r := range rows {
 			dir := path.Join(cryptag.TrustedBasePath, "decrypted")
-			if _, err = rowutil.SaveAsFile(r, dir); err != nil {
+			if _, err = saveAsFile(r, dir); err != nil {
 				log.Printf("Error locally saving file: %v\n", err)
 				continue
 			}
@@ -88,6 +89,22 @@ func main() {
 	}
 }
 
+// saveAsFile saves r to dir, decrypting it from db as it's written
+// if r's data is streamed.
+func saveAsFile(r *types.Row, dir string) (string, error) {
+	if !r.Streamed {
+		return rowutil.SaveAsFile(r, dir)
+	}
+
+	rc, err := backend.OpenRowStream(db, r)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	return rowutil.SaveReaderAsFile(r, dir, rc)
+}
+
 var (
 	prefix = "Usage: " + filepath.Base(os.Args[0]) + " "
 
@@ -50,6 +50,11 @@ func main() {
 				log.Fatalf("Error trying to use Tor: %v\n", err)
 			}
 		}
+
+		// For signing messages (see `cryptag setting sign true`)
+		if err = backend.UseIdentity(); err != nil {
+			log.Printf("Error loading identity: %v\n", err)
+		}
 	}
 
 	switch os.Args[1] {
@@ -252,10 +257,14 @@ func fmtMsg(r *types.Row) string {
 		to = color.BlackOnCyan("To") + ": " + to + "\n"
 	}
 
+	// Anyone with the room's key can claim to be anyone in the
+	// "from:" tag; only a verified signature says who sent it
 	return fmt.Sprintf(`%s%s: %s
+%s: %s
 %s`,
 		to,
 		color.BlackOnCyan(from), msg.Msg,
+		color.BlackOnCyan("Author"), color.Author(r),
 		color.Tags(r.PlainTags()))
 }
 
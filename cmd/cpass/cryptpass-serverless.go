@@ -18,6 +18,7 @@ import (
 	"github.com/cryptag/cryptag/exporter"
 	"github.com/cryptag/cryptag/importer"
 	"github.com/cryptag/cryptag/rowutil"
+	"github.com/cryptag/cryptag/tagschema"
 	"github.com/elimisteve/clipboard"
 	shellwords "github.com/mattn/go-shellwords"
 	"github.com/qpliu/qrencode-go/qrencode"
@@ -107,7 +108,7 @@ func main() {
 			log.Fatal(err)
 		}
 
-		rows.Sort(rowutil.ByTagPrefix("created:", true))
+		rows.Sort(rowutil.ByCreated(true))
 
 		dec := rows[0].Decrypted()
 
@@ -169,7 +170,7 @@ func main() {
 			}
 
 			log.Printf("Successfully imported password for site %s\n",
-				rowutil.TagWithPrefixStripped(row, "url:"))
+				tagschema.Value(row.PlainTags(), "url"))
 		}
 
 	case "export":
@@ -209,13 +210,22 @@ func main() {
 		// Empty clipboard
 		clipboard.WriteAll(nil)
 
-		plaintags := append(args, "type:text")
-		rows, err := backend.RowsFromPlainTags(db, nil, plaintags)
+		// Tags, unless explicitly a query
+		query := backend.TagsQuery(append(args, "type:text"))
+		if len(args) > 0 && (args[0] == "-q" || args[0] == "--query") {
+			userQuery, err := backend.ParseQuery(strings.Join(args[1:], " "))
+			if err != nil {
+				log.Fatal(err)
+			}
+			query = userQuery.And(backend.TagsQuery([]string{"type:text"}))
+		}
+
+		rows, err := backend.RowsFromQuery(db, nil, query)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		rows.Sort(rowutil.ByTagPrefix("created:", true))
+		rows.Sort(rowutil.ByCreated(true))
 
 		// Add first row's contents to clipboard
 		dec := rows[0].Decrypted()
@@ -268,7 +278,7 @@ var (
 	importUsage = prefix + "import <exported-from-keepassx.csv> [<tag1> ...]"
 	exportUsage = prefix + "export lastpass <lastpass.csv> <tag1> [<tag2> ...]"
 	qrUsage     = prefix + "qr     <tag1> [<tag2> ...]"
-	searchUsage = prefix + "<tag1> [<tag2> ...]"
+	searchUsage = prefix + "<tag1> [<tag2> ...] | --query <query>   (e.g., --query '(github OR gitlab) -archived')"
 
 	allUsage = strings.Join([]string{createUsage, tagsUsage, deleteUsage, runUsage, importUsage, exportUsage,
 		qrUsage, searchUsage}, "\n")
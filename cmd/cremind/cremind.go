@@ -14,6 +14,7 @@ import (
 	"github.com/cryptag/cryptag/backend"
 	"github.com/cryptag/cryptag/cli/color"
 	"github.com/cryptag/cryptag/rowutil"
+	"github.com/cryptag/cryptag/tagschema"
 	"github.com/cryptag/cryptag/types"
 )
 
@@ -52,7 +53,7 @@ func main() {
 		}
 
 		todo := os.Args[3]
-		tags := append(os.Args[4:], "when:"+when, "app:cremind",
+		tags := append(os.Args[4:], tagschema.New("when", when), "app:cremind",
 			"type:calendarevent", "type:text")
 
 		row, err := backend.CreateRow(db, nil, []byte(todo), tags)
@@ -78,8 +79,13 @@ func main() {
 	default: // Search
 		tags := os.Args[1:]
 
-		if tags[0] == "today" {
+		switch tags[0] {
+		case "today":
 			tags[0] = "when:" + fmtDate(time.Now())
+		case "thismonth":
+			tags[0] = "when:" + fmtDate(time.Now())[:6] + "*"
+		case "upcoming":
+			tags[0] = "when:>=" + fmtDate(time.Now())
 		}
 
 		plaintags := append(tags, "type:calendarevent")
@@ -98,16 +104,12 @@ func main() {
 	}
 }
 
-var (
-	timeLayout = "20060102"
-)
-
 func fmtReminder(r *types.Row) string {
-	whenStr := rowutil.TagWithPrefixStripped(r, "when:")
-	when, err := time.Parse(timeLayout, whenStr)
+	whenTag, _ := tagschema.Find(r.PlainTags(), "when")
+	whenStr := whenTag.Value
+	when, err := whenTag.Time()
 	if err != nil {
-		log.Printf("Error parsing timestamp `%v` as format `%v`: %v\n", whenStr,
-			timeLayout, err)
+		log.Printf("Error parsing date of reminder: %v\n", err)
 	}
 
 	// Indicate whether this event is planned for today
@@ -129,8 +131,7 @@ func isToday(day time.Time) bool {
 }
 
 func fmtDate(t time.Time) string {
-	y, m, d := t.Date()
-	return fmt.Sprintf("%d%02d%02d", y, m, d)
+	return tagschema.FormatDate(t)
 }
 
 func parseDate(dateOrig string) (string, error) {
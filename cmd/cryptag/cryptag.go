@@ -4,11 +4,13 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/cryptag/cryptag"
@@ -18,6 +20,7 @@ import (
 	"github.com/cryptag/cryptag/keyutil"
 	"github.com/cryptag/cryptag/rowutil"
 	"github.com/cryptag/cryptag/share"
+	"github.com/cryptag/cryptag/types"
 )
 
 var (
@@ -58,7 +61,7 @@ func main() {
 	}
 
 	if !containsAny(osArgs[1], "init", "listbackends", "lb",
-		"setdefaultbackend", "sdb", "invite") {
+		"setdefaultbackend", "sdb", "invite", "lock", "unlock", "identity") {
 
 		var err error
 		db, err = backend.LoadBackend("", backendName)
@@ -73,6 +76,11 @@ func main() {
 				log.Fatalf("Error trying to use Tor: %v\n", err)
 			}
 		}
+
+		// For reading Rows sealed to this user
+		if err = backend.UseIdentity(); err != nil {
+			log.Printf("Error loading identity: %v\n", err)
+		}
 	}
 
 	switch osArgs[1] {
@@ -170,9 +178,118 @@ func main() {
 
 		color.Println(color.TextRow(row))
 
+	case "createfor":
+		// Seal text to the given recipients (and this user)
+		if len(osArgs) < 5 {
+			cli.ArgFatal(createForUsage)
+		}
+
+		recipients, err := backend.ParseRecipients(strings.Split(osArgs[2], ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		self, err := backend.LoadIdentity()
+		if err != nil {
+			log.Fatalf("Error loading identity (create one with `cryptag"+
+				" identity`): %v", err)
+		}
+		recipients = append(recipients, self.PublicOnly())
+
+		tags := append(osArgs[4:], "app:cryptag", "type:text")
+
+		row, err := backend.CreateRowForRecipients(db, nil, []byte(osArgs[3]),
+			tags, recipients)
+		if err != nil {
+			log.Fatalf("Error creating text: %v\n", err)
+		}
+
+		color.Printf("Text sealed to %d recipients and saved with these tags:\n%v\n",
+			len(recipients), color.Tags(row.PlainTags()))
+
+	case "team":
+		if len(osArgs) < 3 {
+			cli.ArgFatal(allTeamUsage)
+		}
+
+		var err error
+
+		switch osArgs[2] {
+		case "add":
+			if len(osArgs) < 5 {
+				cli.ArgFatal(teamAddUsage)
+			}
+			err = backend.AddTeamMember(db, osArgs[3], osArgs[4],
+				strings.Join(osArgs[5:], " "))
+
+		case "remove", "rm":
+			if len(osArgs) < 4 {
+				cli.ArgFatal(teamRemoveUsage)
+			}
+			err = backend.RemoveTeamMember(db, osArgs[3])
+			if err == nil {
+				fmt.Println("Member removed and Backend re-keyed; remaining" +
+					" members should run `cryptag team sync`")
+			}
+
+		case "list", "ls":
+			var team *backend.Team
+			team, err = backend.GetTeam(db)
+			if err == nil {
+				for _, member := range team.Members {
+					fmt.Printf("%s  %s  %s\n", member.ID, member.SignerID,
+						member.Name)
+				}
+			}
+
+		case "sync":
+			err = backend.SyncTeamKeys(db)
+
+		case "trust":
+			if len(osArgs) < 4 {
+				cli.ArgFatal(teamTrustUsage)
+			}
+			err = backend.TrustTeamSigner(db, osArgs[3])
+
+		default:
+			cli.ArgFatal(allTeamUsage)
+		}
+
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	case "identity":
+		// Show this user's miniLock ID, creating an identity if needed
+		keys, err := backend.LoadIdentity()
+		if os.IsNotExist(err) {
+			keys, err = backend.CreateIdentity()
+		}
+		if err != nil {
+			log.Fatalf("Error loading identity: %v", err)
+		}
+
+		id, err := keys.EncodeID()
+		if err != nil {
+			log.Fatalf("Error encoding identity: %v", err)
+		}
+		fmt.Println(id)
+		fmt.Println("Signing ID: " + types.SignerID(backend.SigningKey(keys).Public().(ed25519.PublicKey)))
+
 	case "getkey":
 		fmt.Println(keyutil.Format(db.Key()))
 
+	case "listkeys":
+		// Oldest first; newest (used for new data) marked with '*'
+		ring := backend.KeyRing(db)
+		for i, id := range ring.IDs() {
+			current := " "
+			if i == len(ring)-1 {
+				current = "*"
+			}
+			fmt.Printf("%s %s\n", current, id)
+		}
+
 	case "setkey":
 		if len(osArgs) < 3 {
 			cli.ArgFatal(setkeyUsage)
@@ -185,6 +302,60 @@ func main() {
 			log.Fatalf("Error updating config with new key: %v", err)
 		}
 
+	case "rotatekey":
+		// Re-encrypt all data under a new key (given or random)
+		var newKey *[32]byte
+		if len(osArgs) > 2 {
+			var err error
+			newKey, err = keyutil.Parse(strings.Join(osArgs[2:], ","))
+			if err != nil {
+				log.Fatalf("Error parsing new key: %v", err)
+			}
+		}
+
+		err := backend.RotateKey(db, newKey)
+		if _, ok := err.(*backend.SignedByOthersError); ok {
+			fmt.Printf("Data in Backend `%s` re-encrypted with new key, but %v\n",
+				db.Name(), err)
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error rotating key (re-run to resume): %v", err)
+		}
+
+		fmt.Printf("All data in Backend `%s` re-encrypted with new key\n",
+			db.Name())
+
+	case "blindtags":
+		// Switch to locally-computable RandomTags, or finish moving to
+		// a new blind index key (see `team remove`)
+		var err error
+		if backend.UsesBlindTags(db) {
+			err = backend.RekeyBlindTags(db)
+		} else {
+			err = backend.MigrateToBlindTags(db)
+		}
+		if err != nil {
+			log.Fatalf("Error migrating to blind tags (re-run to resume): %v", err)
+		}
+
+		fmt.Printf("Backend `%s` now uses blind tags\n", db.Name())
+
+	case "setting":
+		// Show all settings, or set one
+		if len(osArgs) == 2 {
+			fmt.Printf("%+v\n", backend.GetSettings(db))
+			break
+		}
+		if len(osArgs) < 4 {
+			cli.ArgFatal(settingUsage)
+		}
+
+		err := backend.UpdateSetting(db, osArgs[2], strings.Join(osArgs[3:], " "))
+		if err != nil {
+			log.Fatalf("Error updating setting: %v", err)
+		}
+
 	case "listbackends", "lb":
 		bkPattern := "*"
 		typ := ""
@@ -197,6 +368,7 @@ func main() {
 			}
 		}
 
+		// Listing doesn't need keys, so don't ask for passphrases
 		configs, err := backend.ReadConfigs("", bkPattern)
 		if err != nil {
 			log.Printf("Error reading Backend configs: %v\n", err)
@@ -216,10 +388,16 @@ func main() {
 				current = "*"
 			}
 
-			color.Printf("%-40s   %-30s   %s\n",
+			locked := ""
+			if conf.Protected() {
+				locked = "   (locked)"
+			}
+
+			color.Printf("%-40s   %-30s   %s%s\n",
 				current+color.BlackOnCyan(conf.Name),
 				color.BlackOnWhite(conf.GetType()),
 				color.BlackOnWhite(conf.GetPath()),
+				locked,
 			)
 		}
 
@@ -236,7 +414,7 @@ func main() {
 		listFiles := (osArgs[1] == "listfiles" || osArgs[1] == "lf")
 		listAny := (osArgs[1] == "listany" || osArgs[1] == "la")
 
-		plaintags := append(osArgs[2:], "all")
+		plaintags := []string{"all"}
 
 		if !listAny {
 			if listFiles {
@@ -246,7 +424,17 @@ func main() {
 			}
 		}
 
-		rows, err := backend.ListRowsFromPlainTags(db, nil, plaintags)
+		opts, args, err := parsePageFlags(osArgs[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		query, err := tagsOrQuery(args, plaintags)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rows, err := backend.ListRowsFromQueryPage(db, nil, query, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -268,7 +456,7 @@ func main() {
 		getFiles := (osArgs[1] == "getfiles" || osArgs[1] == "gf")
 		getAny := (osArgs[1] == "getany" || osArgs[1] == "ga")
 
-		plaintags := append(osArgs[2:], "all")
+		plaintags := []string{"all"}
 
 		if !getAny {
 			if getFiles {
@@ -278,7 +466,17 @@ func main() {
 			}
 		}
 
-		rows, err := backend.RowsFromPlainTags(db, nil, plaintags)
+		opts, args, err := parsePageFlags(osArgs[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		query, err := tagsOrQuery(args, plaintags)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rows, err := backend.RowsFromQueryPage(db, nil, query, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -299,6 +497,7 @@ func main() {
 			// Print bodies of non-file rows as text (includes Tasks, etc)
 			if !row.HasPlainTag("type:file") {
 				color.Println(color.TextRow(row))
+				color.Println("Author: " + color.Author(row))
 				continue
 			}
 
@@ -308,6 +507,7 @@ func main() {
 			// files (to download), print row
 			if !getFiles && row.HasPlainTag("type:text") {
 				color.Println(color.TextRow(row))
+				color.Println("Author: " + color.Author(row))
 			}
 
 			// User just wants to view the text representation of this
@@ -316,7 +516,7 @@ func main() {
 				continue
 			}
 
-			fname, err := rowutil.SaveAsFile(row, dir)
+			fname, err := saveAsFile(db, row, dir)
 			if err != nil {
 				log.Printf("Error locally saving file: %s\n", err)
 				continue
@@ -325,6 +525,100 @@ func main() {
 				color.BlackOnCyan(fname), color.Tags(row.PlainTags()))
 		}
 
+	case "search", "s":
+		if len(osArgs) < 3 {
+			cli.ArgFatal(searchUsage)
+		}
+
+		rows, err := backend.Search(db, nil, strings.Join(osArgs[2:], " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for i, row := range rows {
+			if i != 0 {
+				fmt.Println("")
+			}
+			color.Println(color.TextRow(row))
+			color.Println("Author: " + color.Author(row))
+		}
+
+	case "savedsearch", "ss":
+		if len(osArgs) < 3 {
+			cli.ArgFatal(allSavedSearchUsage)
+		}
+
+		switch osArgs[2] {
+		case "save":
+			if len(osArgs) < 5 {
+				cli.ArgFatal(saveSearchUsage)
+			}
+
+			s, err := backend.SaveSearch(db, nil, osArgs[3], strings.Join(osArgs[4:], " "))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			color.Printf("Saved search %s: %s\n", color.BlackOnCyan(s.Name), s.Query)
+
+		case "list", "ls":
+			searches, err := backend.SavedSearches(db, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, s := range searches {
+				color.Printf("%-30s   %s\n", color.BlackOnCyan(s.Name), s.Query)
+			}
+
+		case "run":
+			opts, args, err := parsePageFlags(osArgs[3:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(args) < 1 {
+				cli.ArgFatal(runSearchUsage)
+			}
+
+			rows, err := backend.RunSavedSearch(db, nil, args[0], opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if opts.Order == "" {
+				rows.Sort(rowutil.ByTagPrefix("created:", true))
+			}
+
+			for i, row := range rows {
+				if i != 0 {
+					fmt.Println("")
+				}
+
+				if row.HasPlainTag("type:file") {
+					fname := rowutil.TagWithPrefixStripped(row, "filename:")
+					color.Println(color.TextAndTags(fname, row.PlainTags()))
+					continue
+				}
+
+				color.Println(color.TextRow(row))
+				color.Println("Author: " + color.Author(row))
+			}
+
+		case "delete", "rm":
+			if len(osArgs) < 4 {
+				cli.ArgFatal(deleteSearchUsage)
+			}
+
+			if err := backend.DeleteSavedSearch(db, nil, osArgs[3]); err != nil {
+				log.Fatal(err)
+			}
+
+			log.Printf("Saved search `%s` deleted\n", osArgs[3])
+
+		default:
+			cli.ArgFatal(allSavedSearchUsage)
+		}
+
 	case "tags", "t":
 		pairs, err := db.AllTagPairs(nil)
 		if err != nil {
@@ -335,6 +629,40 @@ func main() {
 			color.Printf("%s  %s\n", pair.Random, color.BlackOnWhite(pair.Plain()))
 		}
 
+	case "renametag", "mergetag":
+		if len(osArgs) < 4 {
+			cli.ArgFatal(renameTagUsage + "\n" + mergeTagUsage)
+		}
+		oldTag, newTag := osArgs[2], osArgs[3]
+
+		var err error
+		if osArgs[1] == "renametag" {
+			err = backend.RenameTag(db, oldTag, newTag)
+		} else {
+			err = backend.MergeTag(db, oldTag, newTag)
+		}
+		if err != nil {
+			log.Fatalf("Error changing tag `%s` to `%s`: %v",
+				oldTag, newTag, err)
+		}
+
+		fmt.Printf("Tag `%s` is now `%s`\n", oldTag, newTag)
+
+	case "repairtags":
+		// Merge TagPairs created for the same tag by different clients
+		repaired, err := backend.RepairDuplicateTags(db)
+		if err != nil {
+			log.Fatalf("Error repairing duplicate tags (re-run to resume): %v", err)
+		}
+
+		if len(repaired) == 0 {
+			fmt.Println("No duplicate tags found")
+			break
+		}
+		for _, plain := range repaired {
+			color.Printf("Repaired duplicate tag %s\n", color.BlackOnWhite(plain))
+		}
+
 	case "deletetext", "dt", "deletefiles", "df", "deleteany", "da":
 		if len(osArgs) < 3 {
 			cli.ArgFatal(allDeleteUsage)
@@ -359,6 +687,76 @@ func main() {
 
 		log.Println("Row(s) successfully deleted")
 
+	case "lock":
+		// Passphrase-protect the keys of the current Backend or of
+		// all Backends whose names match the given pattern;
+		// re-locking a locked Backend changes its passphrase
+		bkPattern := backendName
+		if len(osArgs) > 2 {
+			bkPattern = osArgs[2]
+		}
+		if bkPattern == "" {
+			cli.ArgFatal(lockUsage)
+		}
+
+		configs, err := backend.ReadConfigs("", bkPattern)
+		if err != nil {
+			log.Fatalf("Error reading Backend configs: %v\n", err)
+		}
+		if len(configs) == 0 {
+			log.Fatalf("No Backends found matching `%s`\n", bkPattern)
+		}
+
+		// Re-locking needs each locked Backend's current key
+		for _, conf := range configs {
+			if err = conf.PromptUnlock(); err != nil {
+				log.Fatalf("Error unlocking Backend `%s`: %v\n", conf.Name, err)
+			}
+		}
+
+		passphrase, err := backend.PromptNewPassphrase()
+		if err != nil {
+			log.Fatalf("Error getting new passphrase: %v\n", err)
+		}
+
+		for _, conf := range configs {
+			if err = backend.LockConfig("", conf, passphrase); err != nil {
+				log.Fatalf("Error locking Backend `%s`: %v\n", conf.Name, err)
+			}
+			fmt.Printf("Backend `%s` locked\n", conf.Name)
+		}
+
+	case "unlock":
+		// Remove passphrase protection from the keys of the current
+		// Backend or of all Backends whose names match the given
+		// pattern
+		bkPattern := backendName
+		if len(osArgs) > 2 {
+			bkPattern = osArgs[2]
+		}
+		if bkPattern == "" {
+			cli.ArgFatal(unlockUsage)
+		}
+
+		configs, err := backend.ReadConfigs("", bkPattern)
+		if err != nil {
+			log.Fatalf("Error reading Backend configs: %v\n", err)
+		}
+
+		for _, conf := range configs {
+			if !conf.Protected() {
+				continue
+			}
+			if err = conf.PromptUnlock(); err != nil {
+				log.Fatalf("Error unlocking Backend `%s`: %v\n", conf.Name, err)
+			}
+			if err = backend.RemoveLock("", conf); err != nil {
+				log.Fatalf("Error unlocking Backend `%s`: %v\n", conf.Name, err)
+			}
+			fmt.Printf("Backend `%s` unlocked; its key is now stored"+
+				" unprotected\n", conf.Name)
+		}
+
 	case "invite":
 		if len(osArgs) == 2 {
 			cli.ArgFatal(allInviteUsage)
@@ -442,6 +840,75 @@ func main() {
 	}
 }
 
+// saveAsFile saves row to dir, decrypting it from db as it's written
+// if row's data is streamed.
+func saveAsFile(db backend.Backend, row *types.Row, dir string) (string, error) {
+	if !row.Streamed {
+		return rowutil.SaveAsFile(row, dir)
+	}
+
+	rc, err := backend.OpenRowStream(db, row)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	return rowutil.SaveReaderAsFile(row, dir, rc)
+}
+
+// parsePageFlags removes the paging flags (--limit, --offset, and
+// --order) from args, returning the options they set.  Limits and
+// offsets count back from the newest Row unless --order says
+// otherwise.
+func parsePageFlags(args []string) (opts types.PageOptions, rest []string, err error) {
+	for i := 0; i < len(args); i++ {
+		flagName := args[i]
+		if !containsAny(flagName, "--limit", "--offset", "--order") {
+			rest = append(rest, flagName)
+			continue
+		}
+		if i+1 == len(args) {
+			return opts, nil, fmt.Errorf("%s requires a value", flagName)
+		}
+		i++
+
+		switch flagName {
+		case "--limit":
+			opts.Limit, err = strconv.Atoi(args[i])
+		case "--offset":
+			opts.Offset, err = strconv.Atoi(args[i])
+		case "--order":
+			opts.Order = args[i]
+		}
+		if err != nil {
+			return opts, nil, fmt.Errorf("Invalid %s `%s`", flagName, args[i])
+		}
+	}
+
+	if opts.Order == types.OrderNone && !opts.IsZero() {
+		opts.Order = types.OrderNewest
+	}
+
+	return opts, rest, opts.Valid()
+}
+
+// tagsOrQuery returns a Query matching Rows tagged with all of args
+// and plaintags or, if args start with -q or --query, matching the
+// query (see backend.Query) made of the rest of args and tagged with
+// all of plaintags.  So that tags containing query syntax can still
+// be used, args aren't parsed as a query unless asked.
+func tagsOrQuery(args, plaintags []string) (*backend.Query, error) {
+	if len(args) == 0 || !containsAny(args[0], "-q", "--query") {
+		return backend.TagsQuery(append(args, plaintags...)), nil
+	}
+
+	query, err := backend.ParseQuery(strings.Join(args[1:], " "))
+	if err != nil {
+		return nil, err
+	}
+	return query.And(backend.TagsQuery(plaintags)), nil
+}
+
 func containsAny(in string, strs ...string) bool {
 	for _, s := range strs {
 		if in == s {
@@ -466,6 +933,17 @@ var (
 	createAnyUsage  = prefix + "createany  <data>     <tag1> [<tag2> <type:...> ...]"
 	allCreateUsage  = strings.Join([]string{createTextUsage, createFileUsage, createAnyUsage}, "\n")
 
+	createForUsage = prefix + "createfor <miniLock ID 1>[,<ID 2>,...] <text> <tag1> [<tag2> ...]"
+	identityUsage  = prefix + "identity"
+
+	teamAddUsage    = prefix + "team add    <miniLock ID> <signing ID> [<name>]"
+	teamRemoveUsage = prefix + "team remove <miniLock ID>"
+	teamListUsage   = prefix + "team list"
+	teamSyncUsage   = prefix + "team sync"
+	teamTrustUsage  = prefix + "team trust  <signing ID of member who added you>"
+	allTeamUsage    = strings.Join([]string{teamAddUsage, teamRemoveUsage,
+		teamListUsage, teamSyncUsage, teamTrustUsage}, "\n")
+
 	updateTextUsage = prefix + "updatetext <id_tag_of_any_previous_version> <new_text>"
 	updateFileUsage = prefix + "updatefile <id_tag_of_any_previous_version> <filename>"
 	updateAnyUsage  = prefix + "updateany  <id_tag_of_any_previous_version> <new_data>"
@@ -475,16 +953,28 @@ var (
 
 	setDefaultBackendUsage = prefix + "setdefaultbackend <backend name>"
 
-	listTextUsage  = prefix + "listtext  <tag1> [<tag2> ...]"
-	listFilesUsage = prefix + "listfiles <tag1> [<tag2> ...]"
-	listAnyUsage   = prefix + "listany   <tag1> [<tag2> ...]"
+	listTextUsage  = prefix + "listtext  <tag1> [<tag2> ...] | --query <query>"
+	listFilesUsage = prefix + "listfiles <tag1> [<tag2> ...] | --query <query>"
+	listAnyUsage   = prefix + "listany   <tag1> [<tag2> ...] | --query <query>"
 	allListUsage   = strings.Join([]string{listTextUsage, listFilesUsage, listAnyUsage}, "\n")
 
-	getTextUsage  = prefix + "gettext  <tag1> [<tag2> ...]"
-	getFilesUsage = prefix + "getfiles <tag1> [<tag2> ...]"
-	getAnyUsage   = prefix + "getany   <tag1> [<tag2> ...]"
+	getTextUsage  = prefix + "gettext  <tag1> [<tag2> ...] | --query <query>"
+	getFilesUsage = prefix + "getfiles <tag1> [<tag2> ...] | --query <query>"
+	getAnyUsage   = prefix + "getany   <tag1> [<tag2> ...] | --query <query>"
 	allGetUsage   = strings.Join([]string{getTextUsage, getFilesUsage, getAnyUsage}, "\n")
 
+	queryUsage = "  (<query> example: --query '(github OR gitlab) -archived'; or -q for short; see backend.Query)"
+	pageUsage  = "  (list* and get* also take [--limit <n>] [--offset <n>] [--order newest|oldest])"
+
+	searchUsage = prefix + "search <phrase>"
+
+	saveSearchUsage     = prefix + "savedsearch save   <name> <query>   (saves <query> as <name>)"
+	listSearchesUsage   = prefix + "savedsearch list"
+	runSearchUsage      = prefix + "savedsearch run    <name> [--limit <n>] [--offset <n>] [--order newest|oldest]"
+	deleteSearchUsage   = prefix + "savedsearch delete <name>"
+	allSavedSearchUsage = strings.Join([]string{saveSearchUsage, listSearchesUsage,
+		runSearchUsage, deleteSearchUsage}, "\n")
+
 	deleteTextUsage  = prefix + "deletetext  <tag1> [<tag2> ...]"
 	deleteFilesUsage = prefix + "deletefiles <tag1> [<tag2> ...]"
 	deleteAnyUsage   = prefix + "deleteany   <tag1> [<tag2> ...]"
@@ -499,17 +989,39 @@ var (
 	getkeyUsage = prefix + "getkey"
 	setkeyUsage = prefix + "setkey <key>"
 
+	listkeysUsage  = prefix + "listkeys"
+	rotatekeyUsage = prefix + "rotatekey [<new key>]"
+
+	blindtagsUsage = prefix + "blindtags"
+
+	renameTagUsage  = prefix + "renametag <old tag> <new tag>"
+	mergeTagUsage   = prefix + "mergetag  <old tag> <new tag>   (re-tags every Row, then deletes <old tag>)"
+	repairTagsUsage = prefix + "repairtags   (merges duplicate TagPairs of the same tag)"
+
+	settingUsage = prefix + "setting [<name> <value>]   (e.g., setting padding pow2)"
+
+	lockUsage   = prefix + "lock   [<backend name pattern>]"
+	unlockUsage = prefix + "unlock [<backend name pattern>]"
+
 	allUsages = []string{
 		allInitUsage, "",
 		createTextUsage, createFileUsage, createAnyUsage, "",
+		createForUsage, identityUsage, "",
+		teamAddUsage, teamRemoveUsage, teamListUsage, teamSyncUsage,
+		teamTrustUsage, "",
 		updateTextUsage, updateFileUsage, updateAnyUsage, "",
 		listTextUsage, listFilesUsage, listAnyUsage, "",
-		getTextUsage, getFilesUsage, getAnyUsage, "",
+		getTextUsage, getFilesUsage, getAnyUsage, queryUsage, pageUsage, "",
+		searchUsage, saveSearchUsage, listSearchesUsage, runSearchUsage,
+		deleteSearchUsage, "",
 		deleteTextUsage, deleteFilesUsage, deleteAnyUsage, "",
 		listBackendsUsage, "",
 		setDefaultBackendUsage, "",
 		createInviteUsage, createInviteOnServerUsage, getInviteOnServerUsage, "",
-		getkeyUsage, setkeyUsage,
+		getkeyUsage, setkeyUsage, listkeysUsage, rotatekeyUsage, "",
+		renameTagUsage, mergeTagUsage, repairTagsUsage, "",
+		settingUsage, blindtagsUsage, "",
+		lockUsage, unlockUsage,
 	}
 	allUsage = strings.Join(allUsages, "\n")
 )
@@ -0,0 +1,107 @@
+// Steve Phillips / elimisteve
+// 2017.04.05
+
+package cryptag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// KeyIDLength is the length, in hex characters, of the IDs returned
+// by KeyID.
+const KeyIDLength = 8
+
+// KeyRing holds every key a Backend has used, oldest first.  The last
+// key is the newest and is what new data gets encrypted with; older
+// keys are kept so that data encrypted with them stays readable.
+type KeyRing []*[32]byte
+
+// ErrKeyNotFound is returned when decrypting something that was
+// encrypted with a key not present in the KeyRing being used.
+type ErrKeyNotFound struct {
+	KeyID string
+}
+
+func (e ErrKeyNotFound) Error() string {
+	return fmt.Sprintf("Key with ID %s not in key ring", e.KeyID)
+}
+
+// KeyID returns a short identifier for key that reveals nothing about
+// key itself.  Stored alongside encrypted data so that the key needed
+// to decrypt it can be found.
+func KeyID(key *[32]byte) string {
+	if key == nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte("cryptag key id\x00"))
+	h.Write(key[:])
+	return hex.EncodeToString(h.Sum(nil))[:KeyIDLength]
+}
+
+// Newest returns the newest key in ring, or nil if ring is empty.
+func (ring KeyRing) Newest() *[32]byte {
+	if len(ring) == 0 {
+		return nil
+	}
+	return ring[len(ring)-1]
+}
+
+// Get returns the key in ring with ID keyID, or nil if there is none.
+func (ring KeyRing) Get(keyID string) *[32]byte {
+	for i := len(ring) - 1; i >= 0; i-- {
+		if KeyID(ring[i]) == keyID {
+			return ring[i]
+		}
+	}
+	return nil
+}
+
+// Candidates returns the keys to try, in order, when decrypting
+// something encrypted with the key whose ID is keyID.  Legacy data
+// has no recorded key ID (keyID == ""), in which case every key in
+// ring is returned, newest first.
+func (ring KeyRing) Candidates(keyID string) ([]*[32]byte, error) {
+	if keyID != "" {
+		key := ring.Get(keyID)
+		if key == nil {
+			return nil, ErrKeyNotFound{keyID}
+		}
+		return []*[32]byte{key}, nil
+	}
+
+	keys := make([]*[32]byte, 0, len(ring))
+	for i := len(ring) - 1; i >= 0; i-- {
+		if ring[i] != nil {
+			keys = append(keys, ring[i])
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrNilKey
+	}
+
+	return keys, nil
+}
+
+// Add returns ring with key appended as its newest key.  If key is
+// already in ring, it is moved to the end.
+func (ring KeyRing) Add(key *[32]byte) KeyRing {
+	newRing := make(KeyRing, 0, len(ring)+1)
+	for _, k := range ring {
+		if k != nil && *k != *key {
+			newRing = append(newRing, k)
+		}
+	}
+	return append(newRing, key)
+}
+
+// IDs returns the IDs of the keys in ring, oldest first.
+func (ring KeyRing) IDs() []string {
+	ids := make([]string, len(ring))
+	for i, key := range ring {
+		ids[i] = KeyID(key)
+	}
+	return ids
+}
@@ -0,0 +1,34 @@
+// Steve Phillips / elimisteve
+// 2017.04.05
+
+package cryptag
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRing(t *testing.T) {
+	k1, _ := RandomKey()
+	k2, _ := RandomKey()
+
+	ring := KeyRing{k1}.Add(k2)
+
+	assert.Equal(t, k2, ring.Newest())
+	assert.Equal(t, k1, ring.Get(KeyID(k1)))
+	assert.Len(t, KeyID(k1), KeyIDLength)
+	assert.NotEqual(t, KeyID(k1), KeyID(k2))
+
+	// Re-adding moves a key to the end rather than duplicating it
+	ring = ring.Add(k1)
+	assert.Equal(t, []string{KeyID(k2), KeyID(k1)}, ring.IDs())
+
+	// Legacy data (no key ID) tries every key, newest first
+	keys, err := ring.Candidates("")
+	assert.Nil(t, err)
+	assert.Equal(t, []*[32]byte{k1, k2}, keys)
+
+	_, err = ring.Candidates("deadbeef")
+	assert.Equal(t, ErrKeyNotFound{"deadbeef"}, err)
+}
@@ -23,7 +23,7 @@ func (t *Task) String() string {
 }
 
 var (
-	bk        *backend.WebserverBackend
+	bk        backend.Backend
 	taskCh    = make(chan types.Rows)
 	tagPairCh = make(chan types.TagPairs)
 
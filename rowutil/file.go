@@ -1,8 +1,9 @@
 package rowutil
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"path"
@@ -17,6 +18,15 @@ import (
 // r is stored in the "decrypted" directory within
 // cryptag.TrustedBasePath ($HOME/.cryptag by default).
 func SaveAsFile(r *types.Row, dir string) (filepath string, err error) {
+	return SaveReaderAsFile(r, dir, bytes.NewReader(r.Decrypted()))
+}
+
+// SaveReaderAsFile does the same thing as SaveAsFile except the
+// file's contents are read from src rather than r.Decrypted().
+// Useful for saving streamed Rows without holding their contents in
+// memory.  If reading from src fails, the partially-written file is
+// removed.
+func SaveReaderAsFile(r *types.Row, dir string, src io.Reader) (filepath string, err error) {
 	f := TagWithPrefixStripped(r, "filename:", "id:")
 	if f == "" {
 		log.Printf("Warning: row doesn't have an id:... tag!\n")
@@ -34,7 +44,17 @@ func SaveAsFile(r *types.Row, dir string) (filepath string, err error) {
 
 	filepath = path.Join(dir, f)
 
-	if err := ioutil.WriteFile(filepath, r.Decrypted(), 0600); err != nil {
+	file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(file, src)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filepath)
 		return "", err
 	}
 
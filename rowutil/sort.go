@@ -25,6 +25,18 @@ func ByTagPrefix(tagPrefix string, ascending bool) types.RowSorter {
 	}
 }
 
+// ByCreated orders Rows by when they were created, according to their
+// "created:..." tags (see Row.Created).
+func ByCreated(ascending bool) types.RowSorter {
+	return func(r1, r2 *types.Row) bool {
+		c1, c2 := r1.Created(), r2.Created()
+		if ascending {
+			return c1.Before(c2)
+		}
+		return c1.After(c2)
+	}
+}
+
 func min(n, m int) int {
 	if n < m {
 		return n
@@ -2,8 +2,8 @@ package rowutil
 
 import (
 	"log"
-	"strings"
 
+	"github.com/cryptag/cryptag/tagschema"
 	"github.com/cryptag/cryptag/types"
 )
 
@@ -24,21 +24,19 @@ func ToVersionedRows(origRows types.Rows, rowLess func(r1, r2 *types.Row) bool)
 	// 2D return value
 	var rrows []types.Rows
 
-	ORIG_VERSION_ROW_PREFIX := "origversionrow:"
-
 	// Create map[groupTag]Rows to group the rows together by the
 	// ID-tag of the original Row that has since been versioned
 	mRows := make(map[string]types.Rows, len(rows))
 	for _, r := range rows {
-		tag := TagWithPrefix(r, ORIG_VERSION_ROW_PREFIX, "id:")
+		// origversionrow:id:... -> id:...
+		tag := tagschema.Value(r.PlainTags(), "origversionrow")
+		if tag == "" {
+			tag = r.IDTag()
+		}
 		if tag == "" {
 			log.Printf("Row with tags %#v has no ID-tag!\n", r.PlainTags())
 			continue
 		}
-		if strings.HasPrefix(tag, ORIG_VERSION_ROW_PREFIX) {
-			// origversionrow:id:... -> id:...
-			tag = tag[len(ORIG_VERSION_ROW_PREFIX):]
-		}
 		// assert: tag is of the form id:..., where this tag is the
 		// ID-tag of the original version of every Row in rows
 		mRows[tag] = append(mRows[tag], r)
@@ -13,7 +13,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/cryptag/cryptag"
 	"github.com/cryptag/cryptag/types"
@@ -76,11 +78,13 @@ func main() {
 	router.HandleFunc("/rows", GetRows).Methods("GET")
 	router.HandleFunc("/rows", PostRow).Methods("POST")
 	router.HandleFunc("/rows/list", ListRows).Methods("GET")
+	router.HandleFunc("/rows/ids", GetRowsByIDs).Methods("GET")
 	router.HandleFunc("/rows/delete", DeleteRows).Methods("GET")
 
 	// Tags
 	router.HandleFunc("/tags", GetTags).Methods("GET")
 	router.HandleFunc("/tags", PostTag).Methods("POST")
+	router.HandleFunc("/tags/delete", DeleteTags).Methods("GET")
 
 	http.Handle("/", router)
 
@@ -217,12 +221,18 @@ func GetRows(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	opts, err := types.ParsePageOptions(req.Form)
+	if err != nil {
+		help.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if types.Debug {
 		log.Printf("Rows queried by these tags: %+v\n", tags)
 	}
 
 	includeFileBody := true
-	rows, err := filesystem.RowsByTags(tags, includeFileBody)
+	rows, err := filesystem.RowsByTagsPage(tags, includeFileBody, opts)
 	if err != nil {
 		if err == types.ErrRowsNotFound {
 			w.WriteHeader(http.StatusNotFound)
@@ -250,8 +260,14 @@ func ListRows(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	opts, err := types.ParsePageOptions(req.Form)
+	if err != nil {
+		help.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	includeFileBody := false
-	rows, err := filesystem.RowsByTags(randtags, includeFileBody)
+	rows, err := filesystem.RowsByTagsPage(randtags, includeFileBody, opts)
 	if err != nil {
 		if err == types.ErrRowsNotFound {
 			w.WriteHeader(http.StatusNotFound)
@@ -266,6 +282,35 @@ func ListRows(w http.ResponseWriter, req *http.Request) {
 	help.WriteJSON(w, rows)
 }
 
+// GetRowsByIDs responds with every Row having any of the RandomTags
+// in the `ids` URL parameter (or an empty list if none do).
+func GetRowsByIDs(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		help.WriteError(w, "Error parsing URL parameters: "+err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	ids, err := parseTags(req.Form["ids"])
+	if err != nil {
+		help.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := filesystem.RowsByAnyTag(ids)
+	if err != nil {
+		help.WriteError(w, "Error fetching rows: "+err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if rows == nil {
+		rows = types.Rows{}
+	}
+
+	help.WriteJSON(w, rows)
+}
+
 func PostRow(w http.ResponseWriter, req *http.Request) {
 	row := &types.Row{}
 	if err := help.ReadInto(req.Body, row); err != nil {
@@ -391,7 +436,17 @@ func GetTags(w http.ResponseWriter, req *http.Request) {
 	}
 	randtags = strings.Split(randtags[0], ",")
 
-	pairs, _ := allTagPairs.WithAllRandomTags(randtags)
+	// Return whichever exist; Rows may have decoy tags with no
+	// TagPair
+	var pairs types.TagPairs
+	for _, random := range randtags {
+		for _, pair := range allTagPairs {
+			if pair.Random == random {
+				pairs = append(pairs, pair)
+				break
+			}
+		}
+	}
 	help.WriteJSON(w, pairs)
 }
 
@@ -419,6 +474,39 @@ func PostTag(w http.ResponseWriter, req *http.Request) {
 	help.WriteJSON(w, pair)
 }
 
+func DeleteTags(w http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+
+	randtags, err := parseTags(req.Form["tags"])
+	if err != nil {
+		help.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, random := range randtags {
+		// Don't let tags like "../rows" escape tagsPath
+		if random == "" || random != filepath.Base(random) {
+			help.WriteError(w, "Invalid tag `"+random+"`", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, random := range randtags {
+		err = os.Remove(path.Join(filesystem.tagsPath, random))
+		if err != nil && !os.IsNotExist(err) {
+			help.WriteError(w, "Error deleting TagPair: "+err.Error(),
+				http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if types.Debug {
+		log.Printf("%d TagPairs deleted\n", len(randtags))
+	}
+
+	help.WriteJSON(w, nil)
+}
+
 func parseTags(tags []string) ([]string, error) {
 	if len(tags) == 0 {
 		return nil, errors.New("No tags included in query (not allowed)")
@@ -484,6 +572,9 @@ func (fs *FileSystem) SaveTagPair(pair *types.TagPair) error {
 		"plain_encrypted": pair.PlainEncrypted,
 		"nonce":           pair.Nonce,
 	}
+	if pair.KeyID != "" {
+		t["key_id"] = pair.KeyID
+	}
 	b, err := json.Marshal(t)
 	if err != nil {
 		return err
@@ -547,6 +638,13 @@ func (fs *FileSystem) SaveRow(row *types.Row) error {
 		"data":  row.Encrypted,
 		"nonce": row.Nonce,
 	}
+	if row.KeyID != "" {
+		rowData["key_id"] = row.KeyID
+	}
+	if row.IsSigned() {
+		rowData["signer"] = row.Signer
+		rowData["sig"] = row.Signature
+	}
 	b, err := json.Marshal(rowData)
 	if err != nil {
 		return err
@@ -556,7 +654,7 @@ func (fs *FileSystem) SaveRow(row *types.Row) error {
 
 	filename := path.Join(fs.rowsPath, strings.Join(row.RandomTags, "-"))
 
-	return ioutil.WriteFile(filename, b, 0644)
+	return writeFileKeepModTime(filename, b, 0644)
 }
 
 func (fs *FileSystem) TagPairsFromRandomTags(randtags []string) (types.TagPairs, error) {
@@ -564,8 +662,15 @@ func (fs *FileSystem) TagPairsFromRandomTags(randtags []string) (types.TagPairs,
 }
 
 func (fs *FileSystem) RowsByTags(randTags []string, includeFileBody bool) (types.Rows, error) {
+	return fs.RowsByTagsPage(randTags, includeFileBody, types.PageOptions{})
+}
+
+// RowsByTagsPage is like RowsByTags but only returns the Rows that
+// opts selects, ordered by when they were first saved under their
+// current RandomTags if opts.Order is set.
+func (fs *FileSystem) RowsByTagsPage(randTags []string, includeFileBody bool, opts types.PageOptions) (types.Rows, error) {
 	if types.Debug {
-		log.Printf("RowsByTags(%#v, %v)\n", randTags, includeFileBody)
+		log.Printf("RowsByTagsPage(%#v, %v, %+v)\n", randTags, includeFileBody, opts)
 	}
 
 	rowFiles, err := filepath.Glob(path.Join(fs.rowsPath, "*"))
@@ -573,16 +678,29 @@ func (fs *FileSystem) RowsByTags(randTags []string, includeFileBody bool) (types
 		return nil, err
 	}
 
-	var rows types.Rows
-
-	// For each row dir, if it has all tags, append to `rows`
+	// For each row file, if it has all tags, keep it
+	var matches []string
 	for _, rowFile := range rowFiles {
 		// Row filenames are of the form randtag1-randtag2-randtag3
 		rowTags := strings.Split(filepath.Base(rowFile), "-")
 
-		if !SliceContainsAll(rowTags, randTags) {
-			continue
+		if SliceContainsAll(rowTags, randTags) {
+			matches = append(matches, rowFile)
+		}
+	}
+
+	if opts.Order != types.OrderNone {
+		if err = sortByModTime(matches, opts.Order == types.OrderNewest); err != nil {
+			return nil, err
 		}
+	}
+
+	start, end := opts.Bounds(len(matches))
+
+	var rows types.Rows
+
+	for _, rowFile := range matches[start:end] {
+		rowTags := strings.Split(filepath.Base(rowFile), "-")
 
 		// Row is tagged with all queryTags; return to user
 		row := &types.Row{RandomTags: rowTags}
@@ -605,10 +723,77 @@ func (fs *FileSystem) RowsByTags(randTags []string, includeFileBody bool) (types
 	return rows, nil
 }
 
+// RowsByAnyTag returns every Row, with its data, that has any of
+// randTags.
+func (fs *FileSystem) RowsByAnyTag(randTags []string) (types.Rows, error) {
+	rowFiles, err := filepath.Glob(path.Join(fs.rowsPath, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rows types.Rows
+
+	for _, rowFile := range rowFiles {
+		rowTags := strings.Split(filepath.Base(rowFile), "-")
+
+		if !SliceContainsAny(rowTags, randTags) {
+			continue
+		}
+
+		row, err := readRowFile(rowFile, rowTags)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
 //
 // Helpers
 //
 
+// writeFileKeepModTime is like ioutil.WriteFile but, if filename
+// already exists (e.g., a Row being re-saved after key rotation),
+// keeps its modification time, which Rows are ordered by.
+func writeFileKeepModTime(filename string, b []byte, perm os.FileMode) error {
+	info, statErr := os.Stat(filename)
+
+	if err := ioutil.WriteFile(filename, b, perm); err != nil {
+		return err
+	}
+
+	if statErr != nil {
+		return nil
+	}
+	return os.Chtimes(filename, time.Now(), info.ModTime())
+}
+
+// sortByModTime sorts files by modification time, oldest first unless
+// newestFirst, breaking ties by name.
+func sortByModTime(files []string, newestFirst bool) error {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		ti, tj := modTimes[files[i]], modTimes[files[j]]
+		if ti.Equal(tj) {
+			return files[i] < files[j]
+		}
+		return ti.Before(tj) != newestFirst
+	})
+
+	return nil
+}
+
 func readRowFile(rowFilePath string, rowTags []string) (*types.Row, error) {
 	b, err := ioutil.ReadFile(rowFilePath)
 	if err != nil {
@@ -616,7 +801,8 @@ func readRowFile(rowFilePath string, rowTags []string) (*types.Row, error) {
 	}
 
 	var row types.Row
-	// This populates row.Encrypted and row.Nonce
+	// This populates row.Encrypted, row.Nonce, row.KeyID,
+	// row.Signer, and row.Signature
 	err = json.Unmarshal(b, &row)
 	if err != nil {
 		return nil, err
@@ -636,6 +822,15 @@ func SliceContains(slice []string, s string) bool {
 	return false
 }
 
+func SliceContainsAny(slice []string, any []string) bool {
+	for _, s := range any {
+		if SliceContains(slice, s) {
+			return true
+		}
+	}
+	return false
+}
+
 func SliceContainsAll(slice []string, all []string) bool {
 	for _, s := range all {
 		if !SliceContains(slice, s) {
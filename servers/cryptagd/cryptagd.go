@@ -412,12 +412,12 @@ func main() {
 			return
 		}
 
-		plaintags, handledReq := parsePlaintags(w, req)
+		creq, query, handledReq := parseRowsQuery(w, req)
 		if handledReq {
 			return
 		}
 
-		rows, err := fetchRowsFromPlainTags(backend.ListRowsFromPlainTags, db, pairs, plaintags)
+		rows, err := fetchRowsPage(backend.ListRowsFromQueryPage, db, pairs, creq, query)
 		if err != nil {
 			errStr := err.Error()
 			if strings.Contains(errStr, "found") {
@@ -445,12 +445,12 @@ func main() {
 			return
 		}
 
-		plaintags, handledReq := parsePlaintags(w, req)
+		creq, query, handledReq := parseRowsQuery(w, req)
 		if handledReq {
 			return
 		}
 
-		rows, err := fetchRowsFromPlainTags(backend.RowsFromPlainTags, db, pairs, plaintags)
+		rows, err := fetchRowsPage(backend.RowsFromQueryPage, db, pairs, creq, query)
 		if err != nil {
 			errStr := err.Error()
 			if strings.Contains(errStr, "found") {
@@ -472,20 +472,216 @@ func main() {
 		api.WriteJSONB(w, rowsB)
 	}
 
+	// GetRowsByIDs fetches the Rows whose IDs (from their "id:..."
+	// tags) are listed, so that UIs can list Rows first then fetch
+	// the data of only the ones the user opens
+	GetRowsByIDs := func(w http.ResponseWriter, req *http.Request) {
+		db, handledReq := getBackend(bkStore, w, req)
+		if handledReq {
+			return
+		}
+
+		creq, handledReq := parseRequest(w, req)
+		if handledReq {
+			return
+		}
+
+		if len(creq.IDs) == 0 {
+			api.WriteErrorStatus(w, "No ids included in request",
+				http.StatusBadRequest)
+			return
+		}
+
+		rows, err := fetchRowsByIDs(db, pairs, creq.IDs)
+		if err != nil {
+			errStr := err.Error()
+			if strings.Contains(errStr, "found") {
+				api.WriteErrorStatus(w, errStr, http.StatusNotFound)
+				return
+			}
+			api.WriteError(w, errStr)
+			return
+		}
+
+		rowsB, err := json.Marshal(trusted.FromRows(rows))
+		if err != nil {
+			api.WriteError(w, err.Error())
+			return
+		}
+
+		api.WriteJSONB(w, rowsB)
+	}
+
+	// SearchRows responds with the text Rows containing the given
+	// phrase; see backend.Search
+	SearchRows := func(w http.ResponseWriter, req *http.Request) {
+		db, handledReq := getBackend(bkStore, w, req)
+		if handledReq {
+			return
+		}
+
+		creq, handledReq := parseRequest(w, req)
+		if handledReq {
+			return
+		}
+
+		rows, err := backend.Search(db, pairs.Get(db), creq.Phrase)
+		if err == backend.ErrEmptyQuery {
+			api.WriteErrorStatus(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			errStr := err.Error()
+			if strings.Contains(errStr, "found") {
+				api.WriteErrorStatus(w, errStr, http.StatusNotFound)
+				return
+			}
+			api.WriteError(w, errStr)
+			return
+		}
+
+		rowsB, err := json.Marshal(trusted.FromRows(rows))
+		if err != nil {
+			api.WriteError(w, err.Error())
+			return
+		}
+
+		api.WriteJSONB(w, rowsB)
+	}
+
+	// ListSavedSearches responds with the Backend's saved searches;
+	// see backend.SavedSearches
+	ListSavedSearches := func(w http.ResponseWriter, req *http.Request) {
+		db, handledReq := getBackend(bkStore, w, req)
+		if handledReq {
+			return
+		}
+
+		searches, err := backend.SavedSearches(db, nil)
+		if err != nil {
+			api.WriteError(w, err.Error())
+			return
+		}
+		if searches == nil {
+			searches = []*backend.SavedSearch{}
+		}
+
+		api.WriteJSON(w, searches)
+	}
+
+	// SaveSearch saves the POSTed query (or list of plaintags) under
+	// the POSTed name; see backend.SaveSearch
+	SaveSearch := func(w http.ResponseWriter, req *http.Request) {
+		db, handledReq := getBackend(bkStore, w, req)
+		if handledReq {
+			return
+		}
+
+		creq, handledReq := parseRequest(w, req)
+		if handledReq {
+			return
+		}
+
+		query := creq.Query
+		if query == "" && len(creq.PlainTags) > 0 {
+			query = backend.TagsQuery(creq.PlainTags).String()
+		}
+
+		if _, err := backend.ParseQuery(query); err != nil || creq.Name == "" {
+			if err == nil {
+				err = backend.ErrEmptySearchName
+			}
+			api.WriteErrorStatus(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s, err := backend.SaveSearch(db, nil, creq.Name, query)
+		if err != nil {
+			api.WriteError(w, err.Error())
+			return
+		}
+
+		go pairs.AsyncUpdate(db)
+
+		api.WriteJSONStatus(w, s, http.StatusCreated)
+	}
+
+	// RunSavedSearch responds with the Rows that the saved search
+	// with the POSTed name matches; see backend.RunSavedSearch
+	RunSavedSearch := func(w http.ResponseWriter, req *http.Request) {
+		db, handledReq := getBackend(bkStore, w, req)
+		if handledReq {
+			return
+		}
+
+		creq, handledReq := parseRequest(w, req)
+		if handledReq {
+			return
+		}
+
+		if err := creq.PageOptions().Valid(); err != nil {
+			api.WriteErrorStatus(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := backend.RunSavedSearch(db, nil, creq.Name, creq.PageOptions())
+		if err != nil {
+			errStr := err.Error()
+			if strings.Contains(errStr, "found") {
+				api.WriteErrorStatus(w, errStr, http.StatusNotFound)
+				return
+			}
+			api.WriteError(w, errStr)
+			return
+		}
+
+		rowsB, err := json.Marshal(trusted.FromRows(rows))
+		if err != nil {
+			api.WriteError(w, err.Error())
+			return
+		}
+
+		api.WriteJSONB(w, rowsB)
+	}
+
+	// DeleteSavedSearch deletes the saved search with the POSTed
+	// name; see backend.DeleteSavedSearch
+	DeleteSavedSearch := func(w http.ResponseWriter, req *http.Request) {
+		db, handledReq := getBackend(bkStore, w, req)
+		if handledReq {
+			return
+		}
+
+		creq, handledReq := parseRequest(w, req)
+		if handledReq {
+			return
+		}
+
+		err := backend.DeleteSavedSearch(db, nil, creq.Name)
+		if err == backend.ErrSavedSearchNotFound {
+			api.WriteErrorStatus(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			api.WriteError(w, err.Error())
+			return
+		}
+
+		api.WriteJSONStatus(w, jsonNoError, http.StatusCreated)
+	}
+
 	GetTags := func(w http.ResponseWriter, req *http.Request) {
 		db, handledReq := getBackend(bkStore, w, req)
 		if handledReq {
 			return
 		}
 
-		newPairs, err := db.AllTagPairs(pairs.Get(db))
+		newPairs, err := pairs.update(db)
 		if err != nil {
 			api.WriteError(w, "Error fetching tag pairs: "+err.Error())
 			return
 		}
 
-		pairs.Set(db, newPairs)
-
 		pairsB, err := json.Marshal(trusted.FromTagPairs(newPairs))
 		if err != nil {
 			api.WriteError(w, "Error marshaling tag pairs: "+err.Error())
@@ -583,6 +779,13 @@ func main() {
 	r.HandleFunc("/trusted/rows/get", GetRows).Methods("POST")
 	r.HandleFunc("/trusted/rows/get/versioned", GetRows).Methods("POST")
 	r.HandleFunc("/trusted/rows/get/versioned/latest", GetRows).Methods("POST")
+	r.HandleFunc("/trusted/rows/get/ids", GetRowsByIDs).Methods("POST")
+	r.HandleFunc("/trusted/rows/search", SearchRows).Methods("POST")
+
+	r.HandleFunc("/trusted/searches", ListSavedSearches).Methods("GET")
+	r.HandleFunc("/trusted/searches", SaveSearch).Methods("POST")
+	r.HandleFunc("/trusted/searches/run", RunSavedSearch).Methods("POST")
+	r.HandleFunc("/trusted/searches/delete", DeleteSavedSearch).Methods("POST")
 	r.HandleFunc("/trusted/rows", CreateRow).Methods("POST")
 	r.HandleFunc("/trusted/rows/string", CreateRow).Methods("POST")
 	r.HandleFunc("/trusted/rows/file", CreateFileRow).Methods("POST")
@@ -654,6 +857,17 @@ func getBackend(bkStore *BackendStore, w http.ResponseWriter, req *http.Request)
 }
 
 func parsePlaintags(w http.ResponseWriter, req *http.Request) (plaintags []string, handledReq bool) {
+	creq, handledReq := parseRequest(w, req)
+	if handledReq {
+		return nil, true
+	}
+
+	// TODO: Return error if len(req.PlainTags) == 0?
+
+	return creq.PlainTags, false
+}
+
+func parseRequest(w http.ResponseWriter, req *http.Request) (creq *Request, handledReq bool) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		api.WriteError(w, err.Error())
@@ -661,9 +875,9 @@ func parsePlaintags(w http.ResponseWriter, req *http.Request) (plaintags []strin
 	}
 	defer req.Body.Close()
 
-	var creq Request
+	creq = &Request{}
 
-	err = json.Unmarshal(body, &creq)
+	err = json.Unmarshal(body, creq)
 	if err != nil {
 		errStr := fmt.Sprintf(`Error parsing POSTed JSON object with`+
 			` 'plaintags' key %s: %s`, body, err)
@@ -671,13 +885,64 @@ func parsePlaintags(w http.ResponseWriter, req *http.Request) (plaintags []strin
 		return nil, true
 	}
 
-	// TODO: Return error if len(req.PlainTags) == 0?
+	return creq, false
+}
 
-	return creq.PlainTags, false
+// parseRowsQuery parses the query that the POSTed JSON object's
+// "query" (see backend.Query) and "plaintags" keys together describe,
+// and checks its paging options.
+func parseRowsQuery(w http.ResponseWriter, req *http.Request) (creq *Request, query *backend.Query, handledReq bool) {
+	creq, handledReq = parseRequest(w, req)
+	if handledReq {
+		return nil, nil, true
+	}
+
+	if err := creq.PageOptions().Valid(); err != nil {
+		api.WriteErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, true
+	}
+
+	query = backend.TagsQuery(creq.PlainTags)
+
+	if creq.Query != "" {
+		userQuery, err := backend.ParseQuery(creq.Query)
+		if err != nil {
+			api.WriteErrorStatus(w, err.Error(), http.StatusBadRequest)
+			return nil, nil, true
+		}
+		query = userQuery.And(query)
+	}
+
+	return creq, query, false
 }
 
 type Request struct {
 	PlainTags []string `json:"plaintags"`
+
+	// Optional; see backend.Query
+	Query string `json:"query,omitempty"`
+
+	// For /trusted/rows/get/ids; see backend.RowsByIDs
+	IDs []string `json:"ids,omitempty"`
+
+	// For /trusted/rows/search; see backend.Search
+	Phrase string `json:"phrase,omitempty"`
+
+	// For /trusted/searches...; see backend.SaveSearch
+	Name string `json:"name,omitempty"`
+
+	// Optional; see types.PageOptions
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Order  string `json:"order,omitempty"`
+}
+
+func (creq *Request) PageOptions() types.PageOptions {
+	return types.PageOptions{
+		Limit:  creq.Limit,
+		Offset: creq.Offset,
+		Order:  creq.Order,
+	}
 }
 
 //
@@ -703,17 +968,13 @@ func NewBackendStore(bks []backend.Backend) *BackendStore {
 }
 
 func (store *BackendStore) Get(bkPrimary string, bkNames ...string) (backend.Backend, error) {
-	store.mu.RLock()
-	defer store.mu.RUnlock()
-
 	if bkPrimary != "" {
-		bk := store.bks[bkPrimary]
-		if bk == nil {
-			return nil, fmt.Errorf("Backend `%s` not found", bkPrimary)
-		}
-		return bk, nil
+		return store.load(bkPrimary)
 	}
 
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
 	for _, name := range bkNames {
 		bk := store.bks[name]
 		if bk != nil {
@@ -738,6 +999,31 @@ func (store *BackendStore) Get(bkPrimary string, bkNames ...string) (backend.Bac
 		strings.Join(bkNames, ", "))
 }
 
+// load returns the Backend named bkName, loading it first if it's
+// passphrase-protected and so wasn't loaded along with the others.
+func (store *BackendStore) load(bkName string) (backend.Backend, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if bk := store.bks[bkName]; bk != nil {
+		return bk, nil
+	}
+
+	cfg, err := backend.ReadLockedConfig("", bkName)
+	if err != nil || !cfg.Protected() {
+		return nil, fmt.Errorf("Backend `%s` not found", bkName)
+	}
+
+	bk, err := backend.LoadBackend("", bkName)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading Backend `%s`: %v", bkName, err)
+	}
+
+	store.bks[bkName] = bk
+
+	return bk, nil
+}
+
 func (store *BackendStore) Add(bk backend.Backend) error {
 	store.mu.Lock()
 	defer store.mu.Unlock()
@@ -758,6 +1044,10 @@ func (store *BackendStore) AddByConfig(configs ...*backend.Config) {
 		if _, exists := store.bks[cfg.Name]; exists {
 			continue
 		}
+		// Loaded once selected; see load
+		if cfg.Locked() {
+			continue
+		}
 		bk, err := backend.New(cfg)
 		if err != nil {
 			log.Printf("Error turning config `%s` into Backend: %v\n", cfg.Name,
@@ -812,7 +1102,7 @@ func (store *BackendStore) AddByName(bkNames ...string) {
 //
 
 func NewTagPairStore() *TagPairStore {
-	store := &TagPairStore{pairs: map[string]types.TagPairs{}}
+	store := &TagPairStore{pairs: map[string]*types.TagPairIndex{}}
 	// go store.loop()
 	return store
 }
@@ -820,25 +1110,31 @@ func NewTagPairStore() *TagPairStore {
 type TagPairStore struct {
 	mu sync.RWMutex
 
-	// map[backendName]pairs
-	pairs map[string]types.TagPairs
+	// map[backendName]pairs, indexed so that Backend functions that
+	// are passed them can look up tags quickly.  Since they're used
+	// by many requests at once, each index is never changed once
+	// stored; Set stores an updated copy instead.
+	pairs map[string]*types.TagPairIndex
 }
 
 func (store *TagPairStore) Update(bk backend.Backend) error {
-	store.mu.RLock()
-	oldPairs := store.pairs[bk.Name()]
-	store.mu.RUnlock()
+	_, err := store.update(bk)
+	return err
+}
+
+// update fetches bk's TagPairs, stores them, and returns them.
+func (store *TagPairStore) update(bk backend.Backend) (types.TagPairs, error) {
+	oldPairs := store.Get(bk)
 
+	// Backends can skip re-fetching the TagPairs we already have
 	newPairs, err := bk.AllTagPairs(oldPairs)
 	if err != nil {
-		return fmt.Errorf("Error updating %s's TagPairs: %v", bk.Name(), err)
+		return nil, fmt.Errorf("Error updating %s's TagPairs: %v", bk.Name(), err)
 	}
 
-	store.mu.Lock()
-	store.pairs[bk.Name()] = newPairs
-	store.mu.Unlock()
+	store.Set(bk, newPairs)
 
-	return nil
+	return store.Get(bk), nil
 }
 
 func (store *TagPairStore) AsyncUpdate(bk backend.Backend) {
@@ -857,14 +1153,52 @@ func (store *TagPairStore) Get(bk backend.Backend) types.TagPairs {
 	store.mu.RLock()
 	defer store.mu.RUnlock()
 
-	return store.pairs[bk.Name()]
+	idx := store.pairs[bk.Name()]
+	if idx == nil {
+		return nil
+	}
+	// So that Backend functions passed these TagPairs use idx rather
+	// than indexing them again
+	return idx.Snapshot()
 }
 
+// Set replaces bk's TagPairs with newPairs, adding to and removing
+// from the TagPairs already indexed rather than re-indexing them all.
 func (store *TagPairStore) Set(bk backend.Backend, newPairs types.TagPairs) {
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
-	store.pairs[bk.Name()] = newPairs
+	old := store.pairs[bk.Name()]
+	if old == nil {
+		store.pairs[bk.Name()] = types.NewTagPairIndex(newPairs)
+		return
+	}
+
+	var added types.TagPairs
+	current := make(map[string]bool, len(newPairs))
+	for _, pair := range newPairs {
+		current[pair.Random] = true
+		if !old.HasRandom(pair.Random) {
+			added = append(added, pair)
+		}
+	}
+
+	var removed []string
+	for _, pair := range old.Pairs() {
+		if !current[pair.Random] {
+			removed = append(removed, pair.Random)
+		}
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	idx := old.Clone()
+	idx.Add(added...)
+	idx.Remove(removed...)
+
+	store.pairs[bk.Name()] = idx
 }
 
 //
@@ -888,6 +1222,34 @@ func fetchRowsFromPlainTags(fetcher func(backend.Backend, types.TagPairs, crypta
 	return nil, err
 }
 
+func fetchRowsFromQuery(fetcher func(backend.Backend, types.TagPairs, *backend.Query) (types.Rows, error), bk backend.Backend, pairStore *TagPairStore, query *backend.Query) (types.Rows, error) {
+	return fetchRowsFromPlainTags(func(bk backend.Backend, pairs types.TagPairs, _ cryptag.PlainTags) (types.Rows, error) {
+		return fetcher(bk, pairs, query)
+	}, bk, pairStore, nil)
+}
+
+// fetchRowsByIDs fetches the Rows with the given IDs, re-fetching
+// pairStore's TagPairs and trying again if none were found in case
+// they're new.
+func fetchRowsByIDs(bk backend.Backend, pairStore *TagPairStore, ids []string) (types.Rows, error) {
+	rows, err := backend.RowsByIDs(bk, pairStore.Get(bk), ids)
+	if err != types.ErrRowsNotFound || pairStore.Get(bk) == nil {
+		return rows, err
+	}
+
+	if err = pairStore.Update(bk); err != nil {
+		return nil, fmt.Errorf("Error re-fetching TagPairs: %v", err)
+	}
+	return backend.RowsByIDs(bk, pairStore.Get(bk), ids)
+}
+
+// fetchRowsPage fetches the page of Rows that creq selects.
+func fetchRowsPage(fetcher func(backend.Backend, types.TagPairs, *backend.Query, types.PageOptions) (types.Rows, error), bk backend.Backend, pairStore *TagPairStore, creq *Request, query *backend.Query) (types.Rows, error) {
+	return fetchRowsFromQuery(func(bk backend.Backend, pairs types.TagPairs, query *backend.Query) (types.Rows, error) {
+		return fetcher(bk, pairs, query, creq.PageOptions())
+	}, bk, pairStore, query)
+}
+
 // TODO: For efficiency, don't fetch every version of every Row when
 // we only care about the most recent version of each
 func getTrustedRowsByPath(urlPath string, rows types.Rows) (trows interface{}) {
@@ -0,0 +1,280 @@
+// Steve Phillips / elimisteve
+// 2017.04.02
+
+package cryptag
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// StreamChunkSize is the number of plaintext bytes sealed into each
+// chunk of an encrypted stream.  Every chunk but the last is exactly
+// this long; the last may be shorter (or empty).
+const StreamChunkSize = 64 * 1024
+
+const (
+	streamChunkOverhead = secretbox.Overhead
+	sealedChunkSize     = StreamChunkSize + streamChunkOverhead
+
+	// Flipped into the last byte of every chunk nonce so that no
+	// chunk nonce ever equals the stream's base nonce
+	streamNonceFlag = 0x01
+	// Additionally flipped into the last byte of the final chunk's
+	// nonce so that truncation is detectable
+	streamFinalFlag = 0x02
+)
+
+var (
+	ErrStreamTruncated = errors.New("Encrypted stream truncated; final chunk missing")
+	ErrStreamClosed    = errors.New("Encrypted stream already closed")
+)
+
+// streamNonce derives the nonce for the chunk numbered ctr from the
+// stream's base nonce.  The big-endian chunk counter is XORed into
+// bytes 15-22 and flags are XORed into the final byte, so each chunk
+// of a stream gets a unique nonce, and the final chunk's nonce
+// differs from what a non-final chunk at the same position would use.
+func streamNonce(base *[24]byte, ctr uint64, final bool) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], base[:])
+
+	var ctrB [8]byte
+	binary.BigEndian.PutUint64(ctrB[:], ctr)
+	for i := range ctrB {
+		nonce[15+i] ^= ctrB[i]
+	}
+
+	nonce[23] ^= streamNonceFlag
+	if final {
+		nonce[23] ^= streamFinalFlag
+	}
+
+	return &nonce
+}
+
+//
+// Encryption
+//
+
+type encryptWriter struct {
+	w      io.Writer
+	nonce  *[24]byte
+	key    *[32]byte
+	buf    []byte
+	sealed []byte
+	ctr    uint64
+	closed bool
+}
+
+// NewEncryptWriter returns an io.WriteCloser that encrypts everything
+// written to it in chunks of StreamChunkSize bytes, sealing each chunk
+// with key and a nonce derived from nonce, then writes the result to
+// w.  Close must be called to seal and write the final chunk; a stream
+// without its final chunk will not decrypt.
+func NewEncryptWriter(w io.Writer, nonce *[24]byte, key *[32]byte) (io.WriteCloser, error) {
+	if nonce == nil {
+		return nil, ErrNilNonce
+	}
+	if key == nil {
+		return nil, ErrNilKey
+	}
+
+	ew := &encryptWriter{
+		w:      w,
+		nonce:  nonce,
+		key:    key,
+		buf:    make([]byte, 0, StreamChunkSize),
+		sealed: make([]byte, 0, sealedChunkSize),
+	}
+	return ew, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, ErrStreamClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full buffer once more data arrives, since the
+		// last full chunk may turn out to be the final one
+		if len(ew.buf) == StreamChunkSize {
+			if err := ew.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals and writes the final chunk.  It does not close the
+// underlying io.Writer.
+func (ew *encryptWriter) Close() error {
+	if ew.closed {
+		return ErrStreamClosed
+	}
+	ew.closed = true
+
+	return ew.seal(true)
+}
+
+func (ew *encryptWriter) seal(final bool) error {
+	nonce := streamNonce(ew.nonce, ew.ctr, final)
+	ew.sealed = secretbox.Seal(ew.sealed[:0], ew.buf, nonce, ew.key)
+
+	if _, err := ew.w.Write(ew.sealed); err != nil {
+		return err
+	}
+
+	ew.buf = ew.buf[:0]
+	ew.ctr++
+
+	return nil
+}
+
+//
+// Decryption
+//
+
+type decryptReader struct {
+	r      *bufio.Reader
+	nonce  *[24]byte
+	key    *[32]byte
+	sealed []byte
+	opened []byte
+	plain  []byte // Not-yet-read portion of opened
+	ctr    uint64
+	done   bool
+	err    error
+}
+
+// NewDecryptReader returns an io.Reader that decrypts the stream of
+// chunks read from r, which must have been created by an
+// io.WriteCloser returned from NewEncryptWriter using the same nonce
+// and key.  The returned Reader returns ErrDecrypt if any chunk fails
+// to authenticate and ErrStreamTruncated if r ends before the final
+// chunk is read.
+func NewDecryptReader(r io.Reader, nonce *[24]byte, key *[32]byte) (io.Reader, error) {
+	if nonce == nil {
+		return nil, ErrNilNonce
+	}
+	if key == nil {
+		return nil, ErrNilKey
+	}
+
+	dr := &decryptReader{
+		r:      bufio.NewReaderSize(r, sealedChunkSize),
+		nonce:  nonce,
+		key:    key,
+		sealed: make([]byte, sealedChunkSize),
+		opened: make([]byte, 0, StreamChunkSize),
+	}
+	return dr, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.done {
+			return 0, io.EOF
+		}
+		dr.err = dr.open()
+	}
+
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+
+	return n, nil
+}
+
+// open reads, authenticates, and decrypts the next chunk.
+func (dr *decryptReader) open() error {
+	n, err := io.ReadFull(dr.r, dr.sealed)
+	if err == io.EOF {
+		// Previous chunk wasn't final, yet no chunks remain
+		return ErrStreamTruncated
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// A short chunk is always the final one; a full-length chunk is
+	// final if nothing follows it
+	final := err == io.ErrUnexpectedEOF
+	if !final {
+		_, err = dr.r.Peek(1)
+		if err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	sealed := dr.sealed[:n]
+
+	nonce := streamNonce(dr.nonce, dr.ctr, final)
+	opened, ok := secretbox.Open(dr.opened[:0], sealed, nonce, dr.key)
+	if !ok {
+		// Distinguish truncation (chunks after this one were
+		// dropped) from other corruption
+		if final {
+			nonce = streamNonce(dr.nonce, dr.ctr, false)
+			if _, ok = secretbox.Open(nil, sealed, nonce, dr.key); ok {
+				return ErrStreamTruncated
+			}
+		}
+		return ErrDecrypt
+	}
+
+	dr.plain = opened
+	dr.ctr++
+	dr.done = final
+
+	return nil
+}
+
+//
+// Convenience functions
+//
+
+// EncryptStream encrypts everything read from src with nonce and key
+// and writes the resulting encrypted stream to dst.  Returns the
+// number of plaintext bytes read from src.
+func EncryptStream(dst io.Writer, src io.Reader, nonce *[24]byte, key *[32]byte) (int64, error) {
+	ew, err := NewEncryptWriter(dst, nonce, key)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(ew, src)
+	if err != nil {
+		return n, err
+	}
+
+	return n, ew.Close()
+}
+
+// DecryptStream decrypts the encrypted stream read from src with nonce
+// and key and writes the plaintext to dst.  Returns the number of
+// plaintext bytes written to dst.
+func DecryptStream(dst io.Writer, src io.Reader, nonce *[24]byte, key *[32]byte) (int64, error) {
+	dr, err := NewDecryptReader(src, nonce, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return io.Copy(dst, dr)
+}
@@ -0,0 +1,90 @@
+// Steve Phillips / elimisteve
+// 2017.04.02
+
+package cryptag
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecryptStream(t *testing.T) {
+	key, _ := RandomKey()
+	nonce, _ := RandomNonce()
+
+	sizes := []int{
+		0,
+		1,
+		StreamChunkSize - 1,
+		StreamChunkSize,
+		StreamChunkSize + 1,
+		3*StreamChunkSize + 123,
+	}
+
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var enc bytes.Buffer
+		n, err := EncryptStream(&enc, bytes.NewReader(plain), nonce, key)
+		if err != nil {
+			t.Fatalf("Error encrypting stream of size %d: %v", size, err)
+		}
+		assert.Equal(t, int64(size), n)
+
+		var dec bytes.Buffer
+		n, err = DecryptStream(&dec, &enc, nonce, key)
+		if err != nil {
+			t.Fatalf("Error decrypting stream of size %d: %v", size, err)
+		}
+		assert.Equal(t, int64(size), n)
+		assert.Equal(t, plain, dec.Bytes(), "Decrypted stream doesn't match"+
+			" original plaintext of size %d", size)
+	}
+}
+
+func TestDecryptStreamTruncated(t *testing.T) {
+	key, _ := RandomKey()
+	nonce, _ := RandomNonce()
+
+	plain := make([]byte, 2*StreamChunkSize+10)
+	rand.Read(plain)
+
+	var enc bytes.Buffer
+	if _, err := EncryptStream(&enc, bytes.NewReader(plain), nonce, key); err != nil {
+		t.Fatalf("Error encrypting stream: %v", err)
+	}
+
+	// Drop the final chunk
+	truncated := enc.Bytes()[:2*sealedChunkSize]
+
+	_, err := DecryptStream(ioutil.Discard, bytes.NewReader(truncated), nonce, key)
+	assert.Equal(t, ErrStreamTruncated, err)
+
+	// Drop everything
+	_, err = DecryptStream(ioutil.Discard, bytes.NewReader(nil), nonce, key)
+	assert.Equal(t, ErrStreamTruncated, err)
+}
+
+func TestDecryptStreamTampered(t *testing.T) {
+	key, _ := RandomKey()
+	nonce, _ := RandomNonce()
+
+	plain := make([]byte, StreamChunkSize+10)
+	rand.Read(plain)
+
+	var enc bytes.Buffer
+	if _, err := EncryptStream(&enc, bytes.NewReader(plain), nonce, key); err != nil {
+		t.Fatalf("Error encrypting stream: %v", err)
+	}
+
+	tampered := enc.Bytes()
+	tampered[sealedChunkSize+20] ^= 0xff
+
+	_, err := DecryptStream(ioutil.Discard, bytes.NewReader(tampered), nonce, key)
+	assert.Equal(t, ErrDecrypt, err)
+}
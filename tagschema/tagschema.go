@@ -0,0 +1,288 @@
+// Steve Phillips / elimisteve
+// 2017.04.24
+
+// Package tagschema parses and validates key:value plain tags such as
+// "id:...", "created:...", and "when:...".
+//
+// A plain tag containing a colon is made of a namespace (the part
+// before the first colon) and a value (the rest).  Namespaces can be
+// registered with the type of value their tags must have; tags in
+// unregistered namespaces, and tags without a colon, can have any
+// value.
+package tagschema
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tag is a key:value plain tag.
+type Tag struct {
+	Namespace string // E.g., "created"
+	Value     string // E.g., "20170424101500000000000"
+}
+
+// Parse splits plain into its namespace and value.  ok is false if
+// plain doesn't contain a colon.
+func Parse(plain string) (tag Tag, ok bool) {
+	i := strings.Index(plain, ":")
+	if i == -1 {
+		return Tag{}, false
+	}
+	return Tag{Namespace: plain[:i], Value: plain[i+1:]}, true
+}
+
+// New returns the plain tag with the given namespace and value.
+func New(namespace, value string) string {
+	return namespace + ":" + value
+}
+
+func (tag Tag) String() string {
+	return New(tag.Namespace, tag.Value)
+}
+
+// Type returns the type of value tag's namespace was registered with,
+// or String if it wasn't registered.
+func (tag Tag) Type() ValueType {
+	if vt, ok := Lookup(tag.Namespace); ok {
+		return vt
+	}
+	return String
+}
+
+// Valid returns an error if tag's value isn't of the type its
+// namespace was registered with.
+func (tag Tag) Valid() error {
+	if err := tag.Type().Validate(tag.Value); err != nil {
+		return fmt.Errorf("Invalid tag `%s`: %v", tag, err)
+	}
+	return nil
+}
+
+// Time parses tag's value as a Timestamp or Date, according to the
+// type its namespace was registered with.
+func (tag Tag) Time() (time.Time, error) {
+	switch tag.Type().Name {
+	case Timestamp.Name:
+		return ParseTimestamp(tag.Value)
+	case Date.Name:
+		return ParseDate(tag.Value)
+	}
+	return time.Time{}, fmt.Errorf("Tag `%s` isn't a time", tag)
+}
+
+// Int parses tag's value as an integer.
+func (tag Tag) Int() (int64, error) {
+	return strconv.ParseInt(tag.Value, 10, 64)
+}
+
+// ValueType is the type of value that a namespace's tags have.
+type ValueType struct {
+	Name     string
+	Validate func(value string) error
+}
+
+var (
+	ErrEmptyValue = errors.New("Value cannot be empty")
+	ErrNotIDTag   = errors.New(`Value must be an ID tag ("id:...")`)
+)
+
+var (
+	// String is any non-empty value
+	String = ValueType{"string", validString}
+
+	// Timestamp is a UTC time formatted by cryptag.TimeStr
+	// (e.g., "20170424101500123456789"), or a legacy one with only
+	// seconds (e.g., "20170424101500")
+	Timestamp = ValueType{"timestamp", func(v string) error {
+		_, err := ParseTimestamp(v)
+		return err
+	}}
+
+	// Date is a day formatted like "20170424"
+	Date = ValueType{"date", func(v string) error {
+		_, err := ParseDate(v)
+		return err
+	}}
+
+	// UUID is a UUID like "a91d46c7-45bb-48e4-43d1-642196df15b2"
+	UUID = ValueType{"uuid", validUUID}
+
+	// IDRef is a reference to another Row by its ID tag
+	// (e.g., "id:a91d46c7-45bb-48e4-43d1-642196df15b2")
+	IDRef = ValueType{"idref", func(v string) error {
+		tag, ok := Parse(v)
+		if !ok || tag.Namespace != "id" {
+			return ErrNotIDTag
+		}
+		return validUUID(tag.Value)
+	}}
+
+	// URL is a URL or bare hostname
+	URL = ValueType{"url", func(v string) error {
+		if err := validString(v); err != nil {
+			return err
+		}
+		_, err := url.Parse(v)
+		return err
+	}}
+
+	// Int is a base 10 integer
+	Int = ValueType{"int", func(v string) error {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err
+	}}
+)
+
+const (
+	timestampLayout = "20060102150405"
+	dateLayout      = "20060102"
+)
+
+// ParseTimestamp parses a Timestamp value.
+func ParseTimestamp(value string) (time.Time, error) {
+	if len(value) != len(timestampLayout) && len(value) != len(timestampLayout)+9 {
+		return time.Time{}, fmt.Errorf("Invalid timestamp `%s`", value)
+	}
+
+	t, err := time.Parse(timestampLayout, value[:len(timestampLayout)])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid timestamp `%s`: %v", value, err)
+	}
+
+	if nanos := value[len(timestampLayout):]; nanos != "" {
+		n, err := strconv.Atoi(nanos)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("Invalid timestamp `%s`", value)
+		}
+		t = t.Add(time.Duration(n))
+	}
+
+	return t, nil
+}
+
+// ParseDate parses a Date value.
+func ParseDate(value string) (time.Time, error) {
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date `%s`: %v", value, err)
+	}
+	return t, nil
+}
+
+// FormatDate formats t as a Date value.
+func FormatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+func validString(v string) error {
+	if v == "" {
+		return ErrEmptyValue
+	}
+	return nil
+}
+
+// validUUID checks that v looks like
+// "a91d46c7-45bb-48e4-43d1-642196df15b2"
+func validUUID(v string) error {
+	if len(v) != 36 {
+		return fmt.Errorf("Invalid UUID `%s`", v)
+	}
+	for i, r := range v {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return fmt.Errorf("Invalid UUID `%s`", v)
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return fmt.Errorf("Invalid UUID `%s`", v)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	registryMu sync.RWMutex
+
+	// The namespaces used by CrypTag itself and its apps
+	registry = map[string]ValueType{
+		"id":             UUID,
+		"created":        Timestamp,
+		"when":           Date,
+		"origversionrow": IDRef,
+		"parentrow":      IDRef,
+		"type":           String,
+		"app":            String,
+		"filename":       String,
+		"url":            URL,
+		"login":          String,
+		"size":           Int,
+	}
+)
+
+// Register sets the type of value that tags in namespace must have.
+// Apps can register their own namespaces, or override the type of an
+// existing one.
+func Register(namespace string, vt ValueType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[namespace] = vt
+}
+
+// Lookup returns the type namespace was registered with.
+func Lookup(namespace string) (ValueType, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	vt, ok := registry[namespace]
+	return vt, ok
+}
+
+// Validate returns an error if plain is a key:value tag in a
+// registered namespace whose value isn't of that namespace's type.
+func Validate(plain string) error {
+	tag, ok := Parse(plain)
+	if !ok {
+		return nil
+	}
+	if _, registered := Lookup(tag.Namespace); !registered {
+		return nil
+	}
+	return tag.Valid()
+}
+
+// ValidateAll calls Validate on each of plaintags, returning the first
+// error.
+func ValidateAll(plaintags []string) error {
+	for _, plain := range plaintags {
+		if err := Validate(plain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns the first of plaintags in namespace.
+func Find(plaintags []string, namespace string) (tag Tag, ok bool) {
+	for _, plain := range plaintags {
+		if tag, ok = Parse(plain); ok && tag.Namespace == namespace {
+			return tag, true
+		}
+	}
+	return Tag{}, false
+}
+
+// Value returns the value of the first of plaintags in namespace, or
+// "" if there is none.
+func Value(plaintags []string, namespace string) string {
+	tag, _ := Find(plaintags, namespace)
+	return tag.Value
+}
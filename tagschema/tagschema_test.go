@@ -0,0 +1,80 @@
+// Steve Phillips / elimisteve
+// 2017.04.24
+
+package tagschema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateTest struct {
+	plain string
+	valid bool
+}
+
+var validateTests = []validateTest{
+	{"work", true},
+	{"http://example.com", true}, // Unregistered namespace
+	{"id:a91d46c7-45bb-48e4-43d1-642196df15b2", true},
+	{"id:a91d46c7", false},
+	{"id:", false},
+	{"created:20170424101500123456789", true},
+	{"created:20170424101500", true},
+	{"created:2017-04-24", false},
+	{"when:20170301", true},
+	{"when:20171301", false},
+	{"origversionrow:id:a91d46c7-45bb-48e4-43d1-642196df15b2", true},
+	{"origversionrow:a91d46c7-45bb-48e4-43d1-642196df15b2", false},
+	{"url:example.com", true},
+	{"url:", false},
+	{"size:1024", true},
+	{"size:big", false},
+	{"type:", false},
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range validateTests {
+		err := Validate(test.plain)
+		if test.valid {
+			assert.Nil(t, err, test.plain)
+		} else {
+			assert.NotNil(t, err, test.plain)
+		}
+	}
+}
+
+func TestTagTime(t *testing.T) {
+	tag, ok := Find([]string{"work", "id:x", "created:20170424101500000000042"},
+		"created")
+	assert.True(t, ok)
+
+	created, err := tag.Time()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2017, 4, 24, 10, 15, 0, 42, time.UTC), created)
+
+	tag, _ = Parse("when:20170301")
+	when, err := tag.Time()
+	assert.Nil(t, err)
+	assert.Equal(t, "20170301", FormatDate(when))
+
+	tag, _ = Parse("type:text")
+	_, err = tag.Time()
+	assert.NotNil(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	assert.Nil(t, Validate("priority:high"))
+
+	Register("priority", Int)
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "priority")
+		registryMu.Unlock()
+	}()
+
+	assert.NotNil(t, Validate("priority:high"))
+	assert.Nil(t, Validate("priority:1"))
+}
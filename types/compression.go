@@ -0,0 +1,99 @@
+// Steve Phillips / elimisteve
+// 2017.04.08
+
+package types
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Compression algorithms for Row data.  Compressed payloads start
+// with a 1-byte algorithm ID so that more algorithms can be added
+// later without breaking existing Rows.
+const (
+	CompressNone = ""
+	CompressGzip = "gzip"
+)
+
+const compressIDGzip byte = 1
+
+var (
+	ErrInvalidCompression = errors.New("Invalid compressed row data")
+	ErrDecompressedTooBig = errors.New("Compressed row data decompresses to" +
+		" more than MaxDecompressedSize bytes")
+)
+
+// MaxDecompressedSize is the most bytes that a Row's compressed data
+// is allowed to decompress to, so that a small, malicious Row can't
+// make us allocate gigabytes of memory.  Store larger data as a
+// stream (see Row.EncryptStream), which is never compressed.
+var MaxDecompressedSize int64 = 256 << 20
+
+// ValidCompression returns an error if algo isn't a supported
+// compression algorithm.
+func ValidCompression(algo string) error {
+	switch algo {
+	case CompressNone, CompressGzip:
+		return nil
+	}
+	return fmt.Errorf("Unknown compression algorithm `%s`", algo)
+}
+
+// compress returns data compressed with algo, prefixed with algo's
+// ID.  Returns false if compressing wouldn't save any space, in which
+// case data should be stored as-is.
+func compress(data []byte, algo string) ([]byte, bool, error) {
+	if algo != CompressGzip {
+		return nil, false, ValidCompression(algo)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(compressIDGzip)
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+
+	if buf.Len() >= len(data) {
+		return nil, false, nil
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// decompress reverses compress.
+func decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, ErrInvalidCompression
+	}
+
+	switch b[0] {
+	case compressIDGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, ErrInvalidCompression
+		}
+		defer r.Close()
+
+		// Read one byte past the limit to tell if it was exceeded
+		data, err := ioutil.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+		if err != nil {
+			return nil, ErrInvalidCompression
+		}
+		if int64(len(data)) > MaxDecompressedSize {
+			return nil, ErrDecompressedTooBig
+		}
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("Unknown compression algorithm ID %d", b[0])
+}
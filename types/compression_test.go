@@ -0,0 +1,53 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("compress me "), 100)
+
+	compressed, ok, err := compress(data, CompressGzip)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, len(compressed) < len(data))
+
+	decompressed, err := decompress(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decompressed)
+
+	// Not worth compressing
+	_, ok, err = compress([]byte("x"), CompressGzip)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, err = compress(data, "zip")
+	assert.NotNil(t, err)
+
+	_, err = decompress([]byte{compressIDGzip, 'x'})
+	assert.Equal(t, ErrInvalidCompression, err)
+
+	_, err = decompress([]byte{99})
+	assert.NotNil(t, err)
+}
+
+func TestDecompressLimit(t *testing.T) {
+	defer func(max int64) { MaxDecompressedSize = max }(MaxDecompressedSize)
+	MaxDecompressedSize = 1000
+
+	compressed, _, err := compress(make([]byte, 1000), CompressGzip)
+	assert.Nil(t, err)
+	_, err = decompress(compressed)
+	assert.Nil(t, err)
+
+	compressed, _, err = compress(make([]byte, 1001), CompressGzip)
+	assert.Nil(t, err)
+	_, err = decompress(compressed)
+	assert.Equal(t, ErrDecompressedTooBig, err)
+}
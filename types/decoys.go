@@ -0,0 +1,103 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/elimisteve/fun"
+)
+
+// A Row's RandomTags can be padded with decoys, RandomTags without a
+// TagPair, to hide how many tags it really has.  Decoys are derived
+// from the Row's ID tag, which only those who can decrypt its TagPairs
+// know, and its real RandomTags.  So we can tell a Row's decoys apart
+// from RandomTags whose TagPairs we just haven't fetched yet (e.g.,
+// because another client created them), but the server can't tell
+// them apart from the real ones.
+
+// DecoyRandomTags returns n decoy RandomTags of the given length for
+// a Row with the ID tag idTag and the real RandomTags randtags.
+// Returns nil if idTag is empty, since then the decoys couldn't be
+// recognized.
+func DecoyRandomTags(idTag string, randtags []string, n, length int) []string {
+	if idTag == "" || n <= 0 {
+		return nil
+	}
+
+	sorted := make([]string, len(randtags))
+	copy(sorted, randtags)
+	sort.Strings(sorted)
+
+	var decoys []string
+	for ctr := uint64(0); len(decoys) < n; ctr++ {
+		decoy := decoyRandomTag(idTag, sorted, ctr, length)
+		// Skip the (astronomically unlikely) collisions
+		if !fun.SliceContains(randtags, decoy) && !fun.SliceContains(decoys, decoy) {
+			decoys = append(decoys, decoy)
+		}
+	}
+
+	return decoys
+}
+
+func decoyRandomTag(idTag string, sorted []string, ctr uint64, length int) string {
+	mac := hmac.New(sha256.New, []byte(idTag))
+	mac.Write([]byte("cryptag decoy\x00"))
+	mac.Write([]byte(strings.Join(sorted, "\x00")))
+
+	var ctrB [8]byte
+	binary.BigEndian.PutUint64(ctrB[:], ctr)
+	mac.Write(ctrB[:])
+
+	// Base 36 to match the alphabet of real RandomTags
+	s := new(big.Int).SetBytes(mac.Sum(nil)).Text(36)
+	for len(s) < length {
+		s = "0" + s
+	}
+	return s[len(s)-length:]
+}
+
+// notDecoys returns those of missing, the RandomTags of a Row with the
+// ID tag idTag and the real RandomTags found that have no TagPair,
+// that aren't its decoys.
+func notDecoys(missing []string, idTag string, found []string) []string {
+	if len(missing) == 0 {
+		return nil
+	}
+	length := len(missing[0])
+
+	notDecoys := without(missing, DecoyRandomTags(idTag, found, len(missing), length))
+	if len(notDecoys) <= 1 {
+		return notDecoys
+	}
+
+	// Decoys depend on all the real RandomTags, so if one of them is
+	// missing, too, find out which
+	for i, random := range missing {
+		rest := append(append([]string{}, missing[:i]...), missing[i+1:]...)
+		real := append(append([]string{}, found...), random)
+		if len(without(rest, DecoyRandomTags(idTag, real, len(rest), length))) == 0 {
+			return []string{random}
+		}
+	}
+
+	return notDecoys
+}
+
+// without returns the members of strs not in exclude.
+func without(strs, exclude []string) []string {
+	var kept []string
+	for _, s := range strs {
+		if !fun.SliceContains(exclude, s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,60 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoyRandomTags(t *testing.T) {
+	real := []string{"random000000", "random000001", "random000002"}
+
+	decoys := DecoyRandomTags("id:abc", real, 4, 12)
+	assert.Len(t, decoys, 4)
+	for _, decoy := range decoys {
+		assert.Len(t, decoy, 12)
+		assert.NotContains(t, real, decoy)
+	}
+
+	// Same decoys whatever order the real RandomTags are in
+	reordered := []string{real[2], real[0], real[1]}
+	assert.Equal(t, decoys, DecoyRandomTags("id:abc", reordered, 4, 12))
+
+	// Different ID tag or RandomTags, different decoys
+	assert.NotEqual(t, decoys, DecoyRandomTags("id:abd", real, 4, 12))
+	assert.NotEqual(t, decoys, DecoyRandomTags("id:abc", real[:2], 4, 12))
+
+	assert.Nil(t, DecoyRandomTags("", real, 4, 12))
+	assert.Nil(t, DecoyRandomTags("id:abc", real, 0, 12))
+}
+
+func TestSetPlainTagsDecoys(t *testing.T) {
+	pairs := newTestTagPairs(3)
+	pairs = append(pairs, NewTagPair(nil, "randomid0000", nil, "id:abc"))
+	real := pairs.AllRandom()
+
+	decoys := DecoyRandomTags("id:abc", real, 3, 12)
+
+	row := &Row{RandomTags: append(append([]string{}, real...), decoys...)}
+	assert.Nil(t, row.SetPlainTags(pairs))
+	assert.Equal(t, []string{"plain000000", "plain000001", "plain000002",
+		"id:abc"}, row.PlainTags())
+	assert.Equal(t, decoys, row.Decoys())
+
+	// A missing TagPair isn't mistaken for a decoy
+	row = &Row{RandomTags: append(append([]string{}, real...), decoys...)}
+	err := row.SetPlainTags(pairs[1:])
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "not found")
+	}
+
+	// Nor is a RandomTag that isn't one of this Row's decoys
+	row = &Row{RandomTags: append(append([]string{}, real...), "notadecoy000")}
+	err = row.SetPlainTags(pairs)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "RandomTag `notadecoy000` not found", err.Error())
+	}
+}
@@ -0,0 +1,319 @@
+// Steve Phillips / elimisteve
+// 2017.04.06
+
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cryptag/go-minilock/taber"
+)
+
+// Every Row's data is wrapped in an envelope before being encrypted.
+// The envelope commits to the Row's RandomTags and ID so that,
+// after decrypting, we can tell whether the (untrusted) server
+// storing the Row has attached its ciphertext to different tags, such
+// as moving the password tagged `bank` to the Row tagged `github`.
+//
+// Envelope layout (all inside the ciphertext):
+//
+//	magic (4 bytes) | version (1) | flags (1) | binding (32) |
+//	    info length (2) | info | payload
+//
+// The flags say how the payload was encoded (compressed then padded;
+// see RowOptions).  The info is the Row's ID tag, which the envelope
+// carries so that the binding can be checked without trusting the
+// TagPairs the ID tag was looked up with.  Version 1 envelopes have
+// no info length or info.  Legacy Rows have no envelope; see
+// StrictRows.
+
+const (
+	envelopeVersion = 2
+
+	flagPadded     byte = 1 << 0
+	flagCompressed byte = 1 << 1
+
+	knownFlags = flagPadded | flagCompressed
+
+	bindingLength       = sha256.Size
+	envelopeV1HeaderLen = 4 + 1 + 1 + bindingLength
+	envelopeInfoLenLen  = 2
+
+	// Small enough for the whole header of a streamed Row to fit in
+	// a bufio.Reader's default buffer
+	maxEnvelopeInfoLen = 1024
+)
+
+var envelopeMagic = []byte("\x00CTE")
+
+// RowOptions control how a Row's data is encoded before being
+// encrypted.  Decoding is automatic.
+type RowOptions struct {
+	Padding     Padding
+	Compression string // CompressNone or CompressGzip
+	Cipher      string // Name of a cryptag.Cipher; "" means cryptag.DefaultCipher
+
+	// If set, seal the Row to these public keys rather than
+	// encrypting it with the Backend's key
+	Recipients []*taber.Keys
+}
+
+// encodePayload encodes data according to opts and returns the
+// encoded payload along with the envelope flags describing it.
+func encodePayload(data []byte, opts RowOptions) ([]byte, byte, error) {
+	var flags byte
+
+	if opts.Compression != CompressNone {
+		compressed, ok, err := compress(data, opts.Compression)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			data = compressed
+			flags |= flagCompressed
+		}
+	}
+
+	if opts.Padding.Scheme != PadNone {
+		data = opts.Padding.pad(data)
+		flags |= flagPadded
+	}
+
+	return data, flags, nil
+}
+
+// decodePayload reverses encodePayload.
+func decodePayload(payload []byte, flags byte) ([]byte, error) {
+	if flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("unsupported envelope flags %#x", flags)
+	}
+
+	var err error
+
+	if flags&flagPadded != 0 {
+		if payload, err = unpad(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&flagCompressed != 0 {
+		if payload, err = decompress(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+// RowAuthError is returned when a Row's decrypted envelope doesn't
+// match the RandomTags and ID the Row is stored with, or, when
+// StrictRows is true, when a Row has no envelope at all.
+type RowAuthError struct {
+	RandomTags []string
+	Reason     string
+}
+
+func (e *RowAuthError) Error() string {
+	return fmt.Sprintf("Row with RandomTags %v failed authentication: %s",
+		e.RandomTags, e.Reason)
+}
+
+// rowBinding returns what a Row's envelope commits to: its
+// RandomTags (in any order) and its ID tag.
+func rowBinding(randomTags []string, idTag string) []byte {
+	sorted := make([]string, len(randomTags))
+	copy(sorted, randomTags)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	h.Write([]byte("cryptag row binding\x00"))
+	h.Write([]byte(idTag))
+	for _, randtag := range sorted {
+		h.Write([]byte{0})
+		h.Write([]byte(randtag))
+	}
+	return h.Sum(nil)
+}
+
+// idTag returns the first plaintag starting with "id:", or "" if
+// there is none.
+func idTag(plainTags []string) string {
+	for _, plain := range plainTags {
+		if strings.HasPrefix(plain, "id:") {
+			return plain
+		}
+	}
+	return ""
+}
+
+// envelopeHeader returns the header that goes in front of row's data
+// just before encryption.  row.RandomTags and row's plaintags must
+// already be set.
+func (row *Row) envelopeHeader(flags byte) []byte {
+	id := idTag(row.plainTags)
+	info := []byte(id)
+
+	header := make([]byte, 0, envelopeV1HeaderLen+envelopeInfoLenLen+len(info))
+	header = append(header, envelopeMagic...)
+	header = append(header, envelopeVersion, flags)
+	header = append(header, rowBinding(row.RandomTags, id)...)
+	header = append(header, byte(len(info)>>8), byte(len(info)))
+	header = append(header, info...)
+	return header
+}
+
+// openEnvelope sets row.decrypted to the payload inside the envelope
+// plain, or to plain itself if plain is a legacy, envelope-less
+// plaintext.
+func (row *Row) openEnvelope(plain []byte) error {
+	row.envelope = nil
+
+	header, err := parseEnvelopeHeader(plain)
+	if err != nil {
+		// Legacy plaintexts can start with anything, even
+		// envelopeMagic
+		row.decrypted = plain
+		return nil
+	}
+
+	data, err := decodePayload(plain[header.length:], header.flags)
+	if err != nil {
+		return fmt.Errorf("Error decoding row data: %v", err)
+	}
+
+	row.envelope = header
+	row.decrypted = data
+
+	return nil
+}
+
+type envelopeHeader struct {
+	version byte
+	flags   byte
+	binding []byte
+	idTag   string // Carried by version 2+ envelopes only
+	length  int    // Of the whole header
+}
+
+// parseEnvelopeHeader parses the envelope header at the front of b,
+// returning an error if b doesn't start with one.
+func parseEnvelopeHeader(b []byte) (*envelopeHeader, error) {
+	if !bytes.HasPrefix(b, envelopeMagic) || len(b) < envelopeV1HeaderLen {
+		return nil, fmt.Errorf("no envelope")
+	}
+
+	header := &envelopeHeader{
+		version: b[4],
+		flags:   b[5],
+		binding: append([]byte(nil), b[6:envelopeV1HeaderLen]...),
+		length:  envelopeV1HeaderLen,
+	}
+
+	switch header.version {
+	case 1:
+		return header, nil
+	case 2:
+		// Parsed below
+	default:
+		return nil, fmt.Errorf("unsupported envelope version %d", header.version)
+	}
+
+	b = b[envelopeV1HeaderLen:]
+	if len(b) < envelopeInfoLenLen {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	infoLen := int(b[0])<<8 | int(b[1])
+	b = b[envelopeInfoLenLen:]
+	if infoLen > maxEnvelopeInfoLen || len(b) < infoLen {
+		return nil, fmt.Errorf("invalid envelope info length %d", infoLen)
+	}
+
+	id := string(b[:infoLen])
+	if id != "" && !strings.HasPrefix(id, "id:") {
+		return nil, fmt.Errorf("invalid ID tag in envelope")
+	}
+
+	header.idTag = id
+	header.length += envelopeInfoLenLen + infoLen
+
+	return header, nil
+}
+
+// Verify checks that row's decrypted envelope commits to row's
+// current RandomTags and that the ID tag it carries is row's;
+// Populate calls it automatically.  Returns a *RowAuthError if not,
+// or if row is a legacy Row without an envelope and StrictRows is
+// true.
+func (row *Row) Verify() error {
+	env := row.envelope
+	if env == nil {
+		if StrictRows {
+			return &RowAuthError{row.RandomTags, "legacy row has no authenticated envelope"}
+		}
+		return nil
+	}
+
+	id := env.idTag
+	if env.version == 1 {
+		// Didn't carry the ID tag
+		id = idTag(row.plainTags)
+	}
+
+	if !hmac.Equal(env.binding, rowBinding(row.RandomTags, id)) {
+		return &RowAuthError{row.RandomTags, "data doesn't belong to these tags"}
+	}
+
+	// TagPairs are stored by the server, too
+	if row.plainTags != nil && idTag(row.plainTags) != id {
+		return &RowAuthError{row.RandomTags, "data doesn't belong to this ID tag"}
+	}
+
+	return nil
+}
+
+// ReadStreamEnvelope reads and strips the envelope header from the
+// front of r, the decrypted stream of row's data, then verifies it.
+func (row *Row) ReadStreamEnvelope(r *bufio.Reader) error {
+	row.envelope = nil
+
+	b, err := r.Peek(envelopeV1HeaderLen + envelopeInfoLenLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// Version 2+ headers are longer
+	if len(b) == envelopeV1HeaderLen+envelopeInfoLenLen && b[4] >= 2 {
+		infoLen := int(b[envelopeV1HeaderLen])<<8 | int(b[envelopeV1HeaderLen+1])
+		if infoLen <= maxEnvelopeInfoLen {
+			b, err = r.Peek(envelopeV1HeaderLen + envelopeInfoLenLen + infoLen)
+			if err != nil && err != io.EOF {
+				return err
+			}
+		}
+	}
+
+	header, err := parseEnvelopeHeader(b)
+	if err != nil {
+		// Legacy data
+		return row.Verify()
+	}
+	// Streamed data is never padded or otherwise encoded
+	if header.flags != 0 {
+		return fmt.Errorf("unsupported flags %#x on streamed row data",
+			header.flags)
+	}
+	row.envelope = header
+
+	if _, err = r.Discard(header.length); err != nil {
+		return err
+	}
+
+	return row.Verify()
+}
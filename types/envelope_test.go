@@ -0,0 +1,136 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/elimisteve/fun"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestEncryptedRow returns a new Row tagged "work" whose data has
+// been encrypted with key, along with the TagPairs for its RandomTags.
+func newTestEncryptedRow(t *testing.T, key *[32]byte, data string) (*Row, TagPairs) {
+	row, err := NewRow([]byte(data), []string{"work"})
+	if err != nil {
+		t.Fatalf("Error creating Row: %v", err)
+	}
+
+	var pairs TagPairs
+	for _, plain := range row.PlainTags() {
+		random := fun.RandomString("abcdefghijklmnopqrstuvwxyz0123456789", 9)
+		row.RandomTags = append(row.RandomTags, random)
+		pairs = append(pairs, NewTagPair(nil, random, nil, plain))
+	}
+
+	if err = row.Encrypt(key); err != nil {
+		t.Fatalf("Error encrypting Row: %v", err)
+	}
+
+	return row, pairs
+}
+
+// stored returns a copy of row's stored fields, as a server would
+// return it.
+func stored(row *Row) *Row {
+	return &Row{
+		Encrypted:  row.Encrypted,
+		RandomTags: append([]string{}, row.RandomTags...),
+		Nonce:      row.Nonce,
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	key, _ := cryptag.RandomKey()
+	row, pairs := newTestEncryptedRow(t, key, "secret")
+
+	got := stored(row)
+	assert.Nil(t, got.Populate(key, pairs))
+	assert.Equal(t, "secret", string(got.Decrypted()))
+	assert.Equal(t, row.IDTag(), got.IDTag())
+
+	// Data moved to another Row's RandomTags
+	other, otherPairs := newTestEncryptedRow(t, key, "other")
+	moved := stored(row)
+	moved.RandomTags = other.RandomTags
+	err := moved.Populate(key, otherPairs)
+	assert.IsType(t, &RowAuthError{}, err)
+
+	// TagPairs swapped so that the Row appears to have another ID
+	swapped := make(TagPairs, len(pairs))
+	for i, pair := range pairs {
+		swapped[i] = pair
+		if pair.Plain() == row.IDTag() {
+			swapped[i] = NewTagPair(nil, pair.Random, nil, other.IDTag())
+		}
+	}
+	err = stored(row).Populate(key, swapped)
+	assert.IsType(t, &RowAuthError{}, err)
+}
+
+func TestEnvelopeStream(t *testing.T) {
+	key, _ := cryptag.RandomKey()
+	row, pairs := newTestEncryptedRow(t, key, "")
+	row.Streamed = true
+
+	var buf bytes.Buffer
+	_, err := row.EncryptStream(&buf, bytes.NewReader([]byte("streamed")), key)
+	assert.Nil(t, err)
+
+	got := stored(row)
+	got.Encrypted = nil
+	got.Streamed = true
+	assert.Nil(t, got.Populate(key, pairs))
+
+	var out bytes.Buffer
+	_, err = got.DecryptStream(&out, &buf, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "streamed", out.String())
+}
+
+func TestEnvelopeLegacy(t *testing.T) {
+	key, _ := cryptag.RandomKey()
+	nonce, _ := cryptag.RandomNonce()
+
+	// Legacy data can look like the start of an envelope
+	for _, data := range []string{"plain old data", "\x00CTE", "\x00CTE\x07garbage"} {
+		enc, err := cryptag.Encrypt([]byte(data), nonce, key)
+		assert.Nil(t, err)
+
+		row := &Row{Encrypted: enc, RandomTags: []string{"r"}, Nonce: nonce}
+		err = row.Populate(key, TagPairs{NewTagPair(nil, "r", nil, "all")})
+		assert.Nil(t, err, "%q", data)
+		assert.Equal(t, data, string(row.Decrypted()))
+
+		StrictRows = true
+		assert.IsType(t, &RowAuthError{}, row.Verify())
+		StrictRows = false
+	}
+}
+
+func TestEnvelopeV1(t *testing.T) {
+	key, _ := cryptag.RandomKey()
+	row, pairs := newTestEncryptedRow(t, key, "")
+
+	// Version 1 envelopes don't carry the ID tag
+	v1 := append([]byte{}, envelopeMagic...)
+	v1 = append(v1, 1, 0)
+	v1 = append(v1, rowBinding(row.RandomTags, row.IDTag())...)
+	v1 = append(v1, "old"...)
+
+	enc, err := cryptag.Encrypt(v1, row.Nonce, key)
+	assert.Nil(t, err)
+
+	got := stored(row)
+	got.Encrypted = enc
+	assert.Nil(t, got.Populate(key, pairs))
+	assert.Equal(t, "old", string(got.Decrypted()))
+
+	br := bufio.NewReader(bytes.NewReader(v1))
+	assert.Nil(t, got.ReadStreamEnvelope(br))
+}
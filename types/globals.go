@@ -7,10 +7,17 @@ import "os"
 
 var (
 	Debug = false
+
+	// StrictRows makes Row.Verify reject legacy Rows whose data isn't
+	// wrapped in an authenticated envelope (see envelope.go)
+	StrictRows = false
 )
 
 func init() {
 	if os.Getenv("DEBUG") == "1" {
 		Debug = true
 	}
+	if os.Getenv("STRICT_ROWS") == "1" {
+		StrictRows = true
+	}
 }
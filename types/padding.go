@@ -0,0 +1,115 @@
+// Steve Phillips / elimisteve
+// 2017.04.07
+
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Padding schemes.  Padding hides the exact length of each Row's
+// data from the server storing it.
+const (
+	PadNone  = ""
+	PadPow2  = "pow2"  // Pad up to the next power of 2
+	PadBlock = "block" // Pad up to the next multiple of BlockSize
+)
+
+const (
+	// Padded data never gets shorter than this
+	minPaddedLength = 64
+
+	// Bigger blocks would mostly waste space
+	MaxPadBlockSize = 1 << 20
+
+	padLengthHeaderLen = 4
+)
+
+var (
+	ErrInvalidPadding = errors.New("Invalid padding in decrypted row data")
+)
+
+// Padding describes how Row data should be padded before encryption.
+type Padding struct {
+	Scheme    string
+	BlockSize int // Used by PadBlock
+}
+
+// ParsePadding parses s, which should be "" (no padding), "pow2", or
+// "block:N" (where N is the block size in bytes, at most
+// MaxPadBlockSize), into a Padding.
+func ParsePadding(s string) (Padding, error) {
+	switch {
+	case s == PadNone:
+		return Padding{}, nil
+	case s == PadPow2:
+		return Padding{Scheme: PadPow2}, nil
+	case strings.HasPrefix(s, PadBlock+":"):
+		size, err := strconv.Atoi(strings.TrimPrefix(s, PadBlock+":"))
+		if err != nil || size <= 0 {
+			return Padding{}, fmt.Errorf("Invalid padding block size in `%s`", s)
+		}
+		if size > MaxPadBlockSize {
+			return Padding{}, fmt.Errorf("Padding block size in `%s` is bigger"+
+				" than the maximum of %d", s, MaxPadBlockSize)
+		}
+		return Padding{Scheme: PadBlock, BlockSize: size}, nil
+	}
+	return Padding{}, fmt.Errorf("Unknown padding scheme `%s`", s)
+}
+
+func (p Padding) String() string {
+	if p.Scheme == PadBlock {
+		return fmt.Sprintf("%s:%d", PadBlock, p.BlockSize)
+	}
+	return p.Scheme
+}
+
+// paddedLength returns the total length that n bytes of data plus
+// the padding length header get padded to.
+func (p Padding) paddedLength(n int) int {
+	n += padLengthHeaderLen
+
+	switch p.Scheme {
+	case PadPow2:
+		padded := minPaddedLength
+		for padded < n {
+			padded *= 2
+		}
+		return padded
+	case PadBlock:
+		if p.BlockSize <= 0 {
+			return n
+		}
+		return (n + p.BlockSize - 1) / p.BlockSize * p.BlockSize
+	}
+
+	return n
+}
+
+// pad returns data prefixed with its length and padded with zeroes
+// according to p.
+func (p Padding) pad(data []byte) []byte {
+	padded := make([]byte, p.paddedLength(len(data)))
+	binary.BigEndian.PutUint32(padded, uint32(len(data)))
+	copy(padded[padLengthHeaderLen:], data)
+	return padded
+}
+
+// unpad reverses pad.
+func unpad(padded []byte) ([]byte, error) {
+	if len(padded) < padLengthHeaderLen {
+		return nil, ErrInvalidPadding
+	}
+
+	n := binary.BigEndian.Uint32(padded)
+	if uint64(n) > uint64(len(padded)-padLengthHeaderLen) {
+		return nil, ErrInvalidPadding
+	}
+
+	return padded[padLengthHeaderLen : padLengthHeaderLen+int(n)], nil
+}
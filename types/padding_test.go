@@ -0,0 +1,78 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type parsePaddingTest struct {
+	in    string
+	out   Padding
+	valid bool
+}
+
+var parsePaddingTests = []parsePaddingTest{
+	{"", Padding{}, true},
+	{"pow2", Padding{Scheme: PadPow2}, true},
+	{"block:256", Padding{Scheme: PadBlock, BlockSize: 256}, true},
+	{"block:1048576", Padding{Scheme: PadBlock, BlockSize: MaxPadBlockSize}, true},
+	{"block:1048577", Padding{}, false},
+	{"block:0", Padding{}, false},
+	{"block:-1", Padding{}, false},
+	{"block:", Padding{}, false},
+	{"pow3", Padding{}, false},
+}
+
+func TestParsePadding(t *testing.T) {
+	for _, test := range parsePaddingTests {
+		padding, err := ParsePadding(test.in)
+		if !test.valid {
+			assert.NotNil(t, err, test.in)
+			continue
+		}
+		assert.Nil(t, err, test.in)
+		assert.Equal(t, test.out, padding)
+		assert.Equal(t, test.in, padding.String())
+	}
+}
+
+func TestPad(t *testing.T) {
+	pow2 := Padding{Scheme: PadPow2}
+	block := Padding{Scheme: PadBlock, BlockSize: 100}
+
+	lengths := []struct {
+		padding Padding
+		n       int
+		padded  int
+	}{
+		{pow2, 0, 64},
+		{pow2, 60, 64},
+		{pow2, 61, 128},
+		{pow2, 1000, 1024},
+		{block, 0, 100},
+		{block, 96, 100},
+		{block, 97, 200},
+	}
+
+	for _, l := range lengths {
+		data := bytes.Repeat([]byte("x"), l.n)
+		padded := l.padding.pad(data)
+		assert.Equal(t, l.padded, len(padded), "%s of %d bytes", l.padding, l.n)
+
+		unpadded, err := unpad(padded)
+		assert.Nil(t, err)
+		assert.Equal(t, data, unpadded)
+	}
+
+	// Length header longer than the data
+	_, err := unpad([]byte{0, 0, 1, 0, 'x'})
+	assert.Equal(t, ErrInvalidPadding, err)
+
+	_, err = unpad([]byte{0, 0})
+	assert.Equal(t, ErrInvalidPadding, err)
+}
@@ -0,0 +1,110 @@
+// Steve Phillips / elimisteve
+// 2017.04.18
+
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// PageOptions select one page of the Rows matching a query, so that
+// listing a huge Backend doesn't mean downloading all of it.  The zero
+// value selects every matching Row, in no particular order.
+type PageOptions struct {
+	Limit  int    // Max number of Rows to return; 0 means no limit
+	Offset int    // Number of matching Rows to skip first
+	Order  string // OrderNone, OrderOldest, or OrderNewest
+}
+
+// Orders that Rows can be returned in.  Backends that page Rows
+// themselves (see backend.PagingBackend) can't read their "created:"
+// tags, so order them by when they were first saved under their
+// current RandomTags instead; Rows paged locally (see
+// backend.PageRows) are ordered by their "created:" tags.  The two
+// orders agree except for Rows that were saved long after they were
+// created (e.g., imported) or moved to new RandomTags (e.g., by
+// backend.MergeTag or backend.MigrateToBlindTags), which Backends put
+// last.
+const (
+	OrderNone   = ""
+	OrderOldest = "oldest"
+	OrderNewest = "newest"
+)
+
+var (
+	ErrInvalidOrder = errors.New(`Order must be "oldest" or "newest"`)
+)
+
+// IsZero reports whether opts selects every matching Row.
+func (opts PageOptions) IsZero() bool {
+	return opts == PageOptions{}
+}
+
+// Valid returns an error if any of opts' values are invalid.
+func (opts PageOptions) Valid() error {
+	if opts.Limit < 0 {
+		return fmt.Errorf("Invalid limit %d", opts.Limit)
+	}
+	if opts.Offset < 0 {
+		return fmt.Errorf("Invalid offset %d", opts.Offset)
+	}
+	if opts.Order != OrderNone && opts.Order != OrderOldest &&
+		opts.Order != OrderNewest {
+		return ErrInvalidOrder
+	}
+	return nil
+}
+
+// Bounds returns the indexes of the first and (one past the) last of
+// n matching Rows that opts selects.
+func (opts PageOptions) Bounds(n int) (start, end int) {
+	start = opts.Offset
+	if start > n {
+		start = n
+	}
+	end = n
+	if opts.Limit > 0 && start+opts.Limit < n {
+		end = start + opts.Limit
+	}
+	return start, end
+}
+
+// Values returns opts as URL query parameters ("limit", "offset",
+// and "order"), omitting zero values.
+func (opts PageOptions) Values() url.Values {
+	v := url.Values{}
+	if opts.Limit != 0 {
+		v.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset != 0 {
+		v.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.Order != OrderNone {
+		v.Set("order", opts.Order)
+	}
+	return v
+}
+
+// ParsePageOptions parses the URL query parameters that
+// PageOptions.Values returns.
+func ParsePageOptions(v url.Values) (PageOptions, error) {
+	var opts PageOptions
+	var err error
+
+	if s := v.Get("limit"); s != "" {
+		if opts.Limit, err = strconv.Atoi(s); err != nil {
+			return PageOptions{}, fmt.Errorf("Invalid limit `%s`", s)
+		}
+	}
+	if s := v.Get("offset"); s != "" {
+		if opts.Offset, err = strconv.Atoi(s); err != nil {
+			return PageOptions{}, fmt.Errorf("Invalid offset `%s`", s)
+		}
+	}
+	opts.Order = v.Get("order")
+
+	return opts, opts.Valid()
+}
@@ -0,0 +1,243 @@
+// Steve Phillips / elimisteve
+// 2017.04.12
+
+package types
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/go-minilock/taber"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// A Row can be sealed to a list of recipients' Curve25519 public keys
+// (e.g., miniLock IDs) rather than to its Backend's key, so that only
+// those recipients can read its data.  A random per-Row data key
+// encrypts the Row's data as usual, and is wrapped to each recipient
+// with an ephemeral sender key so that recipients aren't identified.
+// Layout of such a Row's Encrypted field:
+//
+//	magic (4 bytes) | version (1) | recipient count (2) |
+//	  count * [ephemeral public key (32) | nonce (24) | wrapped data key (48)] |
+//	  data encrypted with the data key
+//
+// The Row's TagPairs are still encrypted with the Backend's key.
+
+const (
+	recipientsVersion = 1
+
+	recipientsHeaderLen = 4 + 1 + 2
+	wrappedKeyLen       = 32 + 24 + 32 + box.Overhead
+
+	// Max recipients per Row
+	MaxRecipients = 1024
+)
+
+var recipientsMagic = []byte("\x00CTR")
+
+var (
+	ErrNoRecipients      = errors.New("No recipients given")
+	ErrTooManyRecipients = fmt.Errorf("Rows can have at most %d recipients",
+		MaxRecipients)
+	ErrNotARecipient    = errors.New("Row not sealed to any of this user's identities")
+	ErrInvalidRecipient = errors.New("Recipient has no valid public key")
+)
+
+// identities are the keypairs whose private keys can open Rows sealed
+// to them; see AddIdentity.
+var identities struct {
+	mu   sync.RWMutex
+	keys []*taber.Keys
+}
+
+// AddIdentity makes the private key in keys available to Row.Decrypt
+// (and thus Populate) for opening Rows sealed to keys' public key.
+func AddIdentity(keys *taber.Keys) error {
+	if !keys.HasPrivate() || !keys.HasPublic() {
+		return errors.New("Identity must have a public and private key")
+	}
+
+	identities.mu.Lock()
+	defer identities.mu.Unlock()
+
+	identities.keys = append(identities.keys, keys)
+	return nil
+}
+
+func getIdentities() []*taber.Keys {
+	identities.mu.RLock()
+	defer identities.mu.RUnlock()
+
+	return append([]*taber.Keys(nil), identities.keys...)
+}
+
+// HasRecipients reports whether row's data is sealed to recipients
+// rather than encrypted with its Backend's key.
+func (row *Row) HasRecipients() bool {
+	return bytes.HasPrefix(row.Encrypted, recipientsMagic)
+}
+
+// sealToRecipients sets row.Encrypted to plain encrypted with a new,
+// random data key, prefixed with the data key wrapped to each of
+// recipients.
+func (row *Row) sealToRecipients(plain []byte, cipherName string, recipients []*taber.Keys) error {
+	if len(recipients) == 0 {
+		return ErrNoRecipients
+	}
+	if len(recipients) > MaxRecipients {
+		return ErrTooManyRecipients
+	}
+
+	dataKey, err := cryptag.RandomKey()
+	if err != nil {
+		return err
+	}
+
+	enc, err := cryptag.EncryptWithCipher(cipherName, plain, row.Nonce, dataKey)
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, recipientsHeaderLen,
+		recipientsHeaderLen+len(recipients)*wrappedKeyLen+len(enc))
+	copy(sealed, recipientsMagic)
+	sealed[4] = recipientsVersion
+	binary.BigEndian.PutUint16(sealed[5:], uint16(len(recipients)))
+
+	for _, recip := range recipients {
+		if !recip.HasPublic() {
+			return ErrInvalidRecipient
+		}
+
+		ephPub, ephPriv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		nonce, err := cryptag.RandomNonce()
+		if err != nil {
+			return err
+		}
+
+		sealed = append(sealed, ephPub[:]...)
+		sealed = append(sealed, nonce[:]...)
+		sealed = box.Seal(sealed, dataKey[:], nonce, recip.PublicArray(), ephPriv)
+	}
+
+	row.Encrypted = append(sealed, enc...)
+
+	return nil
+}
+
+// openAsRecipient decrypts row.Encrypted, which must be sealed to
+// recipients, with the first of this user's identities that it's
+// sealed to.
+func (row *Row) openAsRecipient() ([]byte, error) {
+	header, dataKey, err := row.unwrapDataKey()
+	if err != nil {
+		return nil, err
+	}
+	return cryptag.DecryptVersioned(row.Encrypted[len(header):], row.Nonce, dataKey)
+}
+
+// EncryptForSameRecipients is like EncryptWithOptions but seals row
+// to the same recipients, with the same data key, as old, which must
+// be sealed to recipients that include one of this user's identities.
+// opts.Recipients is ignored.  Useful for moving a Row to new
+// RandomTags.
+func (row *Row) EncryptForSameRecipients(old *Row, opts RowOptions) error {
+	header, dataKey, err := old.unwrapDataKey()
+	if err != nil {
+		return err
+	}
+
+	payload, flags, err := encodePayload(row.decrypted, opts)
+	if err != nil {
+		return err
+	}
+	plain := append(row.envelopeHeader(flags), payload...)
+
+	enc, err := cryptag.EncryptWithCipher(opts.Cipher, plain, row.Nonce, dataKey)
+	if err != nil {
+		return err
+	}
+
+	row.Encrypted = append(append([]byte(nil), header...), enc...)
+
+	return nil
+}
+
+// unwrapDataKey returns the recipients header of row.Encrypted (which
+// must be sealed to recipients) and the data key, unwrapped with the
+// first of this user's identities that it's wrapped to.
+func (row *Row) unwrapDataKey() (header []byte, dataKey *[32]byte, err error) {
+	b := row.Encrypted
+	if len(b) < recipientsHeaderLen {
+		return nil, nil, fmt.Errorf("Recipients header too short")
+	}
+	if b[4] != recipientsVersion {
+		return nil, nil, fmt.Errorf("Unsupported recipients format version %d", b[4])
+	}
+
+	count := int(binary.BigEndian.Uint16(b[5:]))
+	if count > MaxRecipients || len(b) < recipientsHeaderLen+count*wrappedKeyLen {
+		return nil, nil, fmt.Errorf("Invalid recipients header")
+	}
+
+	header = b[:recipientsHeaderLen+count*wrappedKeyLen]
+	wrapped := header[recipientsHeaderLen:]
+
+	for _, id := range getIdentities() {
+		priv := id.PrivateArray()
+
+		for i := 0; i < count; i++ {
+			w := wrapped[i*wrappedKeyLen : (i+1)*wrappedKeyLen]
+
+			var ephPub [32]byte
+			var nonce [24]byte
+			copy(ephPub[:], w[:32])
+			copy(nonce[:], w[32:56])
+
+			keyB, ok := box.Open(nil, w[56:], &nonce, &ephPub, priv)
+			if !ok {
+				continue
+			}
+
+			dataKey, err = cryptag.ConvertKey(keyB)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return header, dataKey, nil
+		}
+	}
+
+	return nil, nil, ErrNotARecipient
+}
+
+// IsRecipient reports whether row can be opened with one of this
+// user's identities.  Always true for Rows not sealed to recipients.
+func (row *Row) IsRecipient() bool {
+	if !row.HasRecipients() {
+		return true
+	}
+	_, _, err := row.unwrapDataKey()
+	return err == nil
+}
+
+// Readable returns the Rows in rows that aren't sealed to other
+// users; see IsRecipient.
+func (rows Rows) Readable() Rows {
+	readable := make(Rows, 0, len(rows))
+	for _, row := range rows {
+		if row.IsRecipient() {
+			readable = append(readable, row)
+		}
+	}
+	return readable
+}
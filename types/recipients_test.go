@@ -0,0 +1,78 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"testing"
+
+	"github.com/cryptag/cryptag"
+	"github.com/cryptag/go-minilock/taber"
+	"github.com/stretchr/testify/assert"
+)
+
+// useOnlyIdentity makes keys this user's only identity until the end
+// of the test.
+func useOnlyIdentity(t *testing.T, keys *taber.Keys) {
+	identities.mu.Lock()
+	orig := identities.keys
+	identities.keys = []*taber.Keys{keys}
+	identities.mu.Unlock()
+
+	t.Cleanup(func() {
+		identities.mu.Lock()
+		identities.keys = orig
+		identities.mu.Unlock()
+	})
+}
+
+func newTestIdentity(t *testing.T) *taber.Keys {
+	keys, err := taber.RandomKey()
+	if err != nil {
+		t.Fatalf("Error generating keys: %v", err)
+	}
+	return keys
+}
+
+func TestRecipients(t *testing.T) {
+	alice, bob, carol := newTestIdentity(t), newTestIdentity(t), newTestIdentity(t)
+
+	key, _ := cryptag.RandomKey()
+
+	// Re-encrypted, sealed to alice and bob
+	row, pairs := newTestEncryptedRow(t, key, "for alice and bob")
+	opts := RowOptions{Recipients: []*taber.Keys{alice, bob}}
+	if err := row.EncryptWithOptions(nil, opts); err != nil {
+		t.Fatalf("Error sealing Row: %v", err)
+	}
+	assert.True(t, row.HasRecipients())
+
+	for name, keys := range map[string]*taber.Keys{"alice": alice, "bob": bob} {
+		t.Run(name, func(t *testing.T) {
+			useOnlyIdentity(t, keys)
+
+			got := stored(row)
+			assert.True(t, got.IsRecipient())
+			assert.Equal(t, Rows{got}, Rows{got}.Readable())
+			if assert.Nil(t, got.Populate(nil, pairs)) {
+				assert.Equal(t, "for alice and bob", string(got.Decrypted()))
+			}
+		})
+	}
+
+	useOnlyIdentity(t, carol)
+
+	got := stored(row)
+	assert.False(t, got.IsRecipient())
+	assert.Equal(t, 0, len(Rows{got}.Readable()))
+
+	_, err := got.openAsRecipient()
+	assert.Equal(t, ErrNotARecipient, err)
+	assert.NotNil(t, got.Populate(nil, pairs))
+	assert.Nil(t, got.Decrypted())
+
+	// Rows not sealed to recipients are always readable
+	plain, _ := newTestEncryptedRow(t, key, "for anyone with the key")
+	assert.True(t, plain.IsRecipient())
+	assert.Equal(t, Rows{plain}, Rows{got, plain}.Readable())
+}
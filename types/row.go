@@ -4,12 +4,17 @@
 package types
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"time"
 
 	"github.com/cryptag/cryptag"
+	"github.com/cryptag/cryptag/tagschema"
 	"github.com/elimisteve/fun"
 	uuid "github.com/nu7hatch/gouuid"
 )
@@ -36,14 +41,33 @@ type Row struct {
 	Encrypted  []byte   `json:"data"`
 	RandomTags []string `json:"tags"`
 
+	// Streamed is true when this Row's encrypted data is too large to
+	// keep in Encrypted and is instead stored as a separate encrypted
+	// stream (see EncryptStream and DecryptStream)
+	Streamed bool `json:"streamed,omitempty"`
+
+	// KeyID identifies the key this Row's data was encrypted with
+	// (see cryptag.KeyID); empty for legacy Rows
+	KeyID string `json:"key_id,omitempty"`
+
+	// Signer is the Ed25519 public key of whoever signed this Row,
+	// and Signature their signature over it; both empty for
+	// unsigned Rows (see Sign)
+	Signer    []byte `json:"signer,omitempty"`
+	Signature []byte `json:"sig,omitempty"`
+
 	// Populated locally
-	decrypted []byte
-	plainTags []string
-	Nonce     *[24]byte `json:"nonce"`
+	decrypted         []byte
+	plainTags         []string
+	decoys            []string        // RandomTags without TagPairs; see SetPlainTags
+	envelope          *envelopeHeader // From decrypted data; see Verify
+	signatureVerified bool            // See VerifySignature
+	Nonce             *[24]byte       `json:"nonce"`
 }
 
 var (
-	ErrRowsNotFound = errors.New("No rows found")
+	ErrRowsNotFound   = errors.New("No rows found")
+	ErrRowNotStreamed = errors.New("Row data is not stored as a stream")
 )
 
 // NewRow returns a *Row containing/tagged with the passed-in
@@ -51,9 +75,8 @@ var (
 // tag ("created:created:20170105092731"), and the "all" tag.  The
 // *Row returned also contains and a new, random, cryptographic nonce.
 func NewRow(decrypted []byte, plainTags []string) (*Row, error) {
-	// TODO: Ensure len(decrypted) < 2GB
-
-	// TODO: Ensure that len(decrypted) < 2GB(?)
+	// TODO: Ensure that len(decrypted) < 2GB(?) Larger data should
+	// be encrypted with row.EncryptStream instead.
 
 	id, err := uuid.NewV4()
 	if err != nil {
@@ -69,7 +92,9 @@ func NewRow(decrypted []byte, plainTags []string) (*Row, error) {
 	// size := "size:" + fmt.Sprintf("%d", len(decrypted))
 	// approxsize := "approxsize:" + RowSizeCategory(len(decrypted))
 
-	// For future queryability-related reasons, UUID must come first!
+	// (RandomTags are shuffled before saving, so this order doesn't
+	// reveal which RandomTag is the ID tag's; see
+	// backend.PopulateRowBeforeSave)
 	plainTags = append([]string{uuidTag}, append(plainTags, created, "all")...)
 
 	nonce, err := cryptag.RandomNonce()
@@ -77,8 +102,6 @@ func NewRow(decrypted []byte, plainTags []string) (*Row, error) {
 		return nil, err
 	}
 
-	// TODO(elimisteve): Randomize plainTags[1:len(plainTags)-1] here
-
 	row := &Row{decrypted: decrypted, plainTags: plainTags, Nonce: nonce}
 
 	return row, nil
@@ -121,6 +144,12 @@ func (row *Row) PlainTags() []string {
 	return row.plainTags
 }
 
+// Decoys returns row's decoy RandomTags (see DecoyRandomTags), which
+// are known once row's plain tags have been set.
+func (row *Row) Decoys() []string {
+	return row.decoys
+}
+
 // HasRandomTag answers the question, "does row have the random tag randtag?"
 func (row *Row) HasRandomTag(randtag string) bool {
 	return fun.SliceContains(row.RandomTags, randtag)
@@ -131,9 +160,40 @@ func (row *Row) HasPlainTag(plain string) bool {
 	return fun.SliceContains(row.plainTags, plain)
 }
 
+// ID returns row's unique ID (the UUID in its "id:..." tag), or "" if
+// row has none or hasn't been populated.
+func (row *Row) ID() string {
+	return tagschema.Value(row.plainTags, "id")
+}
+
+// IDTag returns row's "id:..." tag, or "" if row has none or hasn't
+// been populated.
+func (row *Row) IDTag() string {
+	return idTag(row.plainTags)
+}
+
+// Created returns when row was created according to its "created:..."
+// tag, or the zero time if row has no valid one.
+func (row *Row) Created() time.Time {
+	tag, ok := tagschema.Find(row.plainTags, "created")
+	if !ok {
+		return time.Time{}
+	}
+	created, err := tag.Time()
+	if err != nil {
+		if Debug {
+			log.Printf("Error parsing Row's created time: %v\n", err)
+		}
+		return time.Time{}
+	}
+	return created
+}
+
 // Decrypt sets row.decrypted, row.nonce based upon row.Encrypted,
 // nonce.  The passed-in `decrypt` function will typically be
-// bkend.Decrypt, where `bkend` is the backend storing this Row.
+// bkend.Decrypt, where `bkend` is the backend storing this Row.  If
+// row is sealed to recipients, key is ignored and row is opened with
+// an identity added with AddIdentity instead.
 func (row *Row) Decrypt(key *[32]byte) error {
 	if len(row.Encrypted) == 0 {
 		if Debug {
@@ -142,6 +202,14 @@ func (row *Row) Decrypt(key *[32]byte) error {
 		return nil
 	}
 
+	if row.HasRecipients() {
+		dec, err := row.openAsRecipient()
+		if err != nil {
+			return fmt.Errorf("Error decrypting: %v", err)
+		}
+		return row.openEnvelope(dec)
+	}
+
 	if key == nil {
 		if Debug {
 			log.Printf("nil key passed to row.Decrypt for row `%#v`\n", row)
@@ -149,24 +217,149 @@ func (row *Row) Decrypt(key *[32]byte) error {
 		return cryptag.ErrNilKey
 	}
 
-	dec, err := cryptag.Decrypt(row.Encrypted, row.Nonce, key)
+	dec, err := cryptag.DecryptVersioned(row.Encrypted, row.Nonce, key)
 	if err != nil {
 		return fmt.Errorf("Error decrypting: %v", err)
 	}
 
-	row.decrypted = dec
+	return row.openEnvelope(dec)
+}
+
+// Encrypt sets row.Encrypted to row's decrypted data, wrapped in an
+// envelope that commits to row.RandomTags and row's ID tag, then
+// encrypted with key and row.Nonce.  row.RandomTags must already be
+// set.
+func (row *Row) Encrypt(key *[32]byte) error {
+	return row.EncryptWithOptions(key, RowOptions{})
+}
+
+// EncryptWithOptions is like Encrypt but first encodes row's data
+// (e.g., compresses and pads it) and picks the cipher according to
+// opts.
+func (row *Row) EncryptWithOptions(key *[32]byte, opts RowOptions) error {
+	payload, flags, err := encodePayload(row.decrypted, opts)
+	if err != nil {
+		return err
+	}
+	plain := append(row.envelopeHeader(flags), payload...)
+
+	if len(opts.Recipients) > 0 {
+		return row.sealToRecipients(plain, opts.Cipher, opts.Recipients)
+	}
+
+	enc, err := cryptag.EncryptWithCipher(opts.Cipher, plain, row.Nonce, key)
+	if err != nil {
+		return err
+	}
+
+	row.Encrypted = enc
 
 	return nil
 }
 
-// SetPlainTags uses row.RandomTags and pairs to set row.plainTags
-func (row *Row) SetPlainTags(pairs TagPairs) error {
-	matches, err := pairs.WithAllRandomTags(row.RandomTags)
+// DecryptWithKeyRing is like Decrypt but decrypts with the key in ring
+// that row.KeyID refers to, or with each key in ring (newest first)
+// if row.KeyID isn't set.
+func (row *Row) DecryptWithKeyRing(ring cryptag.KeyRing) error {
+	if len(row.Encrypted) == 0 {
+		return nil
+	}
+
+	if row.HasRecipients() {
+		return row.Decrypt(nil)
+	}
+
+	keys, err := ring.Candidates(row.KeyID)
 	if err != nil {
 		return err
 	}
 
-	row.plainTags = matches.AllPlain()
+	for _, key := range keys {
+		if err = row.Decrypt(key); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// EncryptStream encrypts the plaintext read from src using key and
+// row.Nonce and writes the resulting encrypted stream to dst.  Useful
+// for data too large to fit in memory.  As with Encrypt, row.RandomTags
+// must already be set, as should row.Streamed.  row isn't modified, so
+// it can be saved (e.g., by backend.StreamBackend.SaveRowStream) while
+// its data is being encrypted.
+func (row *Row) EncryptStream(dst io.Writer, src io.Reader, key *[32]byte) (int64, error) {
+	header := row.envelopeHeader(0)
+	src = io.MultiReader(bytes.NewReader(header), src)
+
+	n, err := cryptag.EncryptStream(dst, src, row.Nonce, key)
+	if n >= int64(len(header)) {
+		n -= int64(len(header))
+	}
+	return n, err
+}
+
+// DecryptStream decrypts the encrypted stream read from src (which
+// should contain this Row's data, as written by row.EncryptStream)
+// and writes the plaintext to dst.
+func (row *Row) DecryptStream(dst io.Writer, src io.Reader, key *[32]byte) (int64, error) {
+	if !row.Streamed {
+		return 0, ErrRowNotStreamed
+	}
+
+	dec, err := cryptag.NewDecryptReader(src, row.Nonce, key)
+	if err != nil {
+		return 0, err
+	}
+
+	br := bufio.NewReader(dec)
+	if err = row.ReadStreamEnvelope(br); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(dst, br)
+}
+
+// SetPlainTags uses row.RandomTags and pairs to set row.plainTags.
+// row's decoy RandomTags (see DecoyRandomTags) are skipped; any other
+// RandomTag without a TagPair in pairs is an error.
+func (row *Row) SetPlainTags(pairs TagPairs) error {
+	if idx, ok := snapshotIndex(pairs); ok {
+		return row.SetPlainTagsFromIndex(idx)
+	}
+
+	// Only index the TagPairs row needs
+	idx := NewTagPairIndex(nil)
+	for _, pair := range pairs {
+		if row.HasRandomTag(pair.Random) {
+			idx.Add(pair)
+		}
+	}
+	return row.SetPlainTagsFromIndex(idx)
+}
+
+// SetPlainTagsFromIndex is like SetPlainTags but looks up TagPairs in
+// idx.
+func (row *Row) SetPlainTagsFromIndex(idx *TagPairIndex) error {
+	plainTags := make([]string, 0, len(row.RandomTags))
+	var found, missing []string
+	for _, random := range row.RandomTags {
+		pair, ok := idx.ByRandom(random)
+		if !ok {
+			missing = append(missing, random)
+			continue
+		}
+		plainTags = append(plainTags, pair.plain)
+		found = append(found, random)
+	}
+
+	if notFound := notDecoys(missing, idTag(plainTags), found); len(notFound) > 0 {
+		return fmt.Errorf("RandomTag `%s` not found", notFound[0])
+	}
+
+	row.decoys = missing
+	row.plainTags = plainTags
 
 	if Debug {
 		log.Printf("row.plainTags set to `%#v`\n", row.plainTags)
@@ -177,13 +370,34 @@ func (row *Row) SetPlainTags(pairs TagPairs) error {
 
 // Populate sets row.decrypted based on row.Encrypted and
 // row.plainTags based on row.RandomTags, thereby populating row with
-// plaintext data.
+// plaintext data, then verifies row's signature, if any (see
+// VerifySignature), and that row's data belongs with its RandomTags
+// (see Verify).
 func (row *Row) Populate(key *[32]byte, pairs TagPairs) error {
-	if err := row.Decrypt(key); err != nil {
+	return Rows{row}.Populate(key, pairs)
+}
+
+// PopulateWithKeyRing is like Populate but decrypts row.Encrypted
+// using DecryptWithKeyRing.
+func (row *Row) PopulateWithKeyRing(ring cryptag.KeyRing, pairs TagPairs) error {
+	return Rows{row}.PopulateWithKeyRing(ring, pairs)
+}
+
+func (row *Row) populate(decrypt func() error, idx *TagPairIndex) error {
+	if err := row.VerifySignature(); err != nil {
+		return err
+	}
+	if err := decrypt(); err != nil {
 		return fmt.Errorf("Error decrypting row: %v", err)
 	}
-	if err := row.SetPlainTags(pairs); err != nil {
+	if err := row.SetPlainTagsFromIndex(idx); err != nil {
 		return fmt.Errorf("Error setting row's plain tags: %v", err)
 	}
+	// Streamed Rows are verified as their data is read
+	if len(row.Encrypted) > 0 {
+		if err := row.Verify(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
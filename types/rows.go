@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"sort"
+
+	"github.com/cryptag/cryptag"
 )
 
 type Rows []*Row
@@ -40,17 +42,55 @@ func (rows Rows) WithAllRandomTags(random []string) Rows {
 	return matches
 }
 
+// Populate populates each of rows (see Row.Populate), indexing the
+// TagPairs they need just once.
 func (rows Rows) Populate(key *[32]byte, pairs TagPairs) error {
 	// TODO: Benchmark whether parallelizing would increase
 	// performance
-	for i := range rows {
-		if err := rows[i].Populate(key, pairs); err != nil {
+	idx := rows.indexTagPairs(pairs)
+	for _, row := range rows {
+		if err := row.populate(func() error { return row.Decrypt(key) }, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PopulateWithKeyRing is like Populate but decrypts each Row using
+// DecryptWithKeyRing.
+func (rows Rows) PopulateWithKeyRing(ring cryptag.KeyRing, pairs TagPairs) error {
+	idx := rows.indexTagPairs(pairs)
+	for _, row := range rows {
+		if err := row.populate(func() error { return row.DecryptWithKeyRing(ring) }, idx); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// indexTagPairs indexes the TagPairs in pairs that rows have.
+func (rows Rows) indexTagPairs(pairs TagPairs) *TagPairIndex {
+	// Already indexed
+	if idx, ok := snapshotIndex(pairs); ok {
+		return idx
+	}
+
+	randtags := map[string]bool{}
+	for _, row := range rows {
+		for _, random := range row.RandomTags {
+			randtags[random] = true
+		}
+	}
+
+	idx := NewTagPairIndex(nil)
+	for _, pair := range pairs {
+		if randtags[pair.Random] {
+			idx.Add(pair)
+		}
+	}
+	return idx
+}
+
 func (rows Rows) Sort(less func(r1, r2 *Row) bool) {
 	rs := rowSorter{rows, less}
 	sort.Sort(rs)
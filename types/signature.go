@@ -0,0 +1,111 @@
+// Steve Phillips / elimisteve
+// 2017.04.14
+
+package types
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Anyone holding a Backend's key can write Rows to it, so a Row can
+// optionally be signed by its author's Ed25519 key.  The signature
+// covers the Row's nonce, KeyID, RandomTags (in sorted order), and
+// ciphertext, so it breaks if any of those are changed, including by
+// re-encrypting the Row (e.g., during key rotation).
+//
+// A verified signature proves which key wrote a Row, not who owns
+// that key; compare SignerID with IDs you trust.
+
+const signatureContext = "cryptag row signature v1\x00"
+
+var (
+	ErrBadSignature = errors.New("Row has an invalid signature")
+	ErrNotSignable  = errors.New("Only Rows with Encrypted data can be signed")
+)
+
+// Sign signs row with priv.  row.Encrypted and row.RandomTags must
+// already be set, and must not change afterward.
+func (row *Row) Sign(priv ed25519.PrivateKey) error {
+	if len(row.Encrypted) == 0 {
+		return ErrNotSignable
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return errors.New("Invalid Ed25519 private key")
+	}
+
+	row.Signer = priv.Public().(ed25519.PublicKey)
+	row.Signature = ed25519.Sign(priv, row.signedMessage())
+	row.signatureVerified = true
+
+	return nil
+}
+
+// IsSigned reports whether row claims to be signed.  Use
+// VerifySignature (or Populate) to check the claim.
+func (row *Row) IsSigned() bool {
+	return len(row.Signature) > 0 || len(row.Signer) > 0
+}
+
+// VerifySignature returns ErrBadSignature if row is signed but its
+// signature isn't valid.  Returns nil for unsigned Rows.
+func (row *Row) VerifySignature() error {
+	row.signatureVerified = false
+
+	if !row.IsSigned() {
+		return nil
+	}
+	if len(row.Signer) != ed25519.PublicKeySize ||
+		len(row.Signature) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	if !ed25519.Verify(ed25519.PublicKey(row.Signer), row.signedMessage(), row.Signature) {
+		return ErrBadSignature
+	}
+
+	row.signatureVerified = true
+	return nil
+}
+
+// Author returns the ID of the key that signed row (see SignerID),
+// and whether that signature has been verified.  id is "" if row
+// isn't signed.
+func (row *Row) Author() (id string, verified bool) {
+	if !row.IsSigned() {
+		return "", false
+	}
+	return SignerID(row.Signer), row.signatureVerified
+}
+
+// SignerID returns the human-readable ID of the Ed25519 public key
+// pub.
+func SignerID(pub ed25519.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(pub)
+}
+
+func (row *Row) signedMessage() []byte {
+	randtags := make([]string, len(row.RandomTags))
+	copy(randtags, row.RandomTags)
+	sort.Strings(randtags)
+
+	msg := []byte(signatureContext)
+	if row.Nonce != nil {
+		msg = append(msg, row.Nonce[:]...)
+	}
+	msg = appendLengthPrefixed(msg, []byte(row.KeyID))
+
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(randtags)))
+	for _, randtag := range randtags {
+		msg = appendLengthPrefixed(msg, []byte(randtag))
+	}
+
+	return appendLengthPrefixed(msg, row.Encrypted)
+}
+
+func appendLengthPrefixed(dst, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(b)))
+	return append(dst, b...)
+}
@@ -19,6 +19,10 @@ type TagPair struct {
 	Random         string    `json:"random"`
 	Nonce          *[24]byte `json:"nonce"`
 
+	// KeyID identifies the key PlainEncrypted was encrypted with (see
+	// cryptag.KeyID); empty for legacy TagPairs
+	KeyID string `json:"key_id,omitempty"`
+
 	plain string
 }
 
@@ -37,7 +41,7 @@ func (pair *TagPair) Plain() string {
 
 // Decrypt sets pair.plain based off of pair.PlainEncrypted
 func (pair *TagPair) Decrypt(key *[32]byte) error {
-	plain, err := cryptag.Decrypt(pair.PlainEncrypted, pair.Nonce, key)
+	plain, err := cryptag.DecryptVersioned(pair.PlainEncrypted, pair.Nonce, key)
 	if err != nil {
 		return fmt.Errorf("Error decrypting plain tag `%s` (%v): %v",
 			pair.PlainEncrypted, pair.PlainEncrypted, err)
@@ -47,3 +51,21 @@ func (pair *TagPair) Decrypt(key *[32]byte) error {
 
 	return nil
 }
+
+// DecryptWithKeyRing sets pair.plain based off of pair.PlainEncrypted,
+// decrypting with the key in ring that pair.KeyID refers to, or with
+// each key in ring (newest first) if pair.KeyID isn't set.
+func (pair *TagPair) DecryptWithKeyRing(ring cryptag.KeyRing) error {
+	keys, err := ring.Candidates(pair.KeyID)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = pair.Decrypt(key); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
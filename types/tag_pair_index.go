@@ -0,0 +1,229 @@
+// Steve Phillips / elimisteve
+// 2017.04.17
+
+package types
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TagPairIndex is a collection of TagPairs indexed by PlainTag and by
+// RandomTag, so that looking up tags is O(1) rather than a scan of
+// every TagPair.  Build one with NewTagPairIndex and keep it up to
+// date with Add and Remove.  Lookups are safe for concurrent use, but
+// changing a TagPairIndex while it's in use isn't.
+type TagPairIndex struct {
+	pairs    TagPairs
+	byPlain  map[string]TagPairs // In the order they were added
+	byRandom map[string]*TagPair
+}
+
+// NewTagPairIndex returns a TagPairIndex containing pairs.
+func NewTagPairIndex(pairs TagPairs) *TagPairIndex {
+	idx := &TagPairIndex{
+		pairs:    make(TagPairs, 0, len(pairs)),
+		byPlain:  make(map[string]TagPairs, len(pairs)),
+		byRandom: make(map[string]*TagPair, len(pairs)),
+	}
+	idx.Add(pairs...)
+	return idx
+}
+
+// Add adds each of pairs to idx, skipping those whose RandomTag idx
+// already has.
+func (idx *TagPairIndex) Add(pairs ...*TagPair) {
+	for _, pair := range pairs {
+		if _, exists := idx.byRandom[pair.Random]; exists {
+			continue
+		}
+		idx.pairs = append(idx.pairs, pair)
+		idx.byPlain[pair.plain] = append(idx.byPlain[pair.plain], pair)
+		idx.byRandom[pair.Random] = pair
+	}
+}
+
+// Remove removes the TagPairs with the given RandomTags from idx.
+func (idx *TagPairIndex) Remove(randtags ...string) {
+	removed := 0
+	for _, random := range randtags {
+		pair, ok := idx.byRandom[random]
+		if !ok {
+			continue
+		}
+		delete(idx.byRandom, random)
+
+		var samePlain TagPairs
+		for _, p := range idx.byPlain[pair.plain] {
+			if p != pair {
+				samePlain = append(samePlain, p)
+			}
+		}
+		if len(samePlain) == 0 {
+			delete(idx.byPlain, pair.plain)
+		} else {
+			idx.byPlain[pair.plain] = samePlain
+		}
+		removed++
+	}
+	if removed == 0 {
+		return
+	}
+
+	kept := make(TagPairs, 0, len(idx.pairs)-removed)
+	for _, pair := range idx.pairs {
+		if _, ok := idx.byRandom[pair.Random]; ok {
+			kept = append(kept, pair)
+		}
+	}
+	idx.pairs = kept
+}
+
+// Pairs returns the TagPairs in idx, in the order they were added.
+// Appending to the returned slice won't affect idx.
+func (idx *TagPairIndex) Pairs() TagPairs {
+	return idx.pairs[:len(idx.pairs):len(idx.pairs)]
+}
+
+// Clone returns a copy of idx that can be changed without changing
+// idx.
+func (idx *TagPairIndex) Clone() *TagPairIndex {
+	clone := &TagPairIndex{
+		pairs:    idx.Pairs(),
+		byPlain:  make(map[string]TagPairs, len(idx.byPlain)),
+		byRandom: make(map[string]*TagPair, len(idx.byRandom)),
+	}
+	for plain, pairs := range idx.byPlain {
+		// Capped so that appending to them in clone copies them
+		clone.byPlain[plain] = pairs[:len(pairs):len(pairs)]
+	}
+	for random, pair := range idx.byRandom {
+		clone.byRandom[random] = pair
+	}
+	return clone
+}
+
+// How many Snapshots IndexTagPairs remembers
+const maxSnapshots = 16
+
+var (
+	snapshotsMu sync.Mutex
+	snapshots   []*TagPairIndex // Most recent last
+)
+
+// Snapshot returns the TagPairs in idx, like Pairs, and remembers
+// that they came from idx so that IndexTagPairs can return idx
+// rather than re-indexing them.  idx must not be changed afterward;
+// Clone it instead.
+func (idx *TagPairIndex) Snapshot() TagPairs {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+
+	for i, snap := range snapshots {
+		if snap == idx {
+			snapshots = append(snapshots[:i], snapshots[i+1:]...)
+			break
+		}
+	}
+	if len(snapshots) == maxSnapshots {
+		snapshots = snapshots[1:]
+	}
+	snapshots = append(snapshots, idx)
+
+	return idx.Pairs()
+}
+
+// IndexTagPairs returns a TagPairIndex containing pairs.  If pairs
+// were returned by a recent call to Snapshot, the TagPairIndex they
+// came from is returned, so that callers passed the same TagPairs
+// again and again (such as servers that keep them in memory) don't
+// re-index them every time.  The returned TagPairIndex must not be
+// changed.
+func IndexTagPairs(pairs TagPairs) *TagPairIndex {
+	if idx, ok := snapshotIndex(pairs); ok {
+		return idx
+	}
+	return NewTagPairIndex(pairs)
+}
+
+// snapshotIndex returns the TagPairIndex that pairs are a recent
+// Snapshot of, if any.
+func snapshotIndex(pairs TagPairs) (*TagPairIndex, bool) {
+	if len(pairs) == 0 {
+		return nil, false
+	}
+
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+
+	for _, snap := range snapshots {
+		if len(snap.pairs) == len(pairs) && &snap.pairs[0] == &pairs[0] {
+			return snap, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of TagPairs in idx.
+func (idx *TagPairIndex) Len() int {
+	return len(idx.pairs)
+}
+
+// ByRandom returns the TagPair with the RandomTag random, if any.
+func (idx *TagPairIndex) ByRandom(random string) (*TagPair, bool) {
+	pair, ok := idx.byRandom[random]
+	return pair, ok
+}
+
+// ByPlain returns the TagPairs with the PlainTag plain (usually just
+// one).
+func (idx *TagPairIndex) ByPlain(plain string) TagPairs {
+	return idx.byPlain[plain]
+}
+
+// Duplicates returns the TagPairs of each PlainTag that more than one
+// TagPair in idx has.
+func (idx *TagPairIndex) Duplicates() map[string]TagPairs {
+	dups := map[string]TagPairs{}
+	for plain, pairs := range idx.byPlain {
+		if len(pairs) > 1 {
+			dups[plain] = pairs
+		}
+	}
+	return dups
+}
+
+// HasRandom reports whether idx has a TagPair with the RandomTag
+// random.
+func (idx *TagPairIndex) HasRandom(random string) bool {
+	_, ok := idx.byRandom[random]
+	return ok
+}
+
+// WithAllPlainTags returns the first TagPair added to idx for each of
+// plaintags, or an error if any of them isn't in idx.
+func (idx *TagPairIndex) WithAllPlainTags(plaintags []string) (TagPairs, error) {
+	matches := make(TagPairs, 0, len(plaintags))
+	for _, plain := range plaintags {
+		pairs := idx.byPlain[plain]
+		if len(pairs) == 0 {
+			return nil, fmt.Errorf("PlainTag `%s` not found", plain)
+		}
+		matches = append(matches, pairs[0])
+	}
+	return matches, nil
+}
+
+// WithAllRandomTags returns the TagPair for each of randomtags, or an
+// error if any of them isn't in idx.
+func (idx *TagPairIndex) WithAllRandomTags(randomtags []string) (TagPairs, error) {
+	matches := make(TagPairs, 0, len(randomtags))
+	for _, random := range randomtags {
+		pair, ok := idx.byRandom[random]
+		if !ok {
+			return nil, fmt.Errorf("RandomTag `%s` not found", random)
+		}
+		matches = append(matches, pair)
+	}
+	return matches, nil
+}
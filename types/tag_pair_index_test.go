@@ -0,0 +1,224 @@
+// Steve Phillips / elimisteve
+// 2017.04.17
+
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const benchNumPairs = 50000
+
+func newTestTagPairs(n int) TagPairs {
+	pairs := make(TagPairs, n)
+	for i := range pairs {
+		pairs[i] = NewTagPair(nil, fmt.Sprintf("random%06d", i), nil,
+			fmt.Sprintf("plain%06d", i))
+	}
+	return pairs
+}
+
+func TestTagPairIndex(t *testing.T) {
+	pairs := newTestTagPairs(5)
+	dup := NewTagPair(nil, "randomdup", nil, "plain000002")
+	idx := NewTagPairIndex(append(pairs, dup, pairs[0]))
+
+	assert.Equal(t, 6, idx.Len())
+
+	matches, err := idx.WithAllPlainTags([]string{"plain000002", "plain000004"})
+	assert.Nil(t, err)
+	assert.Equal(t, TagPairs{pairs[2], pairs[4]}, matches)
+	assert.Equal(t, TagPairs{pairs[2], dup}, idx.ByPlain("plain000002"))
+
+	_, err = idx.WithAllRandomTags([]string{"random000001", "nope"})
+	assert.NotNil(t, err)
+
+	idx.Remove("random000002", "nope")
+
+	assert.Equal(t, 5, idx.Len())
+	assert.False(t, idx.HasRandom("random000002"))
+	assert.Equal(t, TagPairs{dup}, idx.ByPlain("plain000002"))
+
+	// Same answers as the unindexed TagPairs methods
+	matches, err = pairs.WithAllRandomTags([]string{"random000003"})
+	assert.Nil(t, err)
+	assert.Equal(t, TagPairs{pairs[3]}, matches)
+}
+
+func TestTagPairIndexClone(t *testing.T) {
+	pairs := newTestTagPairs(3)
+	idx := NewTagPairIndex(pairs)
+
+	clone := idx.Clone()
+	dup := NewTagPair(nil, "randomdup", nil, "plain000001")
+	clone.Add(dup, NewTagPair(nil, "randomnew", nil, "plainnew"))
+	clone.Remove("random000000")
+
+	assert.Equal(t, 4, clone.Len())
+	assert.Equal(t, TagPairs{pairs[1], dup}, clone.ByPlain("plain000001"))
+
+	// idx is unchanged
+	assert.Equal(t, pairs, idx.Pairs())
+	assert.Equal(t, TagPairs{pairs[1]}, idx.ByPlain("plain000001"))
+	assert.True(t, idx.HasRandom("random000000"))
+	assert.False(t, idx.HasRandom("randomnew"))
+}
+
+func TestIndexTagPairsSnapshot(t *testing.T) {
+	idx := NewTagPairIndex(newTestTagPairs(3))
+	snapshot := idx.Snapshot()
+
+	assert.True(t, IndexTagPairs(snapshot) == idx)
+
+	// Other TagPairs, even with the same contents, are indexed anew
+	same := append(TagPairs{}, snapshot...)
+	if other := IndexTagPairs(same); assert.False(t, other == idx) {
+		assert.Equal(t, idx.Pairs(), other.Pairs())
+	}
+	assert.False(t, IndexTagPairs(snapshot[:2]) == idx)
+	assert.Equal(t, 0, IndexTagPairs(nil).Len())
+
+	// Only the most recent Snapshots are remembered
+	for i := 0; i < maxSnapshots; i++ {
+		NewTagPairIndex(newTestTagPairs(1)).Snapshot()
+	}
+	assert.False(t, IndexTagPairs(snapshot) == idx)
+}
+
+func TestTagPairsDuplicates(t *testing.T) {
+	pairs := newTestTagPairs(3)
+	assert.Empty(t, pairs.Duplicates())
+
+	dup := NewTagPair(nil, "randomdup", nil, "plain000001")
+	pairs = append(pairs, dup, pairs[2])
+
+	assert.Equal(t, map[string]TagPairs{"plain000001": {pairs[1], dup}},
+		pairs.Duplicates())
+}
+
+// withAllPlainTagsScan is how TagPairs.WithAllPlainTags used to find
+// TagPairs: a scan of pairs per plain tag.
+func withAllPlainTagsScan(pairs TagPairs, plaintags []string) TagPairs {
+	var matches TagPairs
+	for _, plain := range plaintags {
+		for _, pair := range pairs {
+			if pair.plain == plain {
+				matches = append(matches, pair)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// setPlainTagsScan is how Row.SetPlainTags used to find TagPairs: a
+// scan of pairs per RandomTag.
+func setPlainTagsScan(row *Row, pairs TagPairs) {
+	row.plainTags = row.plainTags[:0]
+	for _, random := range row.RandomTags {
+		for _, pair := range pairs {
+			if pair.Random == random {
+				row.plainTags = append(row.plainTags, pair.plain)
+				break
+			}
+		}
+	}
+}
+
+// 20 plain tags spread across the pairs
+func benchPlainTags() []string {
+	var plaintags []string
+	for i := 0; i < benchNumPairs; i += benchNumPairs / 20 {
+		plaintags = append(plaintags, fmt.Sprintf("plain%06d", i+7))
+	}
+	return plaintags
+}
+
+// 1000 Rows with 5 RandomTags each
+func benchRows() Rows {
+	rows := make(Rows, 1000)
+	for i := range rows {
+		row := &Row{}
+		for j := 0; j < 5; j++ {
+			n := (i*7919 + j*104729) % benchNumPairs
+			row.RandomTags = append(row.RandomTags, fmt.Sprintf("random%06d", n))
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+func BenchmarkWithAllPlainTagsScan(b *testing.B) {
+	pairs := newTestTagPairs(benchNumPairs)
+	plaintags := benchPlainTags()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		withAllPlainTagsScan(pairs, plaintags)
+	}
+}
+
+func BenchmarkWithAllPlainTagsIndexed(b *testing.B) {
+	idx := NewTagPairIndex(newTestTagPairs(benchNumPairs))
+	plaintags := benchPlainTags()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := idx.WithAllPlainTags(plaintags); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// One-shot lookups without an index
+func BenchmarkWithAllPlainTagsOnePass(b *testing.B) {
+	pairs := newTestTagPairs(benchNumPairs)
+	plaintags := benchPlainTags()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := pairs.WithAllPlainTags(plaintags); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewTagPairIndex(b *testing.B) {
+	pairs := newTestTagPairs(benchNumPairs)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		NewTagPairIndex(pairs)
+	}
+}
+
+func BenchmarkSetPlainTags1000RowsScan(b *testing.B) {
+	pairs := newTestTagPairs(benchNumPairs)
+	rows := benchRows()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, row := range rows {
+			setPlainTagsScan(row, pairs)
+		}
+	}
+}
+
+// Like Rows.Populate does: index once, then look up each Row's tags
+func BenchmarkSetPlainTags1000RowsIndexed(b *testing.B) {
+	pairs := newTestTagPairs(benchNumPairs)
+	rows := benchRows()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		idx := rows.indexTagPairs(pairs)
+		for _, row := range rows {
+			if err := row.SetPlainTagsFromIndex(idx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
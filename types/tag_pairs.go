@@ -31,36 +31,69 @@ func (pairs TagPairs) AllRandom() []string {
 	return random
 }
 
+// HasRandom reports whether any pair in pairs has the RandomTag
+// random.
+func (pairs TagPairs) HasRandom(random string) bool {
+	for _, pair := range pairs {
+		if pair.Random == random {
+			return true
+		}
+	}
+	return false
+}
+
+// Duplicates returns the TagPairs of each PlainTag that more than one
+// TagPair in pairs has (e.g., because two clients created it at the
+// same time).
+func (pairs TagPairs) Duplicates() map[string]TagPairs {
+	return NewTagPairIndex(pairs).Duplicates()
+}
+
+// WithAllPlainTags returns the first TagPair in pairs for each of
+// plaintags, or an error if any of them isn't in pairs.  Takes one
+// pass over pairs; index pairs with NewTagPairIndex instead when doing
+// many lookups.
 func (pairs TagPairs) WithAllPlainTags(plaintags []string) (TagPairs, error) {
-	var matches TagPairs
+	found := make(map[string]*TagPair, len(plaintags))
 	for _, plain := range plaintags {
-		for i, pair := range pairs {
-			if pair.plain == plain {
-				matches = append(matches, pair)
-				break
-			}
-			// End of last loop, meaning no match was found
-			if i == len(pairs)-1 {
-				return nil, fmt.Errorf("PlainTag `%s` not found", plain)
-			}
+		found[plain] = nil
+	}
+	for _, pair := range pairs {
+		if match, wanted := found[pair.plain]; wanted && match == nil {
+			found[pair.plain] = pair
 		}
 	}
+
+	matches := make(TagPairs, 0, len(plaintags))
+	for _, plain := range plaintags {
+		if found[plain] == nil {
+			return nil, fmt.Errorf("PlainTag `%s` not found", plain)
+		}
+		matches = append(matches, found[plain])
+	}
 	return matches, nil
 }
 
+// WithAllRandomTags returns the TagPair in pairs for each of
+// randomtags, or an error if any of them isn't in pairs.  Takes one
+// pass over pairs.
 func (pairs TagPairs) WithAllRandomTags(randomtags []string) (TagPairs, error) {
-	var matches TagPairs
+	found := make(map[string]*TagPair, len(randomtags))
+	for _, random := range randomtags {
+		found[random] = nil
+	}
+	for _, pair := range pairs {
+		if match, wanted := found[pair.Random]; wanted && match == nil {
+			found[pair.Random] = pair
+		}
+	}
+
+	matches := make(TagPairs, 0, len(randomtags))
 	for _, random := range randomtags {
-		for i, pair := range pairs {
-			if pair.Random == random {
-				matches = append(matches, pair)
-				break
-			}
-			// End of last loop, meaning no match was found
-			if i == len(pairs)-1 {
-				return nil, fmt.Errorf("RandomTag `%s` not found", random)
-			}
+		if found[random] == nil {
+			return nil, fmt.Errorf("RandomTag `%s` not found", random)
 		}
+		matches = append(matches, found[random])
 	}
 	return matches, nil
 }
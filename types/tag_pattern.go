@@ -0,0 +1,147 @@
+// Steve Phillips / elimisteve
+// 2017.04.16
+
+package types
+
+import "strings"
+
+// Plain tags like "created:20170105092731", "when:20170301", and
+// "url:..." hold key:value data.  Besides exact matches, a plain tag
+// pattern can match such tags by prefix or by (lexicographic) range:
+//
+//	when:201703*         Tags starting with "when:201703"
+//	created:>=20170101   "created:" tags whose value is >= "20170101"
+//	created:<20170201    ...and likewise for <, <=, and >
+//
+// Values are compared as strings, so numbers should have the same
+// number of digits, as CrypTag's timestamps do.
+//
+// To match a tag that would otherwise be read as a pattern exactly,
+// escape the `*` or range operator with a backslash (see EscapeTag):
+//
+//	50\*                 Just the tag "50*"
+//	size:\>big           Just the tag "size:>big"
+
+type tagPattern struct {
+	prefix string // E.g., "when:201703" or "created:"
+	op     string // "*", ">=", "<=", ">", or "<"
+	value  string // Compared against the rest of the tag after prefix
+}
+
+// Range operators, longest first
+var tagRangeOps = []string{">=", "<=", ">", "<"}
+
+func parseTagPattern(plain string) (tagPattern, bool) {
+	if strings.HasSuffix(plain, "*") {
+		if strings.HasSuffix(plain, `\*`) {
+			return tagPattern{}, false
+		}
+		return tagPattern{prefix: plain[:len(plain)-1], op: "*"}, true
+	}
+
+	i := strings.Index(plain, ":")
+	if i == -1 {
+		return tagPattern{}, false
+	}
+
+	rest := plain[i+1:]
+	for _, op := range tagRangeOps {
+		if strings.HasPrefix(rest, op) {
+			return tagPattern{prefix: plain[:i+1], op: op, value: rest[len(op):]}, true
+		}
+	}
+
+	return tagPattern{}, false
+}
+
+// EscapeTag returns the pattern that matches just the plain tag
+// plain, escaping anything in it that would make it a prefix or range
+// pattern.
+func EscapeTag(plain string) string {
+	if strings.HasSuffix(plain, "*") {
+		plain = plain[:len(plain)-1] + `\*`
+	}
+	if i := strings.Index(plain, ":"); i != -1 && len(plain) > i+1 &&
+		(plain[i+1] == '<' || plain[i+1] == '>') {
+		plain = plain[:i+1] + `\` + plain[i+1:]
+	}
+	return plain
+}
+
+// UnescapeTag returns the plain tag that pattern, which must not be a
+// prefix or range pattern (see IsTagPattern), matches; the reverse of
+// EscapeTag.
+func UnescapeTag(pattern string) string {
+	if strings.HasSuffix(pattern, `\*`) {
+		pattern = pattern[:len(pattern)-2] + "*"
+	}
+	if i := strings.Index(pattern, ":"); i != -1 && len(pattern) > i+2 &&
+		pattern[i+1] == '\\' && (pattern[i+2] == '<' || pattern[i+2] == '>') {
+		pattern = pattern[:i+1] + pattern[i+2:]
+	}
+	return pattern
+}
+
+// UnescapeTags is UnescapeTag for each of patterns.
+func UnescapeTags(patterns []string) []string {
+	plaintags := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		plaintags[i] = UnescapeTag(pattern)
+	}
+	return plaintags
+}
+
+func (p tagPattern) matches(plain string) bool {
+	if !strings.HasPrefix(plain, p.prefix) {
+		return false
+	}
+
+	rest := plain[len(p.prefix):]
+
+	switch p.op {
+	case "*":
+		return true
+	case ">=":
+		return rest >= p.value
+	case "<=":
+		return rest <= p.value
+	case ">":
+		return rest > p.value
+	case "<":
+		return rest < p.value
+	}
+	return false
+}
+
+// IsTagPattern reports whether plain is a prefix or range pattern
+// rather than a plain tag (possibly escaped; see UnescapeTag) to be
+// matched exactly.
+func IsTagPattern(plain string) bool {
+	_, ok := parseTagPattern(plain)
+	return ok
+}
+
+// MatchesTag reports whether the plain tag plain matches pattern,
+// which is either a prefix or range pattern or a plain tag to be
+// matched exactly.
+func MatchesTag(pattern, plain string) bool {
+	if p, ok := parseTagPattern(pattern); ok {
+		return p.matches(plain)
+	}
+	return UnescapeTag(pattern) == plain
+}
+
+// WithPlainPattern returns the TagPairs in pairs whose PlainTags
+// match pattern (see MatchesTag).
+func (pairs TagPairs) WithPlainPattern(pattern string) TagPairs {
+	p, isPattern := parseTagPattern(pattern)
+	plain := UnescapeTag(pattern)
+
+	var matches TagPairs
+	for _, pair := range pairs {
+		if (isPattern && p.matches(pair.plain)) || (!isPattern && pair.plain == plain) {
+			matches = append(matches, pair)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,70 @@
+// Steve Phillips / elimisteve
+// 2017.04.27
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesTag(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{"when:201703*", []string{"when:201703", "when:20170301"},
+			[]string{"when:201704", "when:2017"}},
+		{"created:>=20170101", []string{"created:20170101", "created:20170201"},
+			[]string{"created:20161231", "updated:20170201"}},
+		{"created:<20170201", []string{"created:20170131"},
+			[]string{"created:20170201"}},
+		{"size:big", []string{"size:big"}, []string{"size:bigger"}},
+
+		// Escaped, so matched exactly
+		{`50\*`, []string{"50*"}, []string{"50", "500", `50\*`}},
+		{`size:\>big`, []string{"size:>big"}, []string{"size:big", "size:zzz"}},
+		{`a:\<b\*`, []string{"a:<b*"}, []string{"a:<bc", "a:a"}},
+	}
+
+	for _, test := range tests {
+		for _, plain := range test.matches {
+			assert.True(t, MatchesTag(test.pattern, plain), "%s should match %s",
+				test.pattern, plain)
+		}
+		for _, plain := range test.misses {
+			assert.False(t, MatchesTag(test.pattern, plain), "%s shouldn't match %s",
+				test.pattern, plain)
+		}
+	}
+}
+
+func TestEscapeTag(t *testing.T) {
+	for _, plain := range []string{"50*", "url:50%off", "size:>big", "size:<small",
+		"a:>b*", "all", "*", "x:y:>z", ""} {
+		escaped := EscapeTag(plain)
+		assert.False(t, IsTagPattern(escaped), "%s escaped to %s", plain, escaped)
+		assert.Equal(t, plain, UnescapeTag(escaped))
+		assert.True(t, MatchesTag(escaped, plain))
+	}
+
+	assert.Equal(t, "all", EscapeTag("all"))
+	assert.Equal(t, `size:\>big`, EscapeTag("size:>big"))
+	assert.True(t, IsTagPattern("size:>big"))
+	assert.True(t, IsTagPattern("50*"))
+}
+
+func TestWithPlainPattern(t *testing.T) {
+	pairs := TagPairs{
+		NewTagPair(nil, "r1", nil, "when:20170301"),
+		NewTagPair(nil, "r2", nil, "when:20170401"),
+		NewTagPair(nil, "r3", nil, "50*"),
+		NewTagPair(nil, "r4", nil, "500"),
+	}
+
+	assert.Equal(t, []string{"r1"}, pairs.WithPlainPattern("when:201703*").AllRandom())
+	assert.Equal(t, []string{"r3", "r4"}, pairs.WithPlainPattern("50*").AllRandom())
+	assert.Equal(t, []string{"r3"}, pairs.WithPlainPattern(`50\*`).AllRandom())
+}